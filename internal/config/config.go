@@ -6,21 +6,45 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
 	"spark-heimdall/internal/device"
+	"spark-heimdall/internal/viewer"
 	"strconv"
+	"sync"
 )
 
+// defaultAutoStartTimeout is the default number of seconds auto-start waits
+// for a device to become reachable
+const defaultAutoStartTimeout = 120
+
+// ErrConflict is returned when an update expects a revision the configuration
+// no longer has
+var ErrConflict = errors.New("configuration was changed by someone else")
+
 // Manager defines the interface for configuration operations
 type Manager interface {
 	load() error
 	save() error
 	Validate() error
-	AddDevice(d device.Device) error
+	AddDevice(d device.Device) (device.Device, error)
 	UpdateDevice(d device.Device) error
-	DeleteDevice(id string) error
+	PatchDevice(id string, patch []byte, revision int64) (device.Device, error)
+	DeleteDevice(id string, revision int64) error
 	GetDevice(id string) (device.Device, bool)
+	AddGroup(g device.Group) (device.Group, error)
+	UpdateGroup(g device.Group) error
+	DeleteGroup(id string) error
+	ReorderDevices(ids []string) error
+	SetFavorite(id string, favorite bool) error
+	ApplyDeviceOperations(ops []device.Operation) ([]device.OperationResult, error)
+	AddTemplate(t device.Template) (device.Template, error)
+	UpdateTemplate(t device.Template) error
+	DeleteTemplate(id string) error
+	AddDeviceFromTemplate(templateID string, overrides []byte) (device.Device, error)
+	CloneDevice(id string, overrides []byte) (device.Device, error)
 	Update(config UpdateConfig) error
 }
 
@@ -31,20 +55,95 @@ type UpdateConfig struct {
 	VncViewer       string `json:"vnc_viewer"`
 	VncPasswordFile string `json:"vnc_password_file"`
 	RdpViewer       string `json:"rdp_viewer"`
+
+	ThumbnailInterval int  `json:"thumbnail_interval"`
+	RestoreSession    bool `json:"restore_session"`
+
+	AutoStartFallbackIDs []string `json:"auto_start_fallback_ids"`
+	AutoStartTimeout     int      `json:"auto_start_timeout"`
+
+	DiscoveryCIDR string `json:"discovery_cidr"`
+
+	// Force saves viewer paths even if they cannot be found
+	Force bool `json:"force"`
+	// Revision, if set, is the revision the configuration must have for the
+	// update to succeed
+	Revision int64 `json:"revision,omitempty"`
 }
 
 // Ensure Config implements Manager
 var _ Manager = (*Config)(nil)
 
 func (c *Config) Update(config UpdateConfig) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if config.Revision != 0 && config.Revision != c.Revision {
+		return ErrConflict
+	}
+
+	// An empty VNC viewer falls back to the default in Validate, an empty
+	// RDP viewer to the one detected at load time
+	if !config.Force && config.VncViewer != "" {
+		if err := viewer.Check(config.VncViewer); err != nil {
+			return fmt.Errorf("invalid VNC viewer: %w", err)
+		}
+	}
+	if !config.Force && config.RdpViewer != "" {
+		if err := viewer.Check(config.RdpViewer); err != nil {
+			return fmt.Errorf("invalid RDP viewer: %w", err)
+		}
+	}
+	if config.DiscoveryCIDR != "" {
+		if _, err := netip.ParsePrefix(config.DiscoveryCIDR); err != nil {
+			return fmt.Errorf("invalid discovery range: %w", err)
+		}
+	}
+
+	// If saving fails the settings are restored, so memory and disk stay
+	// the same
+	previous, revision := c.updateConfig(), c.Revision
+	c.set(config)
+	c.Revision++
+
+	if err := c.save(); err != nil {
+		c.set(previous)
+		c.Revision = revision
+		return err
+	}
+	return nil
+}
+
+// updateConfig returns the settings Update changes
+func (c *Config) updateConfig() UpdateConfig {
+	return UpdateConfig{
+		ListenPort:           c.ListenPort,
+		AutoStart:            c.AutoStart,
+		AutoStartID:          c.AutoStartID,
+		VncViewer:            c.VncViewer,
+		VncPasswordFile:      c.VncPasswordFile,
+		RdpViewer:            c.RdpViewer,
+		ThumbnailInterval:    c.ThumbnailInterval,
+		RestoreSession:       c.RestoreSession,
+		AutoStartFallbackIDs: c.AutoStartFallbackIDs,
+		AutoStartTimeout:     c.AutoStartTimeout,
+		DiscoveryCIDR:        c.DiscoveryCIDR,
+	}
+}
+
+// set changes the settings of an UpdateConfig
+func (c *Config) set(config UpdateConfig) {
 	c.ListenPort = config.ListenPort
 	c.AutoStart = config.AutoStart
 	c.AutoStartID = config.AutoStartID
 	c.VncViewer = config.VncViewer
 	c.VncPasswordFile = config.VncPasswordFile
 	c.RdpViewer = config.RdpViewer
-
-	return c.save()
+	c.ThumbnailInterval = config.ThumbnailInterval
+	c.RestoreSession = config.RestoreSession
+	c.AutoStartFallbackIDs = config.AutoStartFallbackIDs
+	c.AutoStartTimeout = config.AutoStartTimeout
+	c.DiscoveryCIDR = config.DiscoveryCIDR
 }
 
 // Config holds the application configuration
@@ -56,24 +155,154 @@ type Config struct {
 
 	AutoStart   bool   `json:"auto_start"`
 	AutoStartID string `json:"auto_start_id"`
+	// AutoStartFallbackIDs are tried in order while AutoStartID is unreachable
+	AutoStartFallbackIDs []string `json:"auto_start_fallback_ids,omitempty"`
+	// AutoStartTimeout is how many seconds auto-start waits for a device
+	// to become reachable
+	AutoStartTimeout int `json:"auto_start_timeout"`
+	// RestoreSession reconnects the devices that were active before a
+	// restart, falling back to AutoStartID when nothing was recorded
+	RestoreSession bool `json:"restore_session"`
 
 	VncViewer       string `json:"vnc_viewer"`
 	VncPasswordFile string `json:"vnc_password_file"`
 	RdpViewer       string `json:"rdp_viewer"`
 
-	HighestDeviceId string `json:"-"`
-	device.Store
+	// ThumbnailInterval is the number of seconds between VNC thumbnail
+	// refreshes, 0 disables thumbnails
+	ThumbnailInterval int `json:"thumbnail_interval"`
+
+	// DiscoveryCIDR is the network range scanned for new devices, e.g.
+	// "192.168.1.0/24". When empty the local networks are scanned.
+	DiscoveryCIDR string `json:"discovery_cidr,omitempty"`
+
+	// Revision is increased on every change of the settings above
+	Revision int64 `json:"revision"`
+	// RequireIfMatch rejects device and settings changes through the API
+	// that don't name the revision they are based on. Disable it for
+	// clients written before revisions existed.
+	RequireIfMatch bool `json:"require_if_match"`
+
+	// Store is the single source of truth for devices. It is persisted as
+	// the "devices" array of the configuration file.
+	Store *device.Store `json:"-"`
+
+	// detectedRdpViewer is the RDP client found on PATH at load time, used
+	// while RdpViewer is empty. It is not saved.
+	detectedRdpViewer string
+
+	// lock serialises modifications and writes of the configuration file
+	lock sync.Mutex
+}
+
+// Settings is a copy of the settings of a Config, see Config.Settings
+type Settings struct {
+	ListenPort           int
+	AutoStart            bool
+	AutoStartID          string
+	AutoStartFallbackIDs []string
+	AutoStartTimeout     int
+	RestoreSession       bool
+	VncViewer            string
+	VncPasswordFile      string
+	// RdpViewer is the configured RDP viewer, empty to use DetectedRdpViewer
+	RdpViewer         string
+	DetectedRdpViewer string
+	ThumbnailInterval int
+	DiscoveryCIDR     string
+	Revision          int64
+	RequireIfMatch    bool
+}
+
+// RdpViewerPath returns the RDP viewer to run, the configured or the
+// detected one
+func (s Settings) RdpViewerPath() string {
+	if s.RdpViewer != "" {
+		return s.RdpViewer
+	}
+	return s.DetectedRdpViewer
+}
+
+// Settings returns a copy of the current settings, which may be read while
+// Update changes them
+func (c *Config) Settings() Settings {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return Settings{
+		ListenPort:           c.ListenPort,
+		AutoStart:            c.AutoStart,
+		AutoStartID:          c.AutoStartID,
+		AutoStartFallbackIDs: slices.Clone(c.AutoStartFallbackIDs),
+		AutoStartTimeout:     c.AutoStartTimeout,
+		RestoreSession:       c.RestoreSession,
+		VncViewer:            c.VncViewer,
+		VncPasswordFile:      c.VncPasswordFile,
+		RdpViewer:            c.RdpViewer,
+		DetectedRdpViewer:    c.detectedRdpViewer,
+		ThumbnailInterval:    c.ThumbnailInterval,
+		DiscoveryCIDR:        c.DiscoveryCIDR,
+		Revision:             c.Revision,
+		RequireIfMatch:       c.RequireIfMatch,
+	}
+}
+
+// configJSON is the on-disk representation of Config
+type configJSON struct {
+	*configAlias
+	Groups    device.Groups    `json:"groups,omitempty"`
+	Templates device.Templates `json:"templates,omitempty"`
+	Devices   device.Devices   `json:"devices"`
+}
+
+// configAlias has Config's fields without its JSON methods
+type configAlias Config
+
+func (c *Config) MarshalJSON() ([]byte, error) {
+	return json.Marshal(configJSON{
+		configAlias: (*configAlias)(c),
+		Groups:      c.Store.GetGroups(),
+		Templates:   c.Store.GetTemplates(),
+		Devices:     c.Store.GetAll(),
+	})
+}
+
+func (c *Config) UnmarshalJSON(data []byte) error {
+	aux := configJSON{configAlias: (*configAlias)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if aux.Devices == nil {
+		aux.Devices = device.Devices{}
+	}
+	if c.Store == nil {
+		c.Store = device.NewStore(nil)
+	}
+	c.Store.Replace(aux.Devices, aux.Groups, aux.Templates)
+
+	return nil
 }
 
 func NewConfig(path string, vncPasswdFile string) *Config {
 	return &Config{
 		FilePath:        path,
 		ListenPort:      8080,
-		Store:           device.Store{Devices: []device.Device{}},
+		Store:           device.NewStore(device.Devices{}),
 		VncPasswordFile: vncPasswdFile,
+
+		ThumbnailInterval: 60,
+		AutoStartTimeout:  defaultAutoStartTimeout,
+		RequireIfMatch:    true,
 	}
 }
 
+// StatePath returns the location of the session state file, which is kept
+// next to the configuration file
+func (c *Config) StatePath() string {
+	return filepath.Join(filepath.Dir(c.FilePath), "heimdall-state.json")
+}
+
 // LoadConfigFromFlags loads configuration from flags or environment variables
 func LoadConfigFromFlags() (*Config, error) {
 	configFilePtr := flag.String("config", getEnvString("HEIMDALL_CONFIG", "config.json"), "Path to configuration file")
@@ -137,6 +366,12 @@ func getEnvInt(key string, fallback int) int {
 }
 
 func (c *Config) load() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Probing PATH once keeps Validate and save free of side effects
+	c.detectedRdpViewer = viewer.DetectRDP()
+
 	// Check if file exists
 	_, err := os.Stat(c.FilePath)
 	if os.IsNotExist(err) {
@@ -197,8 +432,10 @@ func (c *Config) Validate() error {
 		return errors.New("listen address must be a valid port number")
 	}
 
+	devices := c.Store.GetAll()
+
 	deviceIdMap := make(map[string]bool)
-	for _, pc := range c.Store.Devices {
+	for _, pc := range devices {
 		if deviceIdMap[pc.ID] {
 			return fmt.Errorf("duplicate PC ID: %s", pc.ID)
 		}
@@ -208,7 +445,7 @@ func (c *Config) Validate() error {
 	// Verify AutoStartID references a valid PC
 	if c.AutoStart && c.AutoStartID != "" {
 		valid := false
-		for _, pc := range c.Store.Devices {
+		for _, pc := range devices {
 			if pc.ID == c.AutoStartID {
 				valid = true
 				break
@@ -219,6 +456,24 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	for _, id := range c.AutoStartFallbackIDs {
+		if !deviceIdMap[id] {
+			return fmt.Errorf("auto start fallback ID %s does not reference a valid PC", id)
+		}
+	}
+
+	if c.Revision <= 0 {
+		c.Revision = 1
+	}
+
+	if c.AutoStartTimeout <= 0 {
+		c.AutoStartTimeout = defaultAutoStartTimeout
+	}
+
+	if c.ThumbnailInterval < 0 {
+		return errors.New("thumbnail interval must not be negative")
+	}
+
 	if c.VncViewer == "" {
 		c.VncViewer = "vncviewer"
 	}
@@ -227,23 +482,27 @@ func (c *Config) Validate() error {
 		c.VncPasswordFile = fmt.Sprintf("%s/.vnc/passwd", getUserHomeDir())
 	}
 
-	if c.RdpViewer == "" {
-		c.RdpViewer = "" // TODO: Figure out a good default rdp viewer
-	}
-
 	return nil
 }
 
-func (c *Config) AddDevice(device device.Device) error {
-	err := c.Store.Add(device)
+// AddDevice stores a new device and returns it with its generated fields set
+func (c *Config) AddDevice(d device.Device) (device.Device, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	d, err := c.Store.Add(d)
 	if err != nil {
-		return err
+		return device.Device{}, err
 	}
-	log.Printf("Added new device: (%s) %s", device.ID, device.Name)
-	return c.save()
+	log.Printf("Added new device: (%s) %s", d.ID, d.Name)
+	return d, c.save()
 }
 
+// UpdateDevice replaces a device, see device.Store.Update
 func (c *Config) UpdateDevice(d device.Device) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	err := c.Store.Update(d)
 	if err != nil {
 		return err
@@ -252,18 +511,91 @@ func (c *Config) UpdateDevice(d device.Device) error {
 	return c.save()
 }
 
-func (c *Config) DeleteDevice(id string) error {
-	err := c.Store.Delete(id)
+// PatchDevice applies a JSON merge patch to the device, see device.MergePatch
+func (c *Config) PatchDevice(id string, patch []byte, revision int64) (device.Device, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	d, err := c.Store.Patch(id, patch, revision)
+	if err != nil {
+		return device.Device{}, err
+	}
+
+	return d, c.save()
+}
+
+func (c *Config) DeleteDevice(id string, revision int64) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	err := c.Store.Delete(id, revision)
 	if err != nil {
 		return err
 	}
 
-	// Update references
+	c.forgetDevice(id)
+
+	return c.save()
+}
+
+// forgetDevice removes references to a deleted device
+func (c *Config) forgetDevice(id string) {
 	if c.AutoStartID == id {
 		c.AutoStartID = ""
 	}
+	c.AutoStartFallbackIDs = slices.DeleteFunc(c.AutoStartFallbackIDs, func(fallback string) bool {
+		return fallback == id
+	})
+}
 
-	return c.save()
+// ApplyDeviceOperations applies the operations all-or-nothing, see
+// device.Store.Apply, and saves the config once. If saving fails the
+// changes are undone, so memory and disk stay the same.
+func (c *Config) ApplyDeviceOperations(ops []device.Operation) ([]device.OperationResult, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	devices, groups, templates := c.Store.GetAll(), c.Store.GetGroups(), c.Store.GetTemplates()
+	autoStartID, fallbackIDs := c.AutoStartID, slices.Clone(c.AutoStartFallbackIDs)
+
+	results, err := c.Store.Apply(ops)
+	if err != nil {
+		return results, err
+	}
+
+	for _, result := range results {
+		if result.Op == device.OpDelete {
+			c.forgetDevice(result.ID)
+		}
+	}
+
+	if err := c.save(); err != nil {
+		c.Store.Replace(devices, groups, templates)
+		c.AutoStartID, c.AutoStartFallbackIDs = autoStartID, fallbackIDs
+		return results, err
+	}
+	log.Printf("Applied %d device operations", len(ops))
+
+	return results, nil
+}
+
+// EditDevices runs edit on a copy of the store, see device.Store.Copy, and
+// then saves the copy's devices with a single write, e.g. for imports. If
+// saving fails the devices are left as they were.
+func (c *Config) EditDevices(edit func(work *device.Store)) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	work := c.Store.Copy()
+	edit(work)
+
+	devices, groups, templates := c.Store.GetAll(), c.Store.GetGroups(), c.Store.GetTemplates()
+	c.Store.Replace(work.GetAll(), groups, templates)
+	if err := c.save(); err != nil {
+		c.Store.Replace(devices, groups, templates)
+		return err
+	}
+	return nil
 }
 
 func (c *Config) GetDevice(id string) (d device.Device, found bool) {
@@ -273,3 +605,129 @@ func (c *Config) GetDevice(id string) (d device.Device, found bool) {
 
 	return d, false
 }
+
+func (c *Config) AddGroup(g device.Group) (device.Group, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	g, err := c.Store.AddGroup(g)
+	if err != nil {
+		return g, err
+	}
+	log.Printf("Added new group: (%s) %s", g.ID, g.Name)
+	return g, c.save()
+}
+
+func (c *Config) UpdateGroup(g device.Group) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	err := c.Store.UpdateGroup(g)
+	if err != nil {
+		return err
+	}
+
+	return c.save()
+}
+
+// DeleteGroup removes a group, moving its subgroups and devices to its parent
+func (c *Config) DeleteGroup(id string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	err := c.Store.DeleteGroup(id)
+	if err != nil {
+		return err
+	}
+
+	return c.save()
+}
+
+func (c *Config) AddTemplate(t device.Template) (device.Template, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t, err := c.Store.AddTemplate(t)
+	if err != nil {
+		return t, err
+	}
+	log.Printf("Added new template: (%s) %s", t.ID, t.Name)
+	return t, c.save()
+}
+
+func (c *Config) UpdateTemplate(t device.Template) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	err := c.Store.UpdateTemplate(t)
+	if err != nil {
+		return err
+	}
+
+	return c.save()
+}
+
+func (c *Config) DeleteTemplate(id string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	err := c.Store.DeleteTemplate(id)
+	if err != nil {
+		return err
+	}
+
+	return c.save()
+}
+
+// AddDeviceFromTemplate creates a device from a template, see
+// device.Store.AddFromTemplate
+func (c *Config) AddDeviceFromTemplate(templateID string, overrides []byte) (device.Device, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	d, err := c.Store.AddFromTemplate(templateID, overrides)
+	if err != nil {
+		return device.Device{}, err
+	}
+	log.Printf("Added new device from template %s: (%s) %s", templateID, d.ID, d.Name)
+	return d, c.save()
+}
+
+// CloneDevice adds a copy of a device, see device.Store.Clone
+func (c *Config) CloneDevice(id string, overrides []byte) (device.Device, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	d, err := c.Store.Clone(id, overrides)
+	if err != nil {
+		return device.Device{}, err
+	}
+	log.Printf("Cloned device %s: (%s) %s", id, d.ID, d.Name)
+	return d, c.save()
+}
+
+// ReorderDevices rearranges devices in the custom order, see device.Store.Reorder
+func (c *Config) ReorderDevices(ids []string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	err := c.Store.Reorder(ids)
+	if err != nil {
+		return err
+	}
+
+	return c.save()
+}
+
+// SetFavorite marks or unmarks a device as favorite
+func (c *Config) SetFavorite(id string, favorite bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	err := c.Store.SetFavorite(id, favorite)
+	if err != nil {
+		return err
+	}
+
+	return c.save()
+}
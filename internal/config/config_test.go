@@ -0,0 +1,167 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"spark-heimdall/internal/device"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestConfig(t *testing.T) *Config {
+	t.Helper()
+
+	c := NewConfig(filepath.Join(t.TempDir(), "config.json"), "")
+	if err := c.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	return c
+}
+
+func TestConfigConcurrentDeviceChanges(t *testing.T) {
+	c := newTestConfig(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			id := fmt.Sprintf("dev%d", i)
+			if _, err := c.AddDevice(device.Device{ID: id, Name: id, IPAddress: "10.0.0.1", Protocol: "vnc"}); err != nil {
+				t.Errorf("AddDevice(%s): %v", id, err)
+				return
+			}
+			if err := c.UpdateDevice(device.Device{ID: id, Name: id + "-updated", IPAddress: "10.0.0.1", Protocol: "vnc"}); err != nil {
+				t.Errorf("UpdateDevice(%s): %v", id, err)
+			}
+			if _, found := c.GetDevice(id); !found {
+				t.Errorf("GetDevice(%s): not found", id)
+			}
+			if i%2 == 1 {
+				if err := c.DeleteDevice(id, 0); err != nil {
+					t.Errorf("DeleteDevice(%s): %v", id, err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// The file on disk must match the store after all writes
+	reloaded := NewConfig(c.FilePath, "")
+	if err := reloaded.load(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	devices := reloaded.Store.GetAll()
+	if len(devices) != 5 {
+		t.Fatalf("expected 5 devices after reload, got %d", len(devices))
+	}
+	for _, d := range devices {
+		if d.Name != d.ID+"-updated" {
+			t.Errorf("device %s was not saved with its update: %q", d.ID, d.Name)
+		}
+	}
+}
+
+func TestConfigRoundTripsDevices(t *testing.T) {
+	c := newTestConfig(t)
+
+	if _, err := c.AddDevice(device.Device{ID: "pc1", Name: "Desk", IPAddress: "10.0.0.1", Protocol: "vnc"}); err != nil {
+		t.Fatalf("AddDevice: %v", err)
+	}
+	if _, err := c.AddTemplate(device.Template{Name: "Kiosk", Device: device.Device{Protocol: "vnc", FullScreen: device.Bool(true)}}); err != nil {
+		t.Fatalf("AddTemplate: %v", err)
+	}
+
+	reloaded := NewConfig(c.FilePath, "")
+	if err := reloaded.load(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	d, found := reloaded.GetDevice("pc1")
+	if !found || d.IPAddress != "10.0.0.1" {
+		t.Fatalf("device not persisted: %+v (found %v)", d, found)
+	}
+	if tpl, found := reloaded.Store.GetTemplate("template1"); !found || !tpl.Device.IsFullScreen() {
+		t.Fatalf("template not persisted: %+v (found %v)", tpl, found)
+	}
+}
+
+func TestConfigRevision(t *testing.T) {
+	c := newTestConfig(t)
+	if c.Revision != 1 || !c.RequireIfMatch {
+		t.Fatalf("new config: revision %d, require if-match %v", c.Revision, c.RequireIfMatch)
+	}
+
+	if err := c.Update(UpdateConfig{ListenPort: 9090, Force: true, Revision: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if c.Revision != 2 {
+		t.Errorf("revision after update = %d", c.Revision)
+	}
+
+	err := c.Update(UpdateConfig{ListenPort: 9191, Force: true, Revision: 1})
+	if !errors.Is(err, ErrConflict) || c.ListenPort != 9090 {
+		t.Errorf("stale update: %v, port %d", err, c.ListenPort)
+	}
+}
+
+func TestConfigUpdateSaveFailure(t *testing.T) {
+	c := newTestConfig(t)
+	if err := c.Update(UpdateConfig{ListenPort: 9090, DiscoveryCIDR: "10.0.0.0/24", Force: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Invalid settings are caught by the validation in save
+	err := c.Update(UpdateConfig{ListenPort: 9191, AutoStart: true, AutoStartID: "missing", Force: true, Revision: 2})
+	if err == nil {
+		t.Fatal("expected the update to fail")
+	}
+	if c.Revision != 2 || c.ListenPort != 9090 || c.AutoStart || c.DiscoveryCIDR != "10.0.0.0/24" {
+		t.Errorf("failed update changed the config: revision %d, port %d, auto start %v, range %q", c.Revision, c.ListenPort, c.AutoStart, c.DiscoveryCIDR)
+	}
+
+	// As are write errors
+	if err := os.Remove(c.FilePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(c.FilePath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Update(UpdateConfig{ListenPort: 9191, Force: true, Revision: 2}); err == nil {
+		t.Fatal("expected the update to fail")
+	}
+	if c.Revision != 2 || c.ListenPort != 9090 {
+		t.Errorf("failed write changed the config: revision %d, port %d", c.Revision, c.ListenPort)
+	}
+}
+
+func TestConfigDetectsRDPViewerWithoutSavingIt(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "xfreerdp"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	c := newTestConfig(t)
+	if err := c.Update(UpdateConfig{ListenPort: 8080, Force: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := c.Settings()
+	if settings.RdpViewer != "" || settings.RdpViewerPath() != "xfreerdp" {
+		t.Errorf("RDP viewer = %q, path %q", settings.RdpViewer, settings.RdpViewerPath())
+	}
+
+	data, err := os.ReadFile(c.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "xfreerdp") {
+		t.Errorf("detected viewer was saved: %s", data)
+	}
+}
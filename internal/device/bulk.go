@@ -0,0 +1,169 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Bulk operation kinds
+const (
+	OpAdd    = "add"
+	OpUpdate = "update"
+	OpDelete = "delete"
+	OpPatch  = "patch"
+)
+
+// MaxOperations limits the number of operations of one bulk request
+const MaxOperations = 1000
+
+// Operation is one change of a bulk request
+type Operation struct {
+	Op string `json:"op"`
+	// ID selects the device to update, delete or patch. Updates may give
+	// it in Device instead.
+	ID string `json:"id,omitempty"`
+	// Device is the device to add, or the replacement for update
+	Device *Device `json:"device,omitempty"`
+	// Patch is a JSON merge patch for the device, e.g. {"full_screen": true}
+	Patch json.RawMessage `json:"patch,omitempty"`
+	// AddTags and RemoveTags change the tags of a patched device without
+	// replacing them
+	AddTags    []string `json:"add_tags,omitempty"`
+	RemoveTags []string `json:"remove_tags,omitempty"`
+	// Revision, if set, is the revision the device must have for an update,
+	// delete or patch to succeed
+	Revision int64 `json:"revision,omitempty"`
+}
+
+// OperationResult reports the outcome of one operation
+type OperationResult struct {
+	Index int    `json:"index"`
+	Op    string `json:"op"`
+	ID    string `json:"id,omitempty"`
+	// Device is the device after an add, update or patch
+	Device *Device      `json:"device,omitempty"`
+	Error  string       `json:"error,omitempty"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// ErrBulkFailed is returned when an operation of a bulk request fails
+var ErrBulkFailed = errors.New("bulk operation failed, no changes were made")
+
+// Apply runs the operations in order and keeps their changes only if all of
+// them succeed. Every operation is attempted so that all errors are
+// reported; on failure ErrBulkFailed is returned with the results.
+func (m *Store) Apply(ops []Operation) ([]OperationResult, error) {
+	if len(ops) > MaxOperations {
+		return nil, fmt.Errorf("at most %d operations are allowed", MaxOperations)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	// Operations run on a copy, which replaces the devices at the end
+	work := &Store{devices: slices.Clone(m.devices), groups: m.groups}
+
+	results := make([]OperationResult, len(ops))
+	failed := false
+	for i, op := range ops {
+		results[i] = work.apply(op)
+		results[i].Index = i
+		failed = failed || results[i].Error != ""
+	}
+
+	if failed {
+		return results, ErrBulkFailed
+	}
+
+	m.devices = work.devices
+	return results, nil
+}
+
+// apply runs one operation
+func (m *Store) apply(op Operation) OperationResult {
+	result := OperationResult{Op: op.Op, ID: op.ID}
+
+	var d Device
+	var err error
+	switch op.Op {
+	case OpAdd:
+		if op.Device == nil {
+			return result.failed(errors.New("add needs a device"))
+		}
+		d, err = m.Add(*op.Device)
+	case OpUpdate:
+		if op.Device == nil {
+			return result.failed(errors.New("update needs a device"))
+		}
+		d = *op.Device
+		if op.ID != "" && d.ID != "" && d.ID != op.ID {
+			return result.failed(fmt.Errorf("device ID %s does not match %s", d.ID, op.ID))
+		}
+		if d.ID == "" {
+			d.ID = op.ID
+		}
+		d.Revision = op.Revision
+		err = m.Update(d)
+	case OpDelete:
+		err = m.Delete(op.ID, op.Revision)
+	case OpPatch:
+		d, err = m.patch(op)
+	default:
+		err = fmt.Errorf("unknown operation %q, expected %s, %s, %s or %s", op.Op, OpAdd, OpUpdate, OpDelete, OpPatch)
+	}
+	if err != nil {
+		return result.failed(err)
+	}
+
+	if op.Op != OpDelete {
+		stored, _ := m.Get(d.ID)
+		result.ID = stored.ID
+		result.Device = &stored
+	}
+	return result
+}
+
+// patch sets the fields of op.Patch and changes the tags of a device
+func (m *Store) patch(op Operation) (Device, error) {
+	d, found := m.Get(op.ID)
+	if !found {
+		return Device{}, fmt.Errorf("PC with ID %s not found", op.ID)
+	}
+	if err := checkRevision(d, op.Revision); err != nil {
+		return Device{}, err
+	}
+
+	if len(op.Patch) > 0 {
+		var err error
+		if d, err = MergePatch(d, op.Patch); err != nil {
+			return Device{}, err
+		}
+	} else {
+		// Adding tags must not modify the array shared with the stored device
+		d.Tags = slices.Clone(d.Tags)
+	}
+
+	for _, tag := range op.AddTags {
+		if !d.HasTag(tag) {
+			d.Tags = append(d.Tags, tag)
+		}
+	}
+	for _, tag := range op.RemoveTags {
+		d.Tags = slices.DeleteFunc(d.Tags, func(t string) bool { return strings.EqualFold(t, tag) })
+	}
+
+	return d, m.Update(d)
+}
+
+// failed records err, including the invalid fields of a validation error
+func (r OperationResult) failed(err error) OperationResult {
+	r.Error = err.Error()
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		r.Fields = validationErr.Fields
+	}
+	return r
+}
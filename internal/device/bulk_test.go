@@ -0,0 +1,86 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func bulkTestStore() *Store {
+	return NewStore(Devices{
+		{ID: "a", Name: "A", IPAddress: "10.0.0.1", Protocol: "vnc", Tags: []string{"lab"}},
+		{ID: "b", Name: "B", IPAddress: "10.0.0.2", Protocol: "vnc"},
+		{ID: "c", Name: "C", IPAddress: "10.0.0.3", Protocol: "vnc"},
+	})
+}
+
+func TestStoreApply(t *testing.T) {
+	store := bulkTestStore()
+
+	results, err := store.Apply([]Operation{
+		{Op: OpPatch, ID: "a", Patch: json.RawMessage(`{"full_screen": true}`), AddTags: []string{"office"}, RemoveTags: []string{"LAB"}},
+		{Op: OpAdd, Device: &Device{Name: "New", IPAddress: "10.0.0.4", Protocol: "vnc"}},
+		{Op: OpUpdate, Device: &Device{ID: "b", Name: "B2", IPAddress: "10.0.0.2", Protocol: "vnc"}},
+		{Op: OpDelete, ID: "c"},
+	})
+	if err != nil {
+		t.Fatalf("Apply: %v %+v", err, results)
+	}
+
+	if results[1].ID != "new" || results[1].Device == nil || results[1].Device.Position != 3 {
+		t.Errorf("add result = %+v", results[1])
+	}
+
+	a, _ := store.Get("a")
+	if !a.IsFullScreen() || !slices.Equal(a.Tags, []string{"office"}) || a.Name != "A" {
+		t.Errorf("patched device = %+v", a)
+	}
+	if got := store.GetAll(); !equalIDs(got, "a", "b", "new") {
+		t.Errorf("devices = %v", ids(got))
+	}
+}
+
+func TestStoreApplyIsAtomic(t *testing.T) {
+	store := bulkTestStore()
+	before := store.GetAll()
+
+	results, err := store.Apply([]Operation{
+		{Op: OpPatch, ID: "a", Patch: json.RawMessage(`{"tags": ["x"], "name": "Renamed"}`)},
+		{Op: OpDelete, ID: "b"},
+		{Op: OpPatch, ID: "c", Patch: json.RawMessage(`{"port": 70000}`)},
+		{Op: OpDelete, ID: "missing"},
+		{Op: "rename", ID: "a"},
+	})
+	if !errors.Is(err, ErrBulkFailed) {
+		t.Fatalf("expected ErrBulkFailed, got %v", err)
+	}
+
+	for i, wantError := range []bool{false, false, true, true, true} {
+		if (results[i].Error != "") != wantError {
+			t.Errorf("result %d = %+v", i, results[i])
+		}
+	}
+	if len(results[2].Fields) != 1 || results[2].Fields[0].Field != "port" {
+		t.Errorf("patch error fields = %+v", results[2].Fields)
+	}
+
+	// Neither the devices nor the tags of the stored devices changed
+	after := store.GetAll()
+	if len(after) != len(before) || after[0].Name != "A" || !slices.Equal(after[0].Tags, []string{"lab"}) {
+		t.Errorf("devices changed: %+v", after)
+	}
+}
+
+func TestStoreApplyPatchErrors(t *testing.T) {
+	for name, patch := range map[string]string{
+		"unknown field": `{"colour": "red"}`,
+		"id change":     `{"id": "z"}`,
+		"wrong type":    `{"port": "80"}`,
+	} {
+		_, err := bulkTestStore().Apply([]Operation{{Op: OpPatch, ID: "a", Patch: json.RawMessage(patch)}})
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package device
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Settings are the connection settings a group can define for its devices.
+// Unset fields are inherited from the parent group.
+type Settings struct {
+	Protocol   string           `json:"protocol,omitempty"`
+	Port       int              `json:"port,omitempty"`
+	Username   string           `json:"username,omitempty"`
+	FullScreen *bool            `json:"full_screen,omitempty"`
+	ViewerArgs []string         `json:"viewer_args,omitempty"`
+	Reconnect  *ReconnectPolicy `json:"reconnect,omitempty"`
+}
+
+// Reconnect modes
+const (
+	ReconnectNever     = "never"
+	ReconnectOnFailure = "on_failure"
+	ReconnectAlways    = "always"
+)
+
+// Defaults used by a ReconnectPolicy that leaves them unset
+const (
+	DefaultReconnectAttempts = 5
+	DefaultReconnectDelay    = 5
+)
+
+// ReconnectPolicy decides whether a viewer is started again after it exits
+type ReconnectPolicy struct {
+	// Mode is ReconnectNever, ReconnectOnFailure (the viewer exited with an
+	// error) or ReconnectAlways
+	Mode string `json:"mode"`
+	// MaxAttempts limits reconnects in a row, 0 for DefaultReconnectAttempts
+	MaxAttempts int `json:"max_attempts,omitempty"`
+	// Delay is the number of seconds to wait before reconnecting, 0 for
+	// DefaultReconnectDelay
+	Delay int `json:"delay,omitempty"`
+}
+
+// Attempts returns MaxAttempts or its default
+func (p ReconnectPolicy) Attempts() int {
+	if p.MaxAttempts > 0 {
+		return p.MaxAttempts
+	}
+	return DefaultReconnectAttempts
+}
+
+// DelaySeconds returns Delay or its default
+func (p ReconnectPolicy) DelaySeconds() int {
+	if p.Delay > 0 {
+		return p.Delay
+	}
+	return DefaultReconnectDelay
+}
+
+// ShouldReconnect reports whether a viewer that exited with err is started
+// again
+func (p *ReconnectPolicy) ShouldReconnect(err error) bool {
+	if p == nil {
+		return false
+	}
+	switch p.Mode {
+	case ReconnectAlways:
+		return true
+	case ReconnectOnFailure:
+		return err != nil
+	}
+	return false
+}
+
+func (p ReconnectPolicy) validate(prefix string, errs *ValidationError) {
+	switch p.Mode {
+	case ReconnectNever, ReconnectOnFailure, ReconnectAlways:
+	default:
+		errs.add(prefix+"reconnect.mode", "unknown mode %q, expected %s, %s or %s", p.Mode, ReconnectNever, ReconnectOnFailure, ReconnectAlways)
+	}
+	if p.MaxAttempts < 0 {
+		errs.add(prefix+"reconnect.max_attempts", "attempts must not be negative")
+	}
+	if p.Delay < 0 {
+		errs.add(prefix+"reconnect.delay", "delay must not be negative")
+	}
+}
+
+// Validate checks the settings that are set. Fields are named as in a
+// group, e.g. "defaults.port".
+func (s Settings) Validate() error {
+	errs := &ValidationError{}
+	if s.Protocol != "" && !isKnownProtocol(s.Protocol) {
+		errs.add("defaults.protocol", "unknown protocol %q, expected one of %s", s.Protocol, strings.Join(Protocols, ", "))
+	}
+	if s.Port < 0 || s.Port > 65535 {
+		errs.add("defaults.port", "port must be between 1 and 65535, or 0 for the default")
+	}
+	if hasControl(s.Username) {
+		errs.add("defaults.username", "username must not contain control characters")
+	}
+	for i, arg := range s.ViewerArgs {
+		if arg == "" || strings.ContainsRune(arg, 0) {
+			errs.add("defaults.viewer_args", "argument %d must not be empty or contain NUL", i+1)
+		}
+	}
+	if s.Reconnect != nil {
+		s.Reconnect.validate("defaults.", errs)
+	}
+	return errs.orNil()
+}
+
+// Bool returns a pointer to b, for the optional flags of Device and Settings
+func Bool(b bool) *bool {
+	return &b
+}
+
+// IsFullScreen reports whether the viewer starts in full screen. Unset
+// means windowed.
+func (d Device) IsFullScreen() bool {
+	return d.FullScreen != nil && *d.FullScreen
+}
+
+// Settings returns the inheritable settings of the device
+func (d Device) Settings() Settings {
+	return Settings{
+		Protocol:   d.Protocol,
+		Port:       d.Port,
+		Username:   d.Username,
+		FullScreen: d.FullScreen,
+		ViewerArgs: d.ViewerArgs,
+		Reconnect:  d.Reconnect,
+	}
+}
+
+// Sources of effective settings, see Groups.Effective
+const (
+	SourceDevice  = "device"
+	SourceDefault = "default"
+	// SourceGroup is followed by the group ID, e.g. "group:group1"
+	SourceGroup = "group:"
+)
+
+// inherit sets the fields of s that are unset from defaults and records
+// source for each of them
+func (s *Settings) inherit(defaults Settings, source string, sources map[string]string) {
+	if s.Protocol == "" && defaults.Protocol != "" {
+		s.Protocol = defaults.Protocol
+		sources["protocol"] = source
+	}
+	if s.Port == 0 && defaults.Port != 0 {
+		s.Port = defaults.Port
+		sources["port"] = source
+	}
+	if s.Username == "" && defaults.Username != "" {
+		s.Username = defaults.Username
+		sources["username"] = source
+	}
+	if s.FullScreen == nil && defaults.FullScreen != nil {
+		s.FullScreen = Bool(*defaults.FullScreen)
+		sources["full_screen"] = source
+	}
+	if len(s.ViewerArgs) == 0 && len(defaults.ViewerArgs) > 0 {
+		s.ViewerArgs = slices.Clone(defaults.ViewerArgs)
+		sources["viewer_args"] = source
+	}
+	if s.Reconnect == nil && defaults.Reconnect != nil {
+		policy := *defaults.Reconnect
+		s.Reconnect = &policy
+		sources["reconnect"] = source
+	}
+}
+
+// inherit sets the settings d leaves unset from defaults
+func (d *Device) inherit(defaults Settings) {
+	settings := d.Settings()
+	settings.inherit(defaults, "", map[string]string{})
+	d.setSettings(settings)
+}
+
+// setSettings replaces the inheritable settings of d
+func (d *Device) setSettings(s Settings) {
+	d.Protocol = s.Protocol
+	d.Port = s.Port
+	d.Username = s.Username
+	d.FullScreen = s.FullScreen
+	d.ViewerArgs = s.ViewerArgs
+	d.Reconnect = s.Reconnect
+}
+
+// settingNames lists the JSON names of the fields of Settings
+var settingNames = []string{"protocol", "port", "username", "full_screen", "viewer_args", "reconnect"}
+
+// Effective returns d with the settings it leaves unset taken from the
+// defaults of its group and then of the group's ancestors, the nearest group
+// winning. sources maps each setting to SourceDevice, SourceGroup plus the
+// ID of the group it came from, or SourceDefault if nothing sets it.
+func (g Groups) Effective(d Device) (Device, map[string]string) {
+	settings := d.Settings()
+	sources := map[string]string{}
+	for _, name := range settingNames {
+		sources[name] = SourceDefault
+	}
+	if settings.Protocol != "" {
+		sources["protocol"] = SourceDevice
+	}
+	if settings.Port != 0 {
+		sources["port"] = SourceDevice
+	}
+	if settings.Username != "" {
+		sources["username"] = SourceDevice
+	}
+	if settings.FullScreen != nil {
+		sources["full_screen"] = SourceDevice
+	}
+	if len(settings.ViewerArgs) > 0 {
+		sources["viewer_args"] = SourceDevice
+	}
+	if settings.Reconnect != nil {
+		sources["reconnect"] = SourceDevice
+	}
+
+	// visited guards against a cycle in a hand-edited config file
+	visited := map[string]bool{}
+	for id := d.GroupID; id != "" && !visited[id]; {
+		visited[id] = true
+		group, found := g.Get(id)
+		if !found {
+			break
+		}
+		settings.inherit(group.Defaults, SourceGroup+group.ID, sources)
+		id = group.ParentID
+	}
+
+	d.setSettings(settings)
+	return d, sources
+}
+
+// effectiveProtocol returns the protocol d uses, which may be inherited
+func (g Groups) effectiveProtocol(d Device) string {
+	d, _ = g.Effective(d)
+	return d.Protocol
+}
+
+// Effective returns d with the settings inherited from its groups, see
+// Groups.Effective
+func (m *Store) Effective(d Device) Device {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	d, _ = m.groups.Effective(d)
+	return d
+}
+
+// GetEffective returns the device with the settings inherited from its
+// groups
+func (m *Store) GetEffective(id string) (Device, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, d := range m.devices {
+		if d.ID == id {
+			d, _ = m.groups.Effective(d)
+			return d, true
+		}
+	}
+	return Device{}, false
+}
+
+// GetAllEffective returns all devices with the settings inherited from their
+// groups
+func (m *Store) GetAllEffective() Devices {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	result := make(Devices, len(m.devices))
+	for i, d := range m.devices {
+		result[i], _ = m.groups.Effective(d)
+	}
+	return result
+}
+
+// Validate checks d as Add and Update would, with its inherited settings
+func (m *Store) Validate(d Device) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.validate(d)
+}
+
+// validateSubtree checks that the devices in group id and its subgroups stay
+// valid with groups, e.g. after a change of the group's defaults
+func (m *Store) validateSubtree(groups Groups, id string) error {
+	inGroup := groups.Descendants(id)
+	for _, d := range m.devices {
+		if !inGroup[d.GroupID] {
+			continue
+		}
+		effective, _ := groups.Effective(d)
+		if err := effective.Validate(); err != nil {
+			// Not wrapped, the fields are those of the device and not of the group
+			return fmt.Errorf("PC %s would become invalid: %v", d.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package device
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestGroupsEffective(t *testing.T) {
+	groups := Groups{
+		{ID: "office", Name: "Office", Defaults: Settings{
+			Protocol:   "rdp",
+			Username:   "staff",
+			FullScreen: Bool(true),
+			Reconnect:  &ReconnectPolicy{Mode: ReconnectOnFailure},
+		}},
+		{ID: "lab", Name: "Lab", ParentID: "office", Defaults: Settings{Username: "student", ViewerArgs: []string{"/dynamic-resolution"}}},
+	}
+
+	d, sources := groups.Effective(Device{ID: "pc1", GroupID: "lab", Port: 3390, FullScreen: Bool(false)})
+	if d.Protocol != "rdp" || d.Username != "student" || d.Port != 3390 || d.IsFullScreen() ||
+		!slices.Equal(d.ViewerArgs, []string{"/dynamic-resolution"}) || d.Reconnect == nil || d.Reconnect.Mode != ReconnectOnFailure {
+		t.Errorf("effective device = %+v", d)
+	}
+
+	want := map[string]string{
+		"protocol":    "group:office",
+		"port":        SourceDevice,
+		"username":    "group:lab",
+		"full_screen": SourceDevice,
+		"viewer_args": "group:lab",
+		"reconnect":   "group:office",
+	}
+	for name, source := range want {
+		if sources[name] != source {
+			t.Errorf("source of %s = %q, want %q", name, sources[name], source)
+		}
+	}
+
+	// Ungrouped devices only have their own settings
+	d, sources = groups.Effective(Device{ID: "pc2", Protocol: "vnc"})
+	if d.FullScreen != nil || sources["protocol"] != SourceDevice || sources["port"] != SourceDefault {
+		t.Errorf("ungrouped device = %+v, sources %v", d, sources)
+	}
+}
+
+func TestStoreGroupDefaults(t *testing.T) {
+	store := NewStore(nil)
+	if _, err := store.AddGroup(Group{ID: "office", Name: "Office", Defaults: Settings{Protocol: "rdp", Username: "staff"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddGroup(Group{ID: "floor1", Name: "Floor 1", ParentID: "office", Defaults: Settings{Port: 3390}}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := store.AddGroup(Group{Name: "Broken", Defaults: Settings{Protocol: "ftp", Reconnect: &ReconnectPolicy{Mode: "sometimes"}}})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Fields) != 2 || validationErr.Fields[0].Field != "defaults.protocol" {
+		t.Errorf("expected errors for protocol and reconnect mode, got %v", err)
+	}
+
+	// The protocol and the username RDP needs come from the groups
+	if _, err := store.Add(Device{ID: "desk", Name: "Desk", IPAddress: "10.0.0.1", GroupID: "floor1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Add(Device{ID: "loose", Name: "Loose", IPAddress: "10.0.0.2"}); err == nil {
+		t.Error("expected an error for an ungrouped device without protocol")
+	}
+	if d, _ := store.GetEffective("desk"); d.Protocol != "rdp" || d.ConnectPort() != 3390 || d.Username != "staff" {
+		t.Errorf("effective device = %+v", d)
+	}
+
+	// Removing the protocol would leave the device without one
+	if err := store.UpdateGroup(Group{ID: "office", Name: "Office"}); err == nil {
+		t.Error("expected an error for defaults that make a device invalid")
+	}
+
+	// Deleting a group keeps the settings its devices inherited from it
+	if err := store.DeleteGroup("floor1"); err != nil {
+		t.Fatal(err)
+	}
+	d, _ := store.Get("desk")
+	if d.GroupID != "office" || d.Port != 3390 || d.Protocol != "" {
+		t.Errorf("device after deleting its group = %+v", d)
+	}
+	if d, _ := store.GetEffective("desk"); d.Protocol != "rdp" || d.ConnectPort() != 3390 {
+		t.Errorf("effective device after deleting its group = %+v", d)
+	}
+}
+
+func TestReconnectPolicy(t *testing.T) {
+	var unset *ReconnectPolicy
+	failed := errors.New("exit status 1")
+
+	tests := []struct {
+		policy *ReconnectPolicy
+		err    error
+		want   bool
+	}{
+		{unset, failed, false},
+		{&ReconnectPolicy{Mode: ReconnectNever}, failed, false},
+		{&ReconnectPolicy{Mode: ReconnectOnFailure}, failed, true},
+		{&ReconnectPolicy{Mode: ReconnectOnFailure}, nil, false},
+		{&ReconnectPolicy{Mode: ReconnectAlways}, nil, true},
+	}
+	for _, test := range tests {
+		if got := test.policy.ShouldReconnect(test.err); got != test.want {
+			t.Errorf("%+v.ShouldReconnect(%v) = %v", test.policy, test.err, got)
+		}
+	}
+
+	policy := ReconnectPolicy{Mode: ReconnectAlways}
+	if policy.Attempts() != DefaultReconnectAttempts || policy.DelaySeconds() != DefaultReconnectDelay {
+		t.Errorf("defaults = %d attempts, %d seconds", policy.Attempts(), policy.DelaySeconds())
+	}
+}
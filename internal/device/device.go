@@ -2,9 +2,9 @@ package device
 
 import (
 	"fmt"
-	"log"
-	"strconv"
+	"slices"
 	"strings"
+	"sync"
 )
 
 // Device represents any connectable device (PC, server, etc.)
@@ -12,23 +12,98 @@ type Device struct {
 	ID          string `json:"id"`
 	Name        string `json:"name"`
 	IPAddress   string `json:"ip_address"`
-	Protocol    string `json:"protocol"` // "vnc", "rdp", etc.
+	Protocol    string `json:"protocol"` // "vnc", "rdp", or empty to inherit
 	Port        int    `json:"port"`
 	Username    string `json:"username,omitempty"`
 	Password    string `json:"password,omitempty"`
-	FullScreen  bool   `json:"full_screen"`
+	FullScreen  *bool  `json:"full_screen,omitempty"` // nil inherits from the group
 	Description string `json:"description,omitempty"`
 	Screen      string `json:"screen,omitempty"`
+	// GroupID is the group the device is shown in, empty for ungrouped
+	GroupID string `json:"group_id,omitempty"`
+	// Tags are free-form labels used for filtering
+	Tags []string `json:"tags,omitempty"`
+	// Favorite devices are pinned to the top of the dashboard
+	Favorite bool `json:"favorite,omitempty"`
+	// Position is the device's place in the custom order, starting at 0.
+	// It is maintained by Store and changed through Reorder.
+	Position int `json:"position"`
+	// RDP holds settings only used by RDP connections
+	RDP RDPOptions `json:"rdp,omitzero"`
+	// ViewerArgs are passed to the viewer in addition to the arguments
+	// built from the other settings
+	ViewerArgs []string `json:"viewer_args,omitempty"`
+	// Reconnect decides whether the viewer is started again after it exits
+	Reconnect *ReconnectPolicy `json:"reconnect,omitempty"`
+	// Revision is increased by Store on every change of the device and lets
+	// clients detect that someone else changed it
+	Revision int64 `json:"revision"`
+}
+
+// RDPOptions are the gateway and redirection settings of an RDP connection
+type RDPOptions struct {
+	// Gateway is the Remote Desktop Gateway to connect through, "host" or
+	// "host:port"
+	Gateway            string `json:"gateway,omitempty"`
+	RedirectClipboard  bool   `json:"redirect_clipboard,omitempty"`
+	RedirectDrives     bool   `json:"redirect_drives,omitempty"`
+	RedirectPrinters   bool   `json:"redirect_printers,omitempty"`
+	RedirectSmartCards bool   `json:"redirect_smart_cards,omitempty"`
+}
+
+// HasTag reports whether the device has tag, ignoring case
+func (d Device) HasTag(tag string) bool {
+	for _, t := range d.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
 }
 
 type Devices []Device
 
+// DefaultPort returns the well-known port for a protocol, or 0 if unknown
+func DefaultPort(protocol string) int {
+	switch protocol {
+	case "vnc":
+		return 5900
+	case "rdp":
+		return 3389
+	default:
+		return 0
+	}
+}
+
+// ConnectPort returns the configured port, falling back to the protocol default
+func (d Device) ConnectPort() int {
+	if d.Port != 0 {
+		return d.Port
+	}
+	return DefaultPort(d.Protocol)
+}
+
+// NewStore returns a store holding a copy of devices
+func NewStore(devices Devices) *Store {
+	devices = slices.Clone(devices)
+	normalizePositions(devices)
+	initRevisions(devices)
+	return &Store{devices: devices}
+}
+
+// GetAll returns a copy of all devices
 func (m *Store) GetAll() Devices {
-	return m.Devices
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return slices.Clone(m.devices)
 }
 
 func (m *Store) Get(id string) (Device, bool) {
-	for _, device := range m.Devices {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, device := range m.devices {
 		if device.ID == id {
 			return device, true
 		}
@@ -37,34 +112,52 @@ func (m *Store) Get(id string) (Device, bool) {
 	return Device{}, false
 }
 
-func (m *Store) Add(device Device) error {
-	// Generate ID if not provided
-	if m.highestDeviceId == 0 {
-		if err := m.findHighestDeviceId(); err != nil {
-			return err
-		}
-	} else {
-		m.highestDeviceId += 1
-	}
+// Add stores a new device and returns it with its ID and position set. An ID
+// is generated from the name if none is given.
+func (m *Store) Add(device Device) (Device, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 
 	if device.ID == "" {
-		device.ID = fmt.Sprintf("pc%d", m.highestDeviceId)
+		device.ID = m.devices.generateID(device.Name)
+	} else if err := ValidateID(device.ID); err != nil {
+		return Device{}, fieldError("id", err)
 	}
 
-	err := m.Devices.ValidateNew(device)
-	if err != nil {
-		return err
+	if err := m.devices.ValidateNew(device); err != nil {
+		return Device{}, fieldError("id", err)
 	}
 
-	m.Devices = append(m.Devices, device)
+	if err := m.validate(device); err != nil {
+		return Device{}, err
+	}
 
-	return nil
+	// New devices are placed at the end of the custom order
+	device.Position = len(m.devices)
+	device.Revision = 1
+	m.devices = append(m.devices, device)
+
+	return device, nil
 }
 
+// Update replaces a stored device. If device.Revision is set, the update
+// fails with a ConflictError unless the stored device has that revision.
 func (m *Store) Update(device Device) error {
-	for i, existingPC := range m.Devices {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if err := m.validate(device); err != nil {
+		return err
+	}
+
+	for i, existingPC := range m.devices {
 		if existingPC.ID == device.ID {
-			m.Devices[i] = device
+			if err := checkRevision(existingPC, device.Revision); err != nil {
+				return err
+			}
+			device.Position = existingPC.Position
+			device.Revision = existingPC.Revision + 1
+			m.devices[i] = device
 			return nil
 		}
 	}
@@ -72,12 +165,38 @@ func (m *Store) Update(device Device) error {
 	return fmt.Errorf("PC with ID %s not found", device.ID)
 }
 
-func (m *Store) Delete(id string) error {
-	newDevices := make([]Device, 0, len(m.Devices))
+// SetFavorite marks or unmarks a device as favorite
+func (m *Store) SetFavorite(id string, favorite bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i := range m.devices {
+		if m.devices[i].ID == id {
+			if m.devices[i].Favorite != favorite {
+				m.devices[i].Favorite = favorite
+				m.devices[i].Revision++
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("PC with ID %s not found", id)
+}
+
+// Delete removes a device. A revision other than 0 must match the stored
+// device, see Update.
+func (m *Store) Delete(id string, revision int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	newDevices := make([]Device, 0, len(m.devices))
 	found := false
 
-	for _, d := range m.Devices {
+	for _, d := range m.devices {
 		if d.ID == id {
+			if err := checkRevision(d, revision); err != nil {
+				return err
+			}
 			found = true
 		} else {
 			newDevices = append(newDevices, d)
@@ -88,42 +207,64 @@ func (m *Store) Delete(id string) error {
 		return fmt.Errorf("PC with ID %s not found", id)
 	}
 
-	m.Devices = newDevices
+	normalizeOrder(newDevices)
+	m.devices = newDevices
 
 	return nil
 }
 
-func (d Devices) ValidateNew(device Device) error {
-	// Check for duplicate ID
-	for _, existingPC := range d {
-		if existingPC.ID == device.ID {
-			return fmt.Errorf("PC with ID %s already exists", device.ID)
-		}
-	}
+// Replace swaps all devices, groups and templates, e.g. after loading the
+// config file
+func (m *Store) Replace(devices Devices, groups Groups, templates Templates) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 
-	return nil
+	m.devices = slices.Clone(devices)
+	normalizePositions(m.devices)
+	initRevisions(m.devices)
+	m.groups = slices.Clone(groups)
+	m.templates = slices.Clone(templates)
 }
 
-func (m *Store) findHighestDeviceId() error {
-	highestDeviceId := 1
-	for _, pc := range m.Devices {
-		part := strings.Split(pc.ID, "pc")
-		if len(part) != 2 {
-			log.Printf("invalid PC ID: %s", pc.ID)
-			continue
-		}
+// Copy returns a separate store with the same devices, groups and
+// templates, e.g. to try out changes before they are made
+func (m *Store) Copy() *Store {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
 
-		test, err := strconv.Atoi(part[1])
-		if err != nil {
-			return err
+	return &Store{
+		devices:   slices.Clone(m.devices),
+		groups:    slices.Clone(m.groups),
+		templates: slices.Clone(m.templates),
+	}
+}
+
+// validate checks device, including references to other stored data
+func (m *Store) validate(device Device) error {
+	errs := &ValidationError{}
+	// Settings such as the protocol may come from the device's groups
+	effective, _ := m.groups.Effective(device)
+	if err := effective.Validate(); err != nil {
+		errs = err.(*ValidationError)
+	}
+
+	if device.GroupID != "" {
+		if _, found := m.groups.Get(device.GroupID); !found {
+			errs.add("group_id", "group with ID %s not found", device.GroupID)
 		}
+	}
+
+	return errs.orNil()
+}
 
-		if test > highestDeviceId {
-			highestDeviceId = test
+func (d Devices) ValidateNew(device Device) error {
+	// Check for duplicate ID
+	for _, existingPC := range d {
+		if existingPC.ID == device.ID {
+			return fmt.Errorf("PC with ID %s already exists", device.ID)
 		}
 	}
-	m.highestDeviceId = highestDeviceId + 1
-	log.Printf("Set highest device id to: %d", m.highestDeviceId)
+
 	return nil
 }
 
@@ -131,14 +272,33 @@ func (m *Store) findHighestDeviceId() error {
 type DevicesStore interface {
 	GetAll() Devices
 	Get(id string) (Device, bool)
-	Add(device Device) error
+	Add(device Device) (Device, error)
 	Update(device Device) error
-	Delete(id string) error
+	Patch(id string, patch []byte, revision int64) (Device, error)
+	Delete(id string, revision int64) error
+	GetGroups() Groups
+	GetGroup(id string) (Group, bool)
+	AddGroup(group Group) (Group, error)
+	UpdateGroup(group Group) error
+	DeleteGroup(id string) error
+	Reorder(ids []string) error
+	SetFavorite(id string, favorite bool) error
+	Apply(ops []Operation) ([]OperationResult, error)
+	GetTemplates() Templates
+	GetTemplate(id string) (Template, bool)
+	AddTemplate(template Template) (Template, error)
+	UpdateTemplate(template Template) error
+	DeleteTemplate(id string) error
+	AddFromTemplate(templateID string, overrides []byte) (Device, error)
+	Clone(id string, overrides []byte) (Device, error)
 }
 
+// Store holds the devices and is safe for concurrent use
 type Store struct {
-	Devices         Devices `json:"devices"`
-	highestDeviceId int
+	lock      sync.RWMutex
+	devices   Devices
+	groups    Groups
+	templates Templates
 }
 
 // Ensure Manager implements DeviceManager
@@ -0,0 +1,106 @@
+package device
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestStoreConcurrentAccess(t *testing.T) {
+	store := NewStore(Devices{{ID: "pc1", Name: "Initial", IPAddress: "10.0.0.1", Protocol: "vnc"}})
+
+	const workers = 16
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			id := fmt.Sprintf("worker%d", i)
+			if _, err := store.Add(Device{ID: id, Name: id, IPAddress: "10.0.0.1", Protocol: "vnc"}); err != nil {
+				t.Errorf("Add(%s): %v", id, err)
+				return
+			}
+
+			for j := 0; j < 50; j++ {
+				if _, found := store.Get(id); !found {
+					t.Errorf("Get(%s): not found", id)
+				}
+				if err := store.Update(Device{ID: id, Name: fmt.Sprintf("%s-%d", id, j), IPAddress: "10.0.0.1", Protocol: "vnc"}); err != nil {
+					t.Errorf("Update(%s): %v", id, err)
+				}
+				for _, d := range store.GetAll() {
+					_ = d.Name
+				}
+			}
+
+			if i%2 == 0 {
+				if err := store.Delete(id, 0); err != nil {
+					t.Errorf("Delete(%s): %v", id, err)
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	if got, want := len(store.GetAll()), 1+workers/2; got != want {
+		t.Fatalf("expected %d devices, got %d", want, got)
+	}
+}
+
+func TestStoreGeneratesUniqueIDsConcurrently(t *testing.T) {
+	store := NewStore(Devices{{ID: "pc1", Name: "Initial", IPAddress: "10.0.0.1", Protocol: "vnc"}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.Add(Device{Name: "Generated", IPAddress: "10.0.0.1", Protocol: "vnc"}); err != nil {
+				t.Errorf("Add: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for _, d := range store.GetAll() {
+		if seen[d.ID] {
+			t.Fatalf("duplicate ID %s", d.ID)
+		}
+		seen[d.ID] = true
+	}
+	if len(seen) != 21 {
+		t.Fatalf("expected 21 devices, got %d", len(seen))
+	}
+}
+
+func TestStoreGetAllReturnsCopy(t *testing.T) {
+	store := NewStore(Devices{{ID: "pc1", Name: "Original", IPAddress: "10.0.0.1", Protocol: "vnc"}})
+
+	devices := store.GetAll()
+	devices[0].Name = "Changed"
+
+	d, _ := store.Get("pc1")
+	if d.Name != "Original" {
+		t.Fatalf("modifying GetAll result changed the store: %q", d.Name)
+	}
+}
+
+func TestStoreCopy(t *testing.T) {
+	store := NewStore(Devices{{ID: "pc1", Name: "Original", IPAddress: "10.0.0.1", Protocol: "vnc"}})
+
+	work := store.Copy()
+	if _, err := work.Add(Device{ID: "pc2", Name: "New", IPAddress: "10.0.0.2", Protocol: "vnc"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := work.Update(Device{ID: "pc1", Name: "Changed", IPAddress: "10.0.0.1", Protocol: "vnc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if d, _ := store.Get("pc1"); d.Name != "Original" || len(store.GetAll()) != 1 {
+		t.Errorf("changing the copy changed the store: %+v", store.GetAll())
+	}
+}
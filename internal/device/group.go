@@ -0,0 +1,226 @@
+package device
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Group organises devices. Groups can be nested through ParentID and are
+// ordered among their siblings by Position.
+type Group struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	ParentID string `json:"parent_id,omitempty"`
+	Position int    `json:"position"`
+	// Defaults are the settings inherited by the group's devices and
+	// subgroups, see Groups.Effective
+	Defaults Settings `json:"defaults,omitzero"`
+}
+
+type Groups []Group
+
+// GroupNode is a group with its place in the hierarchy
+type GroupNode struct {
+	Group
+	Depth int
+	// Path is the names of the group and its ancestors, e.g. "Office / Floor 1"
+	Path string
+}
+
+// Get returns the group with the given ID
+func (g Groups) Get(id string) (Group, bool) {
+	for _, group := range g {
+		if group.ID == id {
+			return group, true
+		}
+	}
+	return Group{}, false
+}
+
+// Children returns the direct children of parentID in display order
+func (g Groups) Children(parentID string) Groups {
+	var children Groups
+	for _, group := range g {
+		if group.ParentID == parentID {
+			children = append(children, group)
+		}
+	}
+	slices.SortStableFunc(children, func(a, b Group) int {
+		if a.Position != b.Position {
+			return a.Position - b.Position
+		}
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+	return children
+}
+
+// Tree returns all groups depth-first in display order
+func (g Groups) Tree() []GroupNode {
+	var nodes []GroupNode
+	var walk func(parentID string, depth int, path string)
+	walk = func(parentID string, depth int, path string) {
+		for _, child := range g.Children(parentID) {
+			childPath := child.Name
+			if path != "" {
+				childPath = path + " / " + child.Name
+			}
+			nodes = append(nodes, GroupNode{Group: child, Depth: depth, Path: childPath})
+			walk(child.ID, depth+1, childPath)
+		}
+	}
+	walk("", 0, "")
+	return nodes
+}
+
+// Descendants returns id and the IDs of all groups nested below it
+func (g Groups) Descendants(id string) map[string]bool {
+	result := map[string]bool{id: true}
+	for changed := true; changed; {
+		changed = false
+		for _, group := range g {
+			if group.ParentID != "" && result[group.ParentID] && !result[group.ID] {
+				result[group.ID] = true
+				changed = true
+			}
+		}
+	}
+	return result
+}
+
+// validate checks group against the other groups, which must not include it
+func (g Groups) validate(group Group) error {
+	if strings.TrimSpace(group.Name) == "" {
+		return fmt.Errorf("group name is required")
+	}
+	if group.ParentID == "" {
+		return nil
+	}
+	if group.ParentID == group.ID {
+		return fmt.Errorf("group %s cannot be its own parent", group.ID)
+	}
+	if _, found := g.Get(group.ParentID); !found {
+		return fmt.Errorf("parent group %s not found", group.ParentID)
+	}
+	// The new parent must not be nested below the group itself
+	if g.Descendants(group.ID)[group.ParentID] {
+		return fmt.Errorf("group %s cannot be moved below its own subgroup", group.ID)
+	}
+	return nil
+}
+
+// nextGroupID returns the lowest unused "groupN" ID
+func (g Groups) nextGroupID() string {
+	for n := 1; ; n++ {
+		id := fmt.Sprintf("group%d", n)
+		if _, found := g.Get(id); !found {
+			return id
+		}
+	}
+}
+
+// GetGroups returns a copy of all groups
+func (m *Store) GetGroups() Groups {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return slices.Clone(m.groups)
+}
+
+func (m *Store) GetGroup(id string) (Group, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.groups.Get(id)
+}
+
+// AddGroup adds group, generating an ID if none is set, and returns it.
+// Without an explicit position the group is placed after its siblings.
+func (m *Store) AddGroup(group Group) (Group, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if group.ID == "" {
+		group.ID = m.groups.nextGroupID()
+	} else if _, found := m.groups.Get(group.ID); found {
+		return Group{}, fmt.Errorf("group with ID %s already exists", group.ID)
+	}
+
+	if err := m.groups.validate(group); err != nil {
+		return Group{}, err
+	}
+	if err := group.Defaults.Validate(); err != nil {
+		return Group{}, err
+	}
+
+	if group.Position == 0 {
+		group.Position = len(m.groups.Children(group.ParentID))
+	}
+
+	m.groups = append(m.groups, group)
+	return group, nil
+}
+
+func (m *Store) UpdateGroup(group Group) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	index := slices.IndexFunc(m.groups, func(g Group) bool { return g.ID == group.ID })
+	if index == -1 {
+		return fmt.Errorf("group with ID %s not found", group.ID)
+	}
+
+	if err := m.groups.validate(group); err != nil {
+		return err
+	}
+	if err := group.Defaults.Validate(); err != nil {
+		return err
+	}
+
+	// New defaults or a new parent change what the devices below inherit
+	groups := slices.Clone(m.groups)
+	groups[index] = group
+	if err := m.validateSubtree(groups, group.ID); err != nil {
+		return err
+	}
+
+	m.groups = groups
+	return nil
+}
+
+// DeleteGroup removes a group. Its subgroups, devices and the templates
+// using it move to its parent. They take over the group's defaults for the
+// settings they don't set themselves, so their effective settings stay the
+// same.
+func (m *Store) DeleteGroup(id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	group, found := m.groups.Get(id)
+	if !found {
+		return fmt.Errorf("group with ID %s not found", id)
+	}
+
+	m.groups = slices.DeleteFunc(m.groups, func(g Group) bool { return g.ID == id })
+	for i := range m.groups {
+		if m.groups[i].ParentID == id {
+			m.groups[i].ParentID = group.ParentID
+			m.groups[i].Defaults.inherit(group.Defaults, "", map[string]string{})
+		}
+	}
+	for i := range m.devices {
+		if m.devices[i].GroupID == id {
+			m.devices[i].GroupID = group.ParentID
+			m.devices[i].inherit(group.Defaults)
+			m.devices[i].Revision++
+		}
+	}
+	for i := range m.templates {
+		if m.templates[i].Device.GroupID == id {
+			m.templates[i].Device.GroupID = group.ParentID
+			m.templates[i].Device.inherit(group.Defaults)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package device
+
+import "testing"
+
+func newGroupStore(t *testing.T) *Store {
+	t.Helper()
+
+	store := NewStore(Devices{
+		{ID: "pc1", Name: "Reception", Protocol: "vnc", GroupID: "group2", Tags: []string{"lobby"}},
+		{ID: "pc2", Name: "Server", Protocol: "rdp", GroupID: "group1"},
+		{ID: "pc3", Name: "Loose", IPAddress: "10.0.0.1", Protocol: "vnc"},
+	})
+	for _, g := range []Group{
+		{ID: "group1", Name: "Office"},
+		{ID: "group2", Name: "Floor 1", ParentID: "group1"},
+	} {
+		if _, err := store.AddGroup(g); err != nil {
+			t.Fatalf("AddGroup(%s): %v", g.ID, err)
+		}
+	}
+	return store
+}
+
+func TestGroupsTree(t *testing.T) {
+	store := newGroupStore(t)
+
+	tree := store.GetGroups().Tree()
+	if len(tree) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(tree))
+	}
+	if tree[1].Path != "Office / Floor 1" || tree[1].Depth != 1 {
+		t.Fatalf("unexpected nested node: %+v", tree[1])
+	}
+}
+
+func TestGroupsRejectCycles(t *testing.T) {
+	store := newGroupStore(t)
+
+	err := store.UpdateGroup(Group{ID: "group1", Name: "Office", ParentID: "group2"})
+	if err == nil {
+		t.Fatal("expected moving a group below its subgroup to fail")
+	}
+}
+
+func TestDeleteGroupMovesMembersToParent(t *testing.T) {
+	store := newGroupStore(t)
+
+	if err := store.DeleteGroup("group2"); err != nil {
+		t.Fatalf("DeleteGroup: %v", err)
+	}
+
+	d, _ := store.Get("pc1")
+	if d.GroupID != "group1" {
+		t.Fatalf("device not moved to parent group: %q", d.GroupID)
+	}
+}
+
+func TestDevicesFilter(t *testing.T) {
+	store := newGroupStore(t)
+	devices := store.GetAll()
+	groups := store.GetGroups()
+
+	if got := devices.Filter(Filter{GroupID: "group1"}, groups); len(got) != 2 {
+		t.Errorf("group filter should include subgroups, got %d devices", len(got))
+	}
+	if got := devices.Filter(Filter{Tag: "LOBBY"}, groups); len(got) != 1 || got[0].ID != "pc1" {
+		t.Errorf("tag filter: %+v", got)
+	}
+	if got := devices.Filter(Filter{Protocol: "rdp"}, groups); len(got) != 1 || got[0].ID != "pc2" {
+		t.Errorf("protocol filter: %+v", got)
+	}
+
+	online := true
+	isOnline := func(id string) bool { return id == "pc3" }
+	if got := devices.Filter(Filter{Online: &online, IsOnline: isOnline}, groups); len(got) != 1 || got[0].ID != "pc3" {
+		t.Errorf("online filter: %+v", got)
+	}
+}
@@ -0,0 +1,120 @@
+package device
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxIDLength limits IDs so they stay readable in URLs and logs
+const maxIDLength = 64
+
+// maxSlugLength limits generated IDs, leaving room for a de-duplication suffix
+const maxSlugLength = 32
+
+// reservedIDs are the paths under /api/pcs/ that have routes of their own.
+// A device with one of these IDs couldn't be reached at /api/pcs/<id>.
+var reservedIDs = map[string]bool{
+	"add":       true,
+	"edit":      true,
+	"delete":    true,
+	"reorder":   true,
+	"bulk":      true,
+	"recent":    true,
+	"favorites": true,
+}
+
+// ValidateID checks a user-supplied device ID. IDs appear in URLs, so they
+// may only contain ASCII letters, digits, '-' and '_' and must start with a
+// letter or digit. IDs that clash with other API routes are reserved.
+func ValidateID(id string) error {
+	if id == "" {
+		return fmt.Errorf("ID must not be empty")
+	}
+	if len(id) > maxIDLength {
+		return fmt.Errorf("ID %q is longer than %d characters", id, maxIDLength)
+	}
+	if reservedIDs[id] {
+		return fmt.Errorf("ID %q is reserved", id)
+	}
+	for i, r := range id {
+		switch {
+		case isAlphanumeric(r):
+		case (r == '-' || r == '_') && i > 0:
+		case i == 0:
+			return fmt.Errorf("ID %q must start with a letter or digit", id)
+		default:
+			return fmt.Errorf("ID %q contains %q, only letters, digits, '-' and '_' are allowed", id, r)
+		}
+	}
+	return nil
+}
+
+func isAlphanumeric(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
+
+// Slug derives an ID from a device name, e.g. "Front Desk #2" becomes
+// "front-desk-2". It returns "" if the name contains no letters or digits.
+func Slug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if isAlphanumeric(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+		if b.Len() >= maxSlugLength {
+			break
+		}
+	}
+	return strings.TrimRight(b.String(), "-")
+}
+
+// generateID returns an unused ID derived from name. Duplicates get a
+// numeric suffix ("desk", "desk-2", ...), as do reserved IDs ("recent-2").
+// Names without letters or digits
+// fall back to the "pcN" scheme, continuing after the highest existing pcN.
+func (d Devices) generateID(name string) string {
+	used := make(map[string]bool, len(d))
+	for _, device := range d {
+		used[device.ID] = true
+	}
+
+	base := Slug(name)
+	if base == "" {
+		return d.nextPCID(used)
+	}
+
+	id := base
+	for n := 2; used[id] || reservedIDs[id]; n++ {
+		id = base + "-" + strconv.Itoa(n)
+	}
+	return id
+}
+
+// nextPCID returns "pcN" for the lowest N above every existing "pcN" ID.
+// IDs that don't follow the scheme are ignored.
+func (d Devices) nextPCID(used map[string]bool) string {
+	highest := 0
+	for _, device := range d {
+		number, found := strings.CutPrefix(device.ID, "pc")
+		if !found {
+			continue
+		}
+		if n, err := strconv.Atoi(number); err == nil && n > highest {
+			highest = n
+		}
+	}
+
+	for n := highest + 1; ; n++ {
+		if id := "pc" + strconv.Itoa(n); !used[id] {
+			return id
+		}
+	}
+}
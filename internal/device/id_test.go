@@ -0,0 +1,60 @@
+package device
+
+import "testing"
+
+func TestValidateID(t *testing.T) {
+	for _, id := range []string{"pc1", "front-desk", "Lab_2", "7"} {
+		if err := ValidateID(id); err != nil {
+			t.Errorf("ValidateID(%q): %v", id, err)
+		}
+	}
+	for _, id := range []string{"", "-pc", "has space", "a/b", "ünicode", "recent", "bulk", string(make([]byte, 65))} {
+		if err := ValidateID(id); err == nil {
+			t.Errorf("ValidateID(%q): expected error", id)
+		}
+	}
+}
+
+func TestSlug(t *testing.T) {
+	tests := map[string]string{
+		"Front Desk #2":                        "front-desk-2",
+		"  --Lab--  ":                          "lab",
+		"Ünïcode Room":                         "n-code-room",
+		"!!!":                                  "",
+		"a-very-long-name-that-keeps-going-on": "a-very-long-name-that-keeps-goin",
+	}
+	for name, want := range tests {
+		if got := Slug(name); got != want {
+			t.Errorf("Slug(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestStoreGeneratesIDs(t *testing.T) {
+	// Malformed "pcN" style IDs used to block every add
+	store := NewStore(Devices{{ID: "pcx"}, {ID: "pc7"}, {ID: "desk"}})
+
+	tests := []struct{ name, want string }{
+		{"Desk", "desk-2"},
+		{"Desk", "desk-3"},
+		{"Reception", "reception"},
+		{"???", "pc8"},
+		{"Recent", "recent-2"},
+	}
+	for _, test := range tests {
+		d, err := store.Add(Device{Name: test.name, IPAddress: "10.0.0.1", Protocol: "vnc"})
+		if err != nil {
+			t.Fatalf("Add(%q): %v", test.name, err)
+		}
+		if d.ID != test.want {
+			t.Errorf("Add(%q): got ID %q, want %q", test.name, d.ID, test.want)
+		}
+	}
+
+	if _, err := store.Add(Device{ID: "bad id", Name: "Bad"}); err == nil {
+		t.Error("expected invalid ID to be rejected")
+	}
+	if _, err := store.Add(Device{ID: "desk", Name: "Duplicate"}); err == nil {
+		t.Error("expected duplicate ID to be rejected")
+	}
+}
@@ -0,0 +1,57 @@
+package device
+
+import (
+	"fmt"
+	"slices"
+)
+
+// normalizePositions sorts devices by position and renumbers them 0..n-1.
+// Devices sharing a position, e.g. configs written before positions existed,
+// keep their relative order.
+func normalizePositions(devices Devices) {
+	slices.SortStableFunc(devices, func(a, b Device) int {
+		return a.Position - b.Position
+	})
+	normalizeOrder(devices)
+}
+
+// Reorder moves the listed devices into the given order. A partial list only
+// rearranges the listed devices among the positions they already occupy, so
+// every other device keeps its place.
+func (m *Store) Reorder(ids []string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	seen := make(map[string]bool, len(ids))
+	indexes := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return fmt.Errorf("PC with ID %s is listed more than once", id)
+		}
+		seen[id] = true
+
+		index := slices.IndexFunc(m.devices, func(d Device) bool { return d.ID == id })
+		if index == -1 {
+			return fmt.Errorf("PC with ID %s not found", id)
+		}
+		indexes = append(indexes, index)
+	}
+
+	// The listed devices fill the slots they occupied, in the requested order
+	slots := slices.Sorted(slices.Values(indexes))
+	reordered := slices.Clone(m.devices)
+	for i, index := range indexes {
+		reordered[slots[i]] = m.devices[index]
+	}
+
+	normalizeOrder(reordered)
+	m.devices = reordered
+	return nil
+}
+
+// normalizeOrder renumbers devices to match their order in the slice
+func normalizeOrder(devices Devices) {
+	for i := range devices {
+		devices[i].Position = i
+	}
+}
@@ -0,0 +1,58 @@
+package device
+
+import "testing"
+
+func TestNewStoreNormalizesPositions(t *testing.T) {
+	// Devices without positions keep their slice order
+	store := NewStore(Devices{{ID: "a"}, {ID: "b", Position: 5}, {ID: "c"}})
+
+	if got := store.GetAll(); !equalIDs(got, "a", "c", "b") || got[2].Position != 2 {
+		t.Fatalf("unexpected order: %+v", got)
+	}
+}
+
+func TestStoreReorder(t *testing.T) {
+	store := NewStore(Devices{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "d"}})
+
+	if err := store.Reorder([]string{"d", "c", "b", "a"}); err != nil {
+		t.Fatalf("full reorder: %v", err)
+	}
+	if got := store.GetAll(); !equalIDs(got, "d", "c", "b", "a") {
+		t.Fatalf("full reorder: got %v", ids(got))
+	}
+
+	// b and d swap the positions they occupy, the others stay in place
+	if err := store.Reorder([]string{"b", "d"}); err != nil {
+		t.Fatalf("partial reorder: %v", err)
+	}
+	if got := store.GetAll(); !equalIDs(got, "b", "c", "d", "a") {
+		t.Fatalf("partial reorder: got %v", ids(got))
+	}
+
+	for _, invalid := range [][]string{{"a", "a"}, {"a", "missing"}} {
+		if err := store.Reorder(invalid); err == nil {
+			t.Errorf("Reorder(%v): expected error", invalid)
+		}
+	}
+}
+
+func TestStoreKeepsPositionsConsistent(t *testing.T) {
+	store := NewStore(Devices{{ID: "a"}, {ID: "b"}, {ID: "c"}})
+
+	if err := store.Delete("a", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Add(Device{ID: "d", Name: "d", IPAddress: "10.0.0.4", Protocol: "vnc", Position: 99}); err != nil {
+		t.Fatal(err)
+	}
+	// Updates cannot move a device
+	if err := store.Update(Device{ID: "b", Name: "renamed", IPAddress: "10.0.0.2", Protocol: "vnc", Position: 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, d := range store.GetAll() {
+		if d.Position != i {
+			t.Errorf("device %s at index %d has position %d", d.ID, i, d.Position)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// MergePatch applies an RFC 7396 JSON merge patch to d. Fields the patch
+// omits keep their value, null resets a field and nested objects such as
+// "rdp" are merged. The patch cannot change the ID, and the position and
+// revision, which Store maintains, are kept.
+func MergePatch(d Device, patch []byte) (Device, error) {
+	patched, err := mergePatchDevice(d, patch)
+	if err != nil {
+		return Device{}, err
+	}
+
+	if patched.ID != d.ID {
+		return Device{}, errors.New("a patch cannot change the device ID")
+	}
+	patched.Position = d.Position
+	patched.Revision = d.Revision
+	return patched, nil
+}
+
+// mergePatchDevice applies a merge patch to d without restricting the
+// fields it may change
+func mergePatchDevice(d Device, patch []byte) (Device, error) {
+	var patchDoc any
+	if err := json.Unmarshal(patch, &patchDoc); err != nil {
+		return Device{}, fmt.Errorf("invalid patch: %w", err)
+	}
+	if _, ok := patchDoc.(map[string]any); !ok {
+		return Device{}, errors.New("invalid patch: expected a JSON object")
+	}
+
+	original, err := json.Marshal(d)
+	if err != nil {
+		return Device{}, err
+	}
+	var target any
+	if err := json.Unmarshal(original, &target); err != nil {
+		return Device{}, err
+	}
+
+	merged, err := json.Marshal(mergePatch(target, patchDoc))
+	if err != nil {
+		return Device{}, err
+	}
+
+	var patched Device
+	decoder := json.NewDecoder(bytes.NewReader(merged))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patched); err != nil {
+		return Device{}, fmt.Errorf("invalid patch: %w", err)
+	}
+	return patched, nil
+}
+
+// mergePatch implements the MergePatch algorithm of RFC 7396
+func mergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = make(map[string]any)
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
+
+// Patch applies a merge patch to the stored device and returns the result,
+// see MergePatch. A revision other than 0 must match the stored device.
+func (m *Store) Patch(id string, patch []byte, revision int64) (Device, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	index := slices.IndexFunc(m.devices, func(d Device) bool { return d.ID == id })
+	if index == -1 {
+		return Device{}, fmt.Errorf("PC with ID %s not found", id)
+	}
+	if err := checkRevision(m.devices[index], revision); err != nil {
+		return Device{}, err
+	}
+
+	d, err := MergePatch(m.devices[index], patch)
+	if err != nil {
+		return Device{}, err
+	}
+	if err := m.validate(d); err != nil {
+		return Device{}, err
+	}
+
+	d.Revision++
+	m.devices[index] = d
+	return d, nil
+}
@@ -0,0 +1,70 @@
+package device
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	d := Device{
+		ID:        "a",
+		Name:      "A",
+		IPAddress: "10.0.0.1",
+		Protocol:  "rdp",
+		Username:  "admin",
+		Password:  "secret",
+		Tags:      []string{"lab", "office"},
+		RDP:       RDPOptions{Gateway: "gw.example.com", RedirectClipboard: true},
+	}
+
+	patched, err := MergePatch(d, []byte(`{"name": "Renamed", "tags": ["lab"], "rdp": {"gateway": null}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if patched.Name != "Renamed" || !slices.Equal(patched.Tags, []string{"lab"}) {
+		t.Errorf("patched = %+v", patched)
+	}
+	// Omitted fields and nested fields keep their value
+	if patched.Password != "secret" || patched.Username != "admin" || !patched.RDP.RedirectClipboard {
+		t.Errorf("omitted fields changed: %+v", patched)
+	}
+	if patched.RDP.Gateway != "" {
+		t.Errorf("null did not reset the gateway: %+v", patched.RDP)
+	}
+	if !slices.Equal(d.Tags, []string{"lab", "office"}) {
+		t.Errorf("original device changed: %v", d.Tags)
+	}
+
+	for _, patch := range []string{`{"id": "b"}`, `{"unknown": 1}`, `["name"]`, `{"port": "x"}`, `{`} {
+		if _, err := MergePatch(d, []byte(patch)); err == nil {
+			t.Errorf("%s: expected an error", patch)
+		}
+	}
+}
+
+func TestStorePatch(t *testing.T) {
+	store := bulkTestStore()
+
+	d, err := store.Patch("b", []byte(`{"full_screen": true, "tags": null}`), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.IsFullScreen() || d.Name != "B" || d.Position != 1 {
+		t.Errorf("patched = %+v", d)
+	}
+
+	_, err = store.Patch("a", []byte(`{"ip_address": "999.1.1.1"}`), 0)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Fields[0].Field != "ip_address" {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	if a, _ := store.Get("a"); a.IPAddress != "10.0.0.1" {
+		t.Errorf("invalid patch was stored: %+v", a)
+	}
+
+	if _, err := store.Patch("missing", []byte(`{}`), 0); err == nil {
+		t.Error("expected an error for a missing device")
+	}
+}
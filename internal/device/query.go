@@ -0,0 +1,180 @@
+package device
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// Filter selects devices for listings. Empty fields match every device.
+type Filter struct {
+	// GroupID matches devices in the group or any of its subgroups
+	GroupID  string
+	Tag      string
+	Protocol string
+	// Online matches devices whose reachability equals *Online, as
+	// reported by IsOnline
+	Online   *bool
+	IsOnline func(id string) bool
+}
+
+// Filter returns the devices matching f. groups is used to resolve subgroups
+// and inherited protocols.
+func (d Devices) Filter(f Filter, groups Groups) Devices {
+	var inGroup map[string]bool
+	if f.GroupID != "" {
+		inGroup = groups.Descendants(f.GroupID)
+	}
+
+	result := Devices{}
+	for _, device := range d {
+		if inGroup != nil && !inGroup[device.GroupID] {
+			continue
+		}
+		if f.Tag != "" && !device.HasTag(f.Tag) {
+			continue
+		}
+		if f.Protocol != "" && !strings.EqualFold(groups.effectiveProtocol(device), f.Protocol) {
+			continue
+		}
+		if f.Online != nil && f.IsOnline != nil && f.IsOnline(device.ID) != *f.Online {
+			continue
+		}
+		result = append(result, device)
+	}
+
+	return result
+}
+
+// Favorites returns the favorite devices in their stored order
+func (d Devices) Favorites() Devices {
+	result := Devices{}
+	for _, device := range d {
+		if device.Favorite {
+			result = append(result, device)
+		}
+	}
+	return result
+}
+
+// Sort orders accepted by Query
+const (
+	SortName     = "name"
+	SortLastUsed = "last_used"
+	SortStatus   = "status"
+	// SortCustom keeps the order in which devices are stored
+	SortCustom = "custom"
+)
+
+// ValidSort reports whether by is a supported sort order. An empty order is
+// the same as SortCustom.
+func ValidSort(by string) bool {
+	switch by {
+	case "", SortName, SortLastUsed, SortStatus, SortCustom:
+		return true
+	}
+	return false
+}
+
+// Query combines filtering, searching, sorting and pagination
+type Query struct {
+	Filter
+	// Search is matched by Devices.Search
+	Search string
+	Sort   string
+	// Limit is the maximum number of devices returned, 0 means no limit
+	Limit  int
+	Offset int
+	// LastUsed returns when a device was last connected to, used by SortLastUsed
+	LastUsed func(id string) time.Time
+}
+
+// Query returns the page of devices selected by q and the number of matching
+// devices before pagination
+func (d Devices) Query(q Query, groups Groups) (Devices, int) {
+	result := d.Filter(q.Filter, groups).Search(q.Search)
+	result.Sort(q.Sort, q.LastUsed, q.IsOnline)
+	return result.Page(q.Limit, q.Offset), len(result)
+}
+
+// Search returns the devices whose name, description, address or tags contain
+// every word of q, ignoring case
+func (d Devices) Search(q string) Devices {
+	terms := strings.Fields(strings.ToLower(q))
+	if len(terms) == 0 {
+		return d
+	}
+
+	result := Devices{}
+	for _, device := range d {
+		text := strings.ToLower(strings.Join(append([]string{
+			device.Name, device.Description, device.IPAddress,
+		}, device.Tags...), "\n"))
+
+		matches := true
+		for _, term := range terms {
+			if !strings.Contains(text, term) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			result = append(result, device)
+		}
+	}
+
+	return result
+}
+
+// Sort orders the devices in place. Ties are broken by name. lastUsed and
+// isOnline may be nil, in which case no device counts as used or online.
+func (d Devices) Sort(by string, lastUsed func(id string) time.Time, isOnline func(id string) bool) {
+	byName := func(a, b Device) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	}
+
+	switch by {
+	case SortName:
+		slices.SortStableFunc(d, byName)
+	case SortLastUsed:
+		if lastUsed == nil {
+			slices.SortStableFunc(d, byName)
+			return
+		}
+		// Most recently used first, never used last
+		slices.SortStableFunc(d, func(a, b Device) int {
+			if c := lastUsed(b.ID).Compare(lastUsed(a.ID)); c != 0 {
+				return c
+			}
+			return byName(a, b)
+		})
+	case SortStatus:
+		if isOnline == nil {
+			slices.SortStableFunc(d, byName)
+			return
+		}
+		// Online devices first
+		slices.SortStableFunc(d, func(a, b Device) int {
+			if onlineA, onlineB := isOnline(a.ID), isOnline(b.ID); onlineA != onlineB {
+				if onlineA {
+					return -1
+				}
+				return 1
+			}
+			return byName(a, b)
+		})
+	}
+}
+
+// Page returns at most limit devices starting at offset. A limit of 0 returns
+// all remaining devices.
+func (d Devices) Page(limit, offset int) Devices {
+	if offset >= len(d) {
+		return Devices{}
+	}
+	d = d[max(offset, 0):]
+	if limit > 0 && limit < len(d) {
+		d = d[:limit]
+	}
+	return d
+}
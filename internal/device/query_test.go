@@ -0,0 +1,93 @@
+package device
+
+import (
+	"testing"
+	"time"
+)
+
+func queryTestDevices() Devices {
+	return Devices{
+		{ID: "pc1", Name: "charlie", IPAddress: "10.0.0.3", Protocol: "vnc", Tags: []string{"kiosk"}},
+		{ID: "pc2", Name: "Alpha", IPAddress: "10.0.0.1", Protocol: "rdp", Description: "Front desk"},
+		{ID: "pc3", Name: "bravo", IPAddress: "192.168.1.2", Protocol: "vnc"},
+	}
+}
+
+func ids(devices Devices) []string {
+	var result []string
+	for _, d := range devices {
+		result = append(result, d.ID)
+	}
+	return result
+}
+
+func equalIDs(devices Devices, want ...string) bool {
+	got := ids(devices)
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDevicesSearch(t *testing.T) {
+	devices := queryTestDevices()
+
+	tests := map[string][]string{
+		"":           {"pc1", "pc2", "pc3"},
+		"ALPHA":      {"pc2"},
+		"front desk": {"pc2"},
+		"10.0.0":     {"pc1", "pc2"},
+		"kiosk":      {"pc1"},
+		"alpha 10.9": nil,
+	}
+	for q, want := range tests {
+		if got := devices.Search(q); !equalIDs(got, want...) {
+			t.Errorf("Search(%q) = %v, want %v", q, ids(got), want)
+		}
+	}
+}
+
+func TestDevicesSort(t *testing.T) {
+	now := time.Now()
+	lastUsed := func(id string) time.Time {
+		switch id {
+		case "pc1":
+			return now.Add(-time.Hour)
+		case "pc3":
+			return now
+		}
+		return time.Time{}
+	}
+	isOnline := func(id string) bool { return id == "pc3" }
+
+	tests := map[string][]string{
+		SortCustom:   {"pc1", "pc2", "pc3"},
+		SortName:     {"pc2", "pc3", "pc1"},
+		SortLastUsed: {"pc3", "pc1", "pc2"},
+		SortStatus:   {"pc3", "pc2", "pc1"},
+	}
+	for by, want := range tests {
+		devices := queryTestDevices()
+		devices.Sort(by, lastUsed, isOnline)
+		if !equalIDs(devices, want...) {
+			t.Errorf("Sort(%q) = %v, want %v", by, ids(devices), want)
+		}
+	}
+}
+
+func TestDevicesQueryPaginates(t *testing.T) {
+	page, total := queryTestDevices().Query(Query{Sort: SortName, Limit: 1, Offset: 1}, nil)
+	if total != 3 || !equalIDs(page, "pc3") {
+		t.Fatalf("got %v of %d", ids(page), total)
+	}
+
+	page, total = queryTestDevices().Query(Query{Offset: 5}, nil)
+	if total != 3 || len(page) != 0 {
+		t.Fatalf("offset past the end: got %v of %d", ids(page), total)
+	}
+}
@@ -0,0 +1,33 @@
+package device
+
+import "fmt"
+
+// ConflictError is returned when a change expects a revision the device no
+// longer has because someone else changed it in the meantime
+type ConflictError struct {
+	// Current is the device as currently stored
+	Current Device
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("PC %s was changed by someone else, its current revision is %d", e.Current.ID, e.Current.Revision)
+}
+
+// checkRevision fails with a ConflictError unless d has the expected
+// revision. A revision of 0 matches any.
+func checkRevision(d Device, revision int64) error {
+	if revision != 0 && revision != d.Revision {
+		return &ConflictError{Current: d}
+	}
+	return nil
+}
+
+// initRevisions gives devices without a revision, e.g. from configs written
+// before revisions existed, their first one
+func initRevisions(devices Devices) {
+	for i := range devices {
+		if devices[i].Revision <= 0 {
+			devices[i].Revision = 1
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestStoreRevisions(t *testing.T) {
+	store := NewStore(Devices{{ID: "old", Name: "Old", IPAddress: "10.0.0.9", Protocol: "vnc"}})
+	if d, _ := store.Get("old"); d.Revision != 1 {
+		t.Errorf("device without revision got %d", d.Revision)
+	}
+
+	d, err := store.Add(Device{Name: "A", IPAddress: "10.0.0.1", Protocol: "vnc"})
+	if err != nil || d.Revision != 1 {
+		t.Fatalf("Add = %+v, %v", d, err)
+	}
+
+	d.Name = "A2"
+	if err := store.Update(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetFavorite("a", true); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ = store.Get("a"); d.Revision != 3 {
+		t.Fatalf("revision after update and favorite = %d", d.Revision)
+	}
+
+	// Changes based on an older revision fail and report the current device
+	stale := []error{
+		store.Update(Device{ID: "a", Name: "Stale", IPAddress: "10.0.0.1", Protocol: "vnc", Revision: 2}),
+		store.Delete("a", 2),
+	}
+	_, err = store.Patch("a", []byte(`{"name": "Stale"}`), 2)
+	stale = append(stale, err)
+	for _, err := range stale {
+		var conflictErr *ConflictError
+		if !errors.As(err, &conflictErr) || conflictErr.Current.Name != "A2" || conflictErr.Current.Revision != 3 {
+			t.Errorf("expected a conflict, got %v", err)
+		}
+	}
+
+	d, err = store.Patch("a", []byte(`{"name": "A3", "revision": 100}`), 3)
+	if err != nil || d.Revision != 4 || d.Name != "A3" {
+		t.Fatalf("Patch = %+v, %v", d, err)
+	}
+
+	_, err = store.Apply([]Operation{{Op: OpPatch, ID: "a", Patch: json.RawMessage(`{"name": "B"}`), Revision: 3}})
+	if !errors.Is(err, ErrBulkFailed) {
+		t.Errorf("expected stale bulk patch to fail, got %v", err)
+	}
+
+	if err := store.Delete("a", 4); err != nil {
+		t.Errorf("Delete with current revision: %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package device
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Template holds the settings shared by similar devices, such as protocol,
+// credentials and viewer arguments
+type Template struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Device holds the settings new devices start with. Its ID, position,
+	// revision and favorite flag are not used, and name and address are
+	// usually given per device.
+	Device Device `json:"device"`
+}
+
+type Templates []Template
+
+// Get returns the template with the given ID
+func (t Templates) Get(id string) (Template, bool) {
+	for _, template := range t {
+		if template.ID == id {
+			return template, true
+		}
+	}
+	return Template{}, false
+}
+
+// nextTemplateID returns the lowest unused "templateN" ID
+func (t Templates) nextTemplateID() string {
+	for n := 1; ; n++ {
+		id := fmt.Sprintf("template%d", n)
+		if _, found := t.Get(id); !found {
+			return id
+		}
+	}
+}
+
+// templateOptional lists the device fields a template may leave empty
+// because each device sets them itself
+var templateOptional = []string{"name", "ip_address", "username"}
+
+// validateTemplate checks template like a device, except that the fields of
+// templateOptional may be missing
+func (m *Store) validateTemplate(template Template) error {
+	if strings.TrimSpace(template.Name) == "" {
+		return fieldError("name", fmt.Errorf("template name is required"))
+	}
+
+	d := template.Device
+	err := m.validate(d)
+	if err == nil {
+		return nil
+	}
+
+	errs := err.(*ValidationError)
+	errs.Fields = slices.DeleteFunc(errs.Fields, func(f FieldError) bool {
+		return slices.Contains(templateOptional, f.Field) && d.fieldIsEmpty(f.Field)
+	})
+	for i := range errs.Fields {
+		errs.Fields[i].Field = "device." + errs.Fields[i].Field
+	}
+	return errs.orNil()
+}
+
+// fieldIsEmpty reports whether one of the fields of templateOptional is unset
+func (d Device) fieldIsEmpty(field string) bool {
+	switch field {
+	case "name":
+		return strings.TrimSpace(d.Name) == ""
+	case "ip_address":
+		return d.IPAddress == ""
+	case "username":
+		return strings.TrimSpace(d.Username) == ""
+	}
+	return false
+}
+
+// newDevice returns the settings of the template for a new device
+func (t Template) newDevice() Device {
+	d := t.Device
+	d.ID = ""
+	d.Position = 0
+	d.Revision = 0
+	d.Favorite = false
+	d.Tags = slices.Clone(d.Tags)
+	d.ViewerArgs = slices.Clone(d.ViewerArgs)
+	if d.Reconnect != nil {
+		policy := *d.Reconnect
+		d.Reconnect = &policy
+	}
+	return d
+}
+
+// GetTemplates returns a copy of all templates
+func (m *Store) GetTemplates() Templates {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return slices.Clone(m.templates)
+}
+
+func (m *Store) GetTemplate(id string) (Template, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.templates.Get(id)
+}
+
+// AddTemplate adds template, generating an ID if none is set, and returns it
+func (m *Store) AddTemplate(template Template) (Template, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if template.ID == "" {
+		template.ID = m.templates.nextTemplateID()
+	} else if err := ValidateID(template.ID); err != nil {
+		return Template{}, fieldError("id", err)
+	} else if _, found := m.templates.Get(template.ID); found {
+		return Template{}, fieldError("id", fmt.Errorf("template with ID %s already exists", template.ID))
+	}
+
+	if err := m.validateTemplate(template); err != nil {
+		return Template{}, err
+	}
+
+	m.templates = append(m.templates, template)
+	return template, nil
+}
+
+func (m *Store) UpdateTemplate(template Template) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	index := slices.IndexFunc(m.templates, func(t Template) bool { return t.ID == template.ID })
+	if index == -1 {
+		return fmt.Errorf("template with ID %s not found", template.ID)
+	}
+
+	if err := m.validateTemplate(template); err != nil {
+		return err
+	}
+
+	m.templates[index] = template
+	return nil
+}
+
+// DeleteTemplate removes a template. Devices created from it are kept.
+func (m *Store) DeleteTemplate(id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, found := m.templates.Get(id); !found {
+		return fmt.Errorf("template with ID %s not found", id)
+	}
+
+	m.templates = slices.DeleteFunc(m.templates, func(t Template) bool { return t.ID == id })
+	return nil
+}
+
+// AddFromTemplate creates a device from a template. The overrides are a
+// JSON merge patch, see MergePatch, that sets the fields of this device,
+// e.g. {"name": "Lab 5", "ip_address": "10.0.0.15"}.
+func (m *Store) AddFromTemplate(templateID string, overrides []byte) (Device, error) {
+	template, found := m.GetTemplate(templateID)
+	if !found {
+		return Device{}, fmt.Errorf("template with ID %s not found", templateID)
+	}
+
+	d := template.newDevice()
+	if len(overrides) > 0 {
+		var err error
+		if d, err = mergePatchDevice(d, overrides); err != nil {
+			return Device{}, err
+		}
+	}
+	return m.Add(d)
+}
+
+// Clone adds a copy of a device with a new ID. Unless the overrides, a JSON
+// merge patch, set another name, the copy is named after the original,
+// e.g. "Desk (copy)".
+func (m *Store) Clone(id string, overrides []byte) (Device, error) {
+	original, found := m.Get(id)
+	if !found {
+		return Device{}, fmt.Errorf("PC with ID %s not found", id)
+	}
+
+	d := Template{Device: original}.newDevice()
+	d.Name = m.copyName(original.Name)
+
+	if len(overrides) > 0 {
+		var err error
+		if d, err = mergePatchDevice(d, overrides); err != nil {
+			return Device{}, err
+		}
+	}
+	return m.Add(d)
+}
+
+// copyName returns a name for a copy of a device that no device has yet
+func (m *Store) copyName(name string) string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	taken := func(name string) bool {
+		return slices.ContainsFunc(m.devices, func(d Device) bool { return d.Name == name })
+	}
+
+	copyName := name + " (copy)"
+	for n := 2; taken(copyName); n++ {
+		copyName = fmt.Sprintf("%s (copy %d)", name, n)
+	}
+	return copyName
+}
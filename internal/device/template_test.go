@@ -0,0 +1,84 @@
+package device
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestTemplates(t *testing.T) {
+	store := NewStore(nil)
+	if _, err := store.AddGroup(Group{ID: "lab", Name: "Lab"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Name, address and username are left to each device
+	template, err := store.AddTemplate(Template{Name: "Lab RDP", Device: Device{
+		Protocol:   "rdp",
+		FullScreen: Bool(true),
+		GroupID:    "lab",
+		Tags:       []string{"lab"},
+		ViewerArgs: []string{"/dynamic-resolution"},
+	}})
+	if err != nil || template.ID != "template1" {
+		t.Fatalf("AddTemplate = %+v, %v", template, err)
+	}
+
+	_, err = store.AddTemplate(Template{Name: "Broken", Device: Device{Protocol: "ftp", Port: 70000}})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr.Fields) != 2 || validationErr.Fields[0].Field != "device.protocol" {
+		t.Errorf("expected errors for protocol and port, got %v", err)
+	}
+
+	d, err := store.AddFromTemplate("template1", []byte(`{"name": "Lab 1", "ip_address": "10.0.0.11", "username": "student"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.ID != "lab-1" || !d.IsFullScreen() || d.GroupID != "lab" || !slices.Equal(d.ViewerArgs, []string{"/dynamic-resolution"}) {
+		t.Errorf("device from template = %+v", d)
+	}
+
+	// The new device must not share slices with the template
+	d.Tags[0] = "changed"
+	if template, _ := store.GetTemplate("template1"); template.Device.Tags[0] != "lab" {
+		t.Errorf("template tags changed: %v", template.Device.Tags)
+	}
+
+	if _, err := store.AddFromTemplate("template1", []byte(`{"name": "Lab 2"}`)); err == nil {
+		t.Error("expected an error for a device without address")
+	}
+
+	if err := store.DeleteGroup("lab"); err != nil {
+		t.Fatal(err)
+	}
+	if template, _ := store.GetTemplate("template1"); template.Device.GroupID != "" {
+		t.Errorf("template still uses the deleted group: %+v", template)
+	}
+}
+
+func TestStoreClone(t *testing.T) {
+	store := bulkTestStore()
+	if err := store.SetFavorite("a", true); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := store.Clone("a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != "a-copy" || first.Name != "A (copy)" || first.IPAddress != "10.0.0.1" || first.Favorite || first.Revision != 1 {
+		t.Errorf("first clone = %+v", first)
+	}
+
+	second, err := store.Clone("a", []byte(`{"ip_address": "10.0.0.9"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Name != "A (copy 2)" || second.IPAddress != "10.0.0.9" || second.Position != 4 {
+		t.Errorf("second clone = %+v", second)
+	}
+
+	if _, err := store.Clone("missing", nil); err == nil {
+		t.Error("expected an error for a missing device")
+	}
+}
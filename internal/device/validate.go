@@ -0,0 +1,173 @@
+package device
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Protocols supported by the viewers
+var Protocols = []string{"vnc", "rdp"}
+
+// FieldError describes a problem with one field, named as in JSON
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a device
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Field + ": " + f.Message
+	}
+	return "invalid device: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) add(field, format string, args ...any) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// orNil returns e if it holds any field errors
+func (e *ValidationError) orNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// fieldError returns a ValidationError for a single field
+func fieldError(field string, err error) *ValidationError {
+	return &ValidationError{Fields: []FieldError{{Field: field, Message: err.Error()}}}
+}
+
+// Validate checks the fields of a device that don't depend on other devices.
+// It returns a *ValidationError listing every invalid field.
+func (d Device) Validate() error {
+	errs := &ValidationError{}
+
+	if strings.TrimSpace(d.Name) == "" {
+		errs.add("name", "name is required")
+	}
+
+	if d.IPAddress == "" {
+		errs.add("ip_address", "address is required")
+	} else if err := ValidateHost(d.IPAddress); err != nil {
+		errs.add("ip_address", "%v", err)
+	}
+
+	if !isKnownProtocol(d.Protocol) {
+		errs.add("protocol", "unknown protocol %q, expected one of %s", d.Protocol, strings.Join(Protocols, ", "))
+	}
+
+	// 0 selects the protocol's default port
+	if d.Port < 0 || d.Port > 65535 {
+		errs.add("port", "port must be between 1 and 65535, or 0 for the default")
+	}
+
+	if d.Protocol == "rdp" && strings.TrimSpace(d.Username) == "" {
+		errs.add("username", "username is required for RDP")
+	} else if hasControl(d.Username) {
+		errs.add("username", "username must not contain control characters")
+	}
+
+	if hasControl(d.Screen) {
+		errs.add("screen", "screen must not contain control characters")
+	}
+
+	if d.RDP.Gateway != "" {
+		if err := validateHostPort(d.RDP.Gateway); err != nil {
+			errs.add("rdp.gateway", "%v", err)
+		}
+	}
+
+	for i, arg := range d.ViewerArgs {
+		if arg == "" || strings.ContainsRune(arg, 0) {
+			errs.add("viewer_args", "argument %d must not be empty or contain NUL", i+1)
+		}
+	}
+
+	if d.Reconnect != nil {
+		d.Reconnect.validate("", errs)
+	}
+
+	return errs.orNil()
+}
+
+func isKnownProtocol(protocol string) bool {
+	for _, p := range Protocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// hasControl reports whether s contains control characters such as line
+// breaks, which would break the lines of exported files
+func hasControl(s string) bool {
+	return strings.IndexFunc(s, unicode.IsControl) >= 0
+}
+
+// ValidateHost checks that host is an IPv4 address, an IPv6 address or a
+// hostname
+func ValidateHost(host string) error {
+	if _, err := netip.ParseAddr(host); err == nil {
+		return nil
+	}
+	if strings.Contains(host, ":") {
+		return fmt.Errorf("%q is not a valid IPv6 address", host)
+	}
+	return validateHostname(host)
+}
+
+// validateHostname checks host against the RFC 1123 hostname syntax
+func validateHostname(host string) error {
+	name := strings.TrimSuffix(host, ".")
+	if name == "" || len(name) > 253 {
+		return fmt.Errorf("%q is not a valid hostname", host)
+	}
+
+	labels := strings.Split(name, ".")
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 {
+			return fmt.Errorf("%q is not a valid hostname", host)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("%q is not a valid hostname: labels cannot start or end with '-'", host)
+		}
+		for _, r := range label {
+			if !isAlphanumeric(r) && r != '-' {
+				return fmt.Errorf("%q is not a valid hostname: %q is not allowed", host, r)
+			}
+		}
+	}
+
+	// Something like 999.1.1.1 is a mistyped address rather than a hostname
+	last := labels[len(labels)-1]
+	if strings.Trim(last, "0123456789") == "" {
+		return fmt.Errorf("%q is not a valid IP address", host)
+	}
+
+	return nil
+}
+
+// validateHostPort checks a host with an optional port, e.g. "gw:443"
+func validateHostPort(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		// No port
+		return ValidateHost(strings.Trim(address, "[]"))
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%q is not a valid port", port)
+	}
+	return ValidateHost(host)
+}
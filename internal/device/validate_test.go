@@ -0,0 +1,77 @@
+package device
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateHost(t *testing.T) {
+	for _, host := range []string{"192.168.1.10", "::1", "fe80::1%eth0", "desk-01", "desk.example.com.", "2001:db8::5"} {
+		if err := ValidateHost(host); err != nil {
+			t.Errorf("ValidateHost(%q): %v", host, err)
+		}
+	}
+	for _, host := range []string{"999.1.1.1", "10.0.0", "-desk", "desk_01", "fe80::zz", "a..b", "host name"} {
+		if err := ValidateHost(host); err == nil {
+			t.Errorf("ValidateHost(%q): expected error", host)
+		}
+	}
+}
+
+func TestDeviceValidate(t *testing.T) {
+	valid := Device{Name: "Desk", IPAddress: "10.0.0.1", Protocol: "rdp", Username: "admin", Port: 3389}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("valid device: %v", err)
+	}
+
+	err := Device{Protocol: "vcn", Port: -1}.Validate()
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	fields := make(map[string]bool)
+	for _, f := range validationErr.Fields {
+		fields[f.Field] = true
+	}
+	for _, field := range []string{"name", "ip_address", "protocol", "port"} {
+		if !fields[field] {
+			t.Errorf("expected an error for %s, got %+v", field, validationErr.Fields)
+		}
+	}
+}
+
+func TestValidateControlCharacters(t *testing.T) {
+	valid := Device{Name: "Desk", IPAddress: "10.0.0.1", Protocol: "rdp", Username: "admin", Screen: "1920x1080"}
+	for _, d := range []Device{
+		{Name: "Desk", IPAddress: "10.0.0.1", Protocol: "rdp", Username: "admin\r\nalternate shell:s:cmd"},
+		{Name: "Desk", IPAddress: "10.0.0.1", Protocol: "rdp", Username: "admin", Screen: "1920x1080\n"},
+		{Name: "Desk", IPAddress: "10.0.0.1", Protocol: "rdp", Username: "admin", RDP: RDPOptions{Gateway: "gw\r\n"}},
+	} {
+		if err := d.Validate(); err == nil {
+			t.Errorf("%+v: expected error", d)
+		}
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("valid device: %v", err)
+	}
+	if err := (Settings{Username: "admin\n"}).Validate(); err == nil {
+		t.Error("group username with a line break: expected error")
+	}
+}
+
+func TestValidateGateway(t *testing.T) {
+	d := Device{Name: "Desk", IPAddress: "10.0.0.1", Protocol: "rdp", Username: "admin"}
+	for _, gateway := range []string{"gw.example.com", "gw.example.com:443", "[2001:db8::1]:443", "10.0.0.2"} {
+		d.RDP.Gateway = gateway
+		if err := d.Validate(); err != nil {
+			t.Errorf("gateway %q: %v", gateway, err)
+		}
+	}
+	for _, gateway := range []string{"gw:0", "gw:https", "bad host"} {
+		d.RDP.Gateway = gateway
+		if err := d.Validate(); err == nil {
+			t.Errorf("gateway %q: expected error", gateway)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+// Package devicecsv reads and writes the device inventory as CSV.
+package devicecsv
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"spark-heimdall/internal/device"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the MIME type CSV exports are served with
+const ContentType = "text/csv; charset=utf-8"
+
+// maxRows limits the number of rows read from one file
+const maxRows = 10000
+
+// formulaPrefixes are the characters that make spreadsheets evaluate a
+// cell as a formula, as listed by OWASP. Such cells are written with a
+// leading quote.
+const formulaPrefixes = "=+-@\t\r"
+
+// column reads and writes one device field as text
+type column struct {
+	name string
+	get  func(d device.Device) string
+	set  func(d *device.Device, value string) error
+}
+
+func stringColumn(name string, field func(d *device.Device) *string) column {
+	return column{
+		name: name,
+		get:  func(d device.Device) string { return *field(&d) },
+		set: func(d *device.Device, value string) error {
+			*field(d) = value
+			return nil
+		},
+	}
+}
+
+func boolColumn(name string, field func(d *device.Device) *bool) column {
+	return column{
+		name: name,
+		get:  func(d device.Device) string { return strconv.FormatBool(*field(&d)) },
+		set: func(d *device.Device, value string) error {
+			b, err := parseBool(value)
+			*field(d) = b
+			return err
+		},
+	}
+}
+
+// optionalBoolColumn is a boolColumn for a flag that can be unset, written
+// as an empty cell
+func optionalBoolColumn(name string, field func(d *device.Device) **bool) column {
+	return column{
+		name: name,
+		get: func(d device.Device) string {
+			if b := *field(&d); b != nil {
+				return strconv.FormatBool(*b)
+			}
+			return ""
+		},
+		set: func(d *device.Device, value string) error {
+			if value == "" {
+				*field(d) = nil
+				return nil
+			}
+			b, err := parseBool(value)
+			*field(d) = device.Bool(b)
+			return err
+		},
+	}
+}
+
+// columns are named after the device's JSON fields, in export order
+var columns = []column{
+	stringColumn("id", func(d *device.Device) *string { return &d.ID }),
+	stringColumn("name", func(d *device.Device) *string { return &d.Name }),
+	stringColumn("ip_address", func(d *device.Device) *string { return &d.IPAddress }),
+	stringColumn("protocol", func(d *device.Device) *string { return &d.Protocol }),
+	{
+		name: "port",
+		get:  func(d device.Device) string { return strconv.Itoa(d.Port) },
+		set: func(d *device.Device, value string) error {
+			if value == "" {
+				d.Port = 0
+				return nil
+			}
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%q is not a number", value)
+			}
+			d.Port = port
+			return nil
+		},
+	},
+	stringColumn("username", func(d *device.Device) *string { return &d.Username }),
+	stringColumn("password", func(d *device.Device) *string { return &d.Password }),
+	optionalBoolColumn("full_screen", func(d *device.Device) **bool { return &d.FullScreen }),
+	stringColumn("description", func(d *device.Device) *string { return &d.Description }),
+	stringColumn("group_id", func(d *device.Device) *string { return &d.GroupID }),
+	{
+		name: "tags",
+		get:  func(d device.Device) string { return strings.Join(d.Tags, ";") },
+		set: func(d *device.Device, value string) error {
+			d.Tags = splitTags(value)
+			return nil
+		},
+	},
+	boolColumn("favorite", func(d *device.Device) *bool { return &d.Favorite }),
+	stringColumn("screen", func(d *device.Device) *string { return &d.Screen }),
+	stringColumn("rdp.gateway", func(d *device.Device) *string { return &d.RDP.Gateway }),
+	boolColumn("rdp.redirect_clipboard", func(d *device.Device) *bool { return &d.RDP.RedirectClipboard }),
+	boolColumn("rdp.redirect_drives", func(d *device.Device) *bool { return &d.RDP.RedirectDrives }),
+	boolColumn("rdp.redirect_printers", func(d *device.Device) *bool { return &d.RDP.RedirectPrinters }),
+	boolColumn("rdp.redirect_smart_cards", func(d *device.Device) *bool { return &d.RDP.RedirectSmartCards }),
+}
+
+// aliases are header names understood without a mapping, besides the
+// column names themselves
+var aliases = map[string]string{
+	"address":  "ip_address",
+	"ip":       "ip_address",
+	"host":     "ip_address",
+	"hostname": "ip_address",
+	"group":    "group_id",
+	"user":     "username",
+}
+
+func findColumn(name string) (column, bool) {
+	for _, c := range columns {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return column{}, false
+}
+
+// Columns returns the names of all columns
+func Columns() []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+	}
+	return names
+}
+
+// DefaultColumns returns the columns exported unless others are chosen,
+// which is all but the password
+func DefaultColumns() []string {
+	return slices.DeleteFunc(Columns(), func(name string) bool { return name == "password" })
+}
+
+// ValidateColumns checks that every name is a known column
+func ValidateColumns(names []string) error {
+	for _, name := range names {
+		if _, found := findColumn(name); !found {
+			return fmt.Errorf("unknown column %q, expected one of %s", name, strings.Join(Columns(), ", "))
+		}
+	}
+	return nil
+}
+
+// Write writes the devices with a header row of the given columns
+func Write(w io.Writer, devices device.Devices, names []string) error {
+	if err := ValidateColumns(names); err != nil {
+		return err
+	}
+
+	out := csv.NewWriter(w)
+	if err := out.Write(names); err != nil {
+		return err
+	}
+	for _, d := range devices {
+		record := make([]string, len(names))
+		for i, name := range names {
+			c, _ := findColumn(name)
+			record[i] = escapeCell(c.get(d))
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Row is a data row of an imported file
+type Row struct {
+	// Line is the row's line number in the file, counting the header
+	Line int
+	// Values maps column names to the row's values
+	Values map[string]string
+}
+
+// Has reports whether the file has the column
+func (r Row) Has(name string) bool {
+	_, found := r.Values[name]
+	return found
+}
+
+// Apply sets the fields of d from the row's columns. Fields without a
+// column are left unchanged. Values that cannot be parsed are reported as
+// a ValidationError.
+func (r Row) Apply(d *device.Device) error {
+	errs := &device.ValidationError{}
+	for _, c := range columns {
+		value, found := r.Values[c.name]
+		if !found {
+			continue
+		}
+		if err := c.set(d, value); err != nil {
+			errs.Fields = append(errs.Fields, device.FieldError{Field: c.name, Message: err.Error()})
+		}
+	}
+	if len(errs.Fields) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Table is a parsed file
+type Table struct {
+	// Mapping maps the file's headers to the columns they were read into
+	Mapping map[string]string
+	// Ignored lists the headers that were not read
+	Ignored []string
+	Rows    []Row
+}
+
+// HasColumn reports whether a header was read into the column
+func (t Table) HasColumn(name string) bool {
+	for _, c := range t.Mapping {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Read parses a file with a header row. Headers are matched to columns by
+// mapping, which maps headers to column names (an empty name ignores the
+// header), and otherwise by their name, ignoring case, spaces and a few
+// common aliases like "hostname".
+func Read(r io.Reader, mapping map[string]string) (Table, error) {
+	in := csv.NewReader(r)
+	in.FieldsPerRecord = -1
+	in.TrimLeadingSpace = true
+
+	header, err := in.Read()
+	if errors.Is(err, io.EOF) {
+		return Table{}, errors.New("file is empty")
+	}
+	if err != nil {
+		return Table{}, fmt.Errorf("failed to read header: %w", err)
+	}
+	// Spreadsheets often save a UTF-8 byte order mark
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	table := Table{Mapping: make(map[string]string)}
+	indexes := make(map[string]int)
+	for i, h := range header {
+		name, err := mapHeader(h, mapping)
+		if err != nil {
+			return Table{}, err
+		}
+		if name == "" {
+			table.Ignored = append(table.Ignored, h)
+			continue
+		}
+		if _, duplicate := indexes[name]; duplicate {
+			return Table{}, fmt.Errorf("more than one header maps to column %q", name)
+		}
+		indexes[name] = i
+		table.Mapping[h] = name
+	}
+	if len(indexes) == 0 {
+		return Table{}, fmt.Errorf("no header matches a column, expected some of %s", strings.Join(Columns(), ", "))
+	}
+
+	for {
+		record, err := in.Read()
+		if errors.Is(err, io.EOF) {
+			return table, nil
+		}
+		if err != nil {
+			return Table{}, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		if isBlank(record) {
+			continue
+		}
+		if len(table.Rows) == maxRows {
+			return Table{}, fmt.Errorf("file has more than %d rows", maxRows)
+		}
+
+		line, _ := in.FieldPos(0)
+		row := Row{Line: line, Values: make(map[string]string, len(indexes))}
+		for name, i := range indexes {
+			if i < len(record) {
+				row.Values[name] = unescapeCell(strings.TrimSpace(record[i]))
+			} else {
+				row.Values[name] = ""
+			}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+}
+
+// escapeCell quotes a value that a spreadsheet would run as a formula, e.g.
+// a device named "=HYPERLINK(...)". Values that look escaped already, such
+// as the password "'-abc", get another quote so they are read back as they
+// were.
+func escapeCell(value string) string {
+	if startsFormula(value) || isEscaped(value) {
+		return "'" + value
+	}
+	return value
+}
+
+// unescapeCell removes the quote escapeCell adds. Any cell that is quotes
+// followed by a formula character loses its first quote, so a hand-written
+// "'-abc" is read as "-abc" and has to be written with a second quote.
+func unescapeCell(value string) string {
+	if isEscaped(value) {
+		return value[1:]
+	}
+	return value
+}
+
+func startsFormula(value string) bool {
+	return value != "" && strings.ContainsRune(formulaPrefixes, rune(value[0]))
+}
+
+// isEscaped reports whether value is one or more quotes followed by a
+// formula character
+func isEscaped(value string) bool {
+	unquoted := strings.TrimLeft(value, "'")
+	return len(unquoted) < len(value) && startsFormula(unquoted)
+}
+
+// mapHeader returns the column for a header, or "" to ignore it
+func mapHeader(header string, mapping map[string]string) (string, error) {
+	if name, found := mapping[header]; found {
+		if name == "" {
+			return "", nil
+		}
+		if _, known := findColumn(name); !known {
+			return "", fmt.Errorf("header %q is mapped to unknown column %q", header, name)
+		}
+		return name, nil
+	}
+
+	normalized := strings.ToLower(strings.TrimSpace(header))
+	normalized = strings.NewReplacer(" ", "_", "-", "_").Replace(normalized)
+	if alias, found := aliases[normalized]; found {
+		return alias, nil
+	}
+	if _, known := findColumn(normalized); known {
+		return normalized, nil
+	}
+	return "", nil
+}
+
+func isBlank(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// parseBool accepts true/false, yes/no, on/off and 1/0; empty is false
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "", "false", "no", "off", "0":
+		return false, nil
+	case "true", "yes", "on", "1":
+		return true, nil
+	}
+	return false, fmt.Errorf("%q is not true or false", value)
+}
+
+// splitTags splits tags separated by semicolons or commas
+func splitTags(value string) []string {
+	var tags []string
+	for _, tag := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == ',' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,152 @@
+package devicecsv
+
+import (
+	"bytes"
+	"slices"
+	"spark-heimdall/internal/device"
+	"strings"
+	"testing"
+)
+
+func TestWriteDefaultColumns(t *testing.T) {
+	devices := device.Devices{{
+		ID:        "desk",
+		Name:      "Desk, 1st floor",
+		IPAddress: "10.0.0.1",
+		Protocol:  "rdp",
+		Username:  "admin",
+		Password:  "secret",
+		Tags:      []string{"office", "floor1"},
+		RDP:       device.RDPOptions{RedirectClipboard: true},
+	}}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, devices, DefaultColumns()); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	if strings.Contains(out, "secret") || strings.Contains(out, "password") {
+		t.Errorf("default export contains the password:\n%s", out)
+	}
+	for _, want := range []string{`"Desk, 1st floor"`, "office;floor1", "rdp.redirect_clipboard"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("export is missing %q:\n%s", want, out)
+		}
+	}
+
+	if err := Write(&buf, devices, []string{"name", "secrets"}); err == nil {
+		t.Error("expected an error for an unknown column")
+	}
+}
+
+func TestReadMapping(t *testing.T) {
+	input := "\ufeffAsset,Hostname,Protocol,Port,Owner,Full Screen,Tags\n" +
+		"Desk,10.0.0.1,vnc,5901,alice,yes,a;b\n" +
+		",,,,,,\n" +
+		"Server,server.example.com,rdp,,bob,0,\n"
+
+	table, err := Read(strings.NewReader(input), map[string]string{"Asset": "name", "Owner": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if table.Mapping["Hostname"] != "ip_address" || table.Mapping["Full Screen"] != "full_screen" {
+		t.Errorf("mapping = %v", table.Mapping)
+	}
+	if !slices.Equal(table.Ignored, []string{"Owner"}) {
+		t.Errorf("ignored = %v", table.Ignored)
+	}
+	if len(table.Rows) != 2 || table.Rows[1].Line != 4 {
+		t.Fatalf("rows = %+v", table.Rows)
+	}
+
+	var d device.Device
+	if err := table.Rows[0].Apply(&d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "Desk" || d.IPAddress != "10.0.0.1" || d.Port != 5901 || !d.IsFullScreen() || !slices.Equal(d.Tags, []string{"a", "b"}) {
+		t.Errorf("device = %+v", d)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for name, test := range map[string]struct {
+		input   string
+		mapping map[string]string
+	}{
+		"empty":          {"", nil},
+		"no columns":     {"foo,bar\n1,2\n", nil},
+		"unknown column": {"name\nx\n", map[string]string{"name": "nickname"}},
+		"duplicate":      {"address,ip\n1,2\n", nil},
+	} {
+		if _, err := Read(strings.NewReader(test.input), test.mapping); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	row := Row{Values: map[string]string{"port": "http", "favorite": "maybe", "name": "x"}}
+
+	var d device.Device
+	err := row.Apply(&d)
+
+	validationErr, ok := err.(*device.ValidationError)
+	if !ok || len(validationErr.Fields) != 2 {
+		t.Fatalf("Apply() = %v", err)
+	}
+	if d.Name != "x" {
+		t.Errorf("valid columns should still be applied, got %+v", d)
+	}
+}
+
+func TestFormulaCells(t *testing.T) {
+	devices := device.Devices{
+		{ID: "a", Name: "=HYPERLINK(\"http://evil.example\")", IPAddress: "10.0.0.1", Protocol: "vnc", Username: "@admin"},
+		{ID: "b", Name: "+1", IPAddress: "10.0.0.2", Protocol: "vnc", Username: "-x"},
+		{ID: "c", Name: "'quoted", IPAddress: "10.0.0.3", Protocol: "vnc", Username: "'-abc"},
+		{ID: "d", Name: "\tTab", IPAddress: "10.0.0.4", Protocol: "vnc", Username: "''=x"},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, devices, []string{"id", "name", "username"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n")[1:] {
+		for _, cell := range strings.Split(line, ",") {
+			cell = strings.TrimPrefix(cell, `"`)
+			if cell != "" && strings.ContainsRune(formulaPrefixes, rune(cell[0])) {
+				t.Errorf("cell %q would be run as a formula", cell)
+			}
+		}
+	}
+
+	table, err := Read(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, row := range table.Rows {
+		if row.Values["name"] != devices[i].Name || row.Values["username"] != devices[i].Username {
+			t.Errorf("row %d = %v, want %q and %q", i, row.Values, devices[i].Name, devices[i].Username)
+		}
+	}
+}
+
+func TestUnescapeCell(t *testing.T) {
+	// Quotes are only removed before formula characters, and only one
+	tests := map[string]string{
+		"'=1":     "=1",
+		"'-abc":   "-abc",
+		"''-abc":  "'-abc",
+		"'\tx":    "\tx",
+		"'quoted": "'quoted",
+		"'":       "'",
+		"-1":      "-1",
+	}
+	for cell, want := range tests {
+		if got := unescapeCell(cell); got != want {
+			t.Errorf("unescapeCell(%q) = %q, want %q", cell, got, want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Package discovery scans a network range for hosts offering remote desktop
+// services and identifies them by their protocol handshake.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Protocols reported for discovered services
+const (
+	ProtocolVNC = "vnc"
+	ProtocolRDP = "rdp"
+	ProtocolSSH = "ssh"
+)
+
+// MaxHosts limits the size of a scanned range
+const MaxHosts = 4096
+
+// DefaultPorts are the ports scanned unless Options.Ports is set: VNC
+// displays 0-10, RDP and SSH
+func DefaultPorts() []int {
+	ports := []int{22, 3389}
+	for port := 5900; port <= 5910; port++ {
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// Options control a scan. Zero values select the defaults.
+type Options struct {
+	Ports []int
+	// Concurrency is the maximum number of simultaneous connection attempts
+	// and reverse DNS lookups
+	Concurrency int
+	// Timeout applies to each connection attempt and handshake
+	Timeout time.Duration
+}
+
+const (
+	defaultConcurrency = 128
+	defaultTimeout     = 500 * time.Millisecond
+)
+
+// Service is an open port found by a scan
+type Service struct {
+	Address netip.Addr `json:"address"`
+	// Hostname is the reverse DNS name of the address, if any
+	Hostname string `json:"hostname,omitempty"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	// Fingerprint describes what the handshake revealed, e.g. "RFB 003.008"
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Verified is set when the handshake confirmed the protocol. Otherwise
+	// the protocol is guessed from the port.
+	Verified bool `json:"verified"`
+}
+
+// Hosts returns the addresses in prefix. For IPv4 ranges larger than /31 the
+// network and broadcast addresses are left out.
+func Hosts(prefix netip.Prefix) ([]netip.Addr, error) {
+	prefix = prefix.Masked()
+	bits := prefix.Addr().BitLen() - prefix.Bits()
+	if bits > 12 {
+		return nil, fmt.Errorf("range %s is too large, at most %d addresses can be scanned", prefix, MaxHosts)
+	}
+
+	var hosts []netip.Addr
+	for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+		hosts = append(hosts, addr)
+		if !addr.Next().IsValid() {
+			break
+		}
+	}
+
+	if prefix.Addr().Is4() && len(hosts) > 2 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+	return hosts, nil
+}
+
+// Scan connects to every port of every host in prefix and returns the open
+// ports, sorted by address and port
+func Scan(ctx context.Context, prefix netip.Prefix, opts Options) ([]Service, error) {
+	hosts, err := Hosts(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := opts.Ports
+	if len(ports) == 0 {
+		ports = DefaultPorts()
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	var (
+		lock     sync.Mutex
+		services []Service
+		wg       sync.WaitGroup
+	)
+	sem := make(chan struct{}, concurrency)
+
+scan:
+	for _, host := range hosts {
+		for _, port := range ports {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				break scan
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				service, open := probe(ctx, host, port, timeout)
+				if open {
+					lock.Lock()
+					services = append(services, service)
+					lock.Unlock()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	lookupHostnames(ctx, services, timeout, sem)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(services, func(a, b Service) int {
+		if c := a.Address.Compare(b.Address); c != 0 {
+			return c
+		}
+		return a.Port - b.Port
+	})
+	return services, nil
+}
+
+// probe connects to host:port and fingerprints the service behind it
+func probe(ctx context.Context, host netip.Addr, port int, timeout time.Duration) (Service, bool) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host.String(), strconv.Itoa(port)))
+	if err != nil {
+		return Service{}, false
+	}
+	defer conn.Close()
+
+	service := Service{Address: host, Port: port, Protocol: guessProtocol(port)}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	switch service.Protocol {
+	case ProtocolVNC:
+		service.Fingerprint, service.Verified = fingerprintVNC(conn)
+	case ProtocolRDP:
+		service.Fingerprint, service.Verified = fingerprintRDP(conn)
+	case ProtocolSSH:
+		service.Fingerprint, service.Verified = fingerprintSSH(conn)
+	}
+	return service, true
+}
+
+func guessProtocol(port int) string {
+	switch {
+	case port == 22:
+		return ProtocolSSH
+	case port == 3389:
+		return ProtocolRDP
+	default:
+		return ProtocolVNC
+	}
+}
+
+// lookupHostnames fills in reverse DNS names, one lookup per address. The
+// lookups share sem with the connection attempts of the scan.
+func lookupHostnames(ctx context.Context, services []Service, timeout time.Duration, sem chan struct{}) {
+	var addrs []netip.Addr
+	names := make(map[netip.Addr]string)
+	for _, service := range services {
+		if _, found := names[service.Address]; !found {
+			names[service.Address] = ""
+			addrs = append(addrs, service.Address)
+		}
+	}
+
+	var (
+		lock sync.Mutex
+		wg   sync.WaitGroup
+	)
+
+lookup:
+	for _, addr := range addrs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break lookup
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			if found, err := net.DefaultResolver.LookupAddr(lookupCtx, addr.String()); err == nil && len(found) > 0 {
+				lock.Lock()
+				names[addr] = found[0]
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range services {
+		services[i].Hostname = names[services[i].Address]
+	}
+}
+
+// LocalPrefixes returns the IPv4 networks of the machine's non-loopback
+// interfaces, used when no range is configured
+func LocalPrefixes() []netip.Prefix {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+
+	var prefixes []netip.Prefix
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(ipNet.String())
+		if err != nil || !prefix.Addr().Is4() || prefix.Addr().IsLoopback() || prefix.Addr().IsLinkLocalUnicast() {
+			continue
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
+}
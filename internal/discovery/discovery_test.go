@@ -0,0 +1,124 @@
+package discovery
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestHosts(t *testing.T) {
+	hosts, err := Hosts(netip.MustParsePrefix("192.168.1.77/30"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 || hosts[0].String() != "192.168.1.77" || hosts[1].String() != "192.168.1.78" {
+		t.Fatalf("unexpected hosts: %v", hosts)
+	}
+
+	if hosts, _ := Hosts(netip.MustParsePrefix("10.0.0.5/32")); len(hosts) != 1 {
+		t.Fatalf("expected a single host, got %v", hosts)
+	}
+
+	if _, err := Hosts(netip.MustParsePrefix("10.0.0.0/16")); err == nil {
+		t.Fatal("expected an error for a range larger than MaxHosts")
+	}
+}
+
+// serve accepts connections on a local port and runs handle for each
+func serve(t *testing.T, handle func(conn net.Conn)) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestScanFingerprintsVNC(t *testing.T) {
+	vnc := serve(t, func(conn net.Conn) {
+		conn.Write([]byte("RFB 003.008\n"))
+	})
+	silent := serve(t, func(conn net.Conn) {
+		time.Sleep(time.Second)
+	})
+
+	services, err := Scan(context.Background(), netip.MustParsePrefix("127.0.0.1/32"), Options{
+		Ports:   []int{vnc, silent},
+		Timeout: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 2 {
+		t.Fatalf("expected 2 services, got %+v", services)
+	}
+
+	for _, service := range services {
+		switch service.Port {
+		case vnc:
+			if !service.Verified || service.Fingerprint != "RFB 003.008" {
+				t.Errorf("VNC service not fingerprinted: %+v", service)
+			}
+		case silent:
+			if service.Verified || service.Protocol != ProtocolVNC {
+				t.Errorf("silent service should be an unverified guess: %+v", service)
+			}
+		}
+	}
+}
+
+func TestFingerprintRDP(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		defer server.Close()
+		request := make([]byte, len(rdpConnectionRequest))
+		io.ReadFull(server, request)
+		// Connection Confirm selecting CredSSP
+		server.Write([]byte{
+			0x03, 0x00, 0x00, 0x13,
+			0x0e, 0xd0, 0x00, 0x00, 0x12, 0x34, 0x00,
+			0x02, 0x1f, 0x08, 0x00, 0x02, 0x00, 0x00, 0x00,
+		})
+	}()
+
+	fingerprint, ok := fingerprintRDP(client)
+	if !ok || fingerprint != "RDP (CredSSP)" {
+		t.Fatalf("got %q, %v", fingerprint, ok)
+	}
+}
+
+func TestFingerprintSSH(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		defer server.Close()
+		server.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+	}()
+
+	fingerprint, ok := fingerprintSSH(client)
+	if !ok || fingerprint != "SSH-2.0-OpenSSH_9.6" {
+		t.Fatalf("got %q, %v", fingerprint, ok)
+	}
+}
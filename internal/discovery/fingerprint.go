@@ -0,0 +1,103 @@
+package discovery
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+)
+
+var rfbVersion = regexp.MustCompile(`^RFB \d{3}\.\d{3}\n$`)
+
+// fingerprintVNC reads the RFB protocol version the server announces
+func fingerprintVNC(conn net.Conn) (string, bool) {
+	version := make([]byte, 12)
+	if _, err := io.ReadFull(conn, version); err != nil {
+		return "", false
+	}
+	if !rfbVersion.Match(version) {
+		return "", false
+	}
+	return strings.TrimSpace(string(version)), true
+}
+
+// rdpConnectionRequest is an X.224 Connection Request carrying an RDP
+// Negotiation Request for TLS and CredSSP, wrapped in a TPKT header
+var rdpConnectionRequest = []byte{
+	0x03, 0x00, 0x00, 0x13, // TPKT: version 3, length 19
+	0x0e, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, // X.224: length 14, CR, refs, class 0
+	0x01, 0x00, 0x08, 0x00, 0x03, 0x00, 0x00, 0x00, // RDP_NEG_REQ: PROTOCOL_SSL | PROTOCOL_HYBRID
+}
+
+// RDP negotiation response types
+const (
+	rdpNegResponse = 0x02
+	rdpNegFailure  = 0x03
+)
+
+// rdpSecurity names the security protocols a server can select
+var rdpSecurity = map[uint32]string{
+	0: "standard RDP security",
+	1: "TLS",
+	2: "CredSSP",
+	8: "CredSSP with early user authorization",
+}
+
+// fingerprintRDP sends a connection request and reports the security
+// protocol the server selects
+func fingerprintRDP(conn net.Conn) (string, bool) {
+	if _, err := conn.Write(rdpConnectionRequest); err != nil {
+		return "", false
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil || header[0] != 0x03 {
+		return "", false
+	}
+	length := int(binary.BigEndian.Uint16(header[2:]))
+	if length < 11 || length > 512 {
+		return "", false
+	}
+
+	body := make([]byte, length-4)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return "", false
+	}
+
+	// X.224 Connection Confirm
+	if body[1]&0xf0 != 0xd0 {
+		return "", false
+	}
+
+	negotiation := body[7:]
+	if len(negotiation) < 8 {
+		// Old servers don't answer the negotiation request
+		return "RDP (" + rdpSecurity[0] + ")", true
+	}
+
+	value := binary.LittleEndian.Uint32(negotiation[4:8])
+	switch negotiation[0] {
+	case rdpNegResponse:
+		name, known := rdpSecurity[value]
+		if !known {
+			name = fmt.Sprintf("security protocol %d", value)
+		}
+		return "RDP (" + name + ")", true
+	case rdpNegFailure:
+		return fmt.Sprintf("RDP (negotiation failed with code %d)", value), true
+	default:
+		return "", false
+	}
+}
+
+// fingerprintSSH reads the identification string the server announces
+func fingerprintSSH(conn net.Conn) (string, bool) {
+	line, err := bufio.NewReaderSize(io.LimitReader(conn, 255), 255).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "SSH-") {
+		return "", false
+	}
+	return strings.TrimSpace(line), true
+}
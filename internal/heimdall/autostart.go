@@ -0,0 +1,244 @@
+package heimdall
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"spark-heimdall/internal/device"
+	"sync"
+	"time"
+)
+
+// Auto-start states reported through the status API
+const (
+	AutoStartIdle      = "idle"
+	AutoStartWaiting   = "waiting"
+	AutoStartConnected = "connected"
+	AutoStartFailed    = "failed"
+	AutoStartCancelled = "cancelled"
+)
+
+const (
+	probeTimeout        = 2 * time.Second
+	autoStartMaxBackoff = 10 * time.Second
+)
+
+// AutoStartStatus reports the progress of the startup connection
+type AutoStartStatus struct {
+	State string `json:"state"`
+	// Source is "restore" when restoring the previous session, otherwise "auto_start"
+	Source     string    `json:"source,omitempty"`
+	Candidates []string  `json:"candidates,omitempty"`
+	Current    string    `json:"current,omitempty"`
+	Attempts   int       `json:"attempts"`
+	StartedAt  time.Time `json:"started_at,omitzero"`
+	Deadline   time.Time `json:"deadline,omitzero"`
+	DeviceID   string    `json:"device_id,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type autoStart struct {
+	lock   sync.Mutex
+	status AutoStartStatus
+	cancel context.CancelFunc
+}
+
+func (a *autoStart) get() AutoStartStatus {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	status := a.status
+	if status.State == "" {
+		status.State = AutoStartIdle
+	}
+	return status
+}
+
+// begin resets the status for a new auto-start run that cancel can abort
+func (a *autoStart) begin(status AutoStartStatus, cancel context.CancelFunc) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.status = status
+	a.cancel = cancel
+}
+
+func (a *autoStart) update(fn func(status *AutoStartStatus)) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	fn(&a.status)
+}
+
+// stop cancels a pending auto-start, e.g. because the user connected manually
+func (a *autoStart) stop() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.cancel != nil && a.status.State == AutoStartWaiting {
+		a.cancel()
+	}
+}
+
+// probeDevice checks whether the device accepts TCP connections on its port
+func (s *Server) probeDevice(ctx context.Context, pc device.Device) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	address, err := s.deviceAddress(ctx, pc)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// autoStartCandidates returns the devices to connect to at startup, in
+// order of preference, and where they came from
+func (s *Server) autoStartCandidates() ([]device.Device, string) {
+	settings := s.configFile.Settings()
+	if settings.RestoreSession && s.state.Exists() {
+		ids := s.state.ActiveDevices()
+		if len(ids) == 0 {
+			log.Println("No session was active before the restart")
+			return nil, ""
+		}
+
+		candidates := s.existingDevices(ids)
+		if len(candidates) > 0 {
+			return candidates, "restore"
+		}
+		log.Printf("Cannot restore session to %v: devices no longer exist", ids)
+	}
+
+	if !settings.AutoStart {
+		return nil, ""
+	}
+
+	var ids []string
+	if settings.AutoStartID != "" {
+		ids = append(ids, settings.AutoStartID)
+	}
+	ids = append(ids, settings.AutoStartFallbackIDs...)
+
+	return s.existingDevices(ids), "auto_start"
+}
+
+func (s *Server) existingDevices(ids []string) []device.Device {
+	var devices []device.Device
+	for _, id := range ids {
+		if pc, found := s.configFile.Store.Get(id); found {
+			devices = append(devices, pc)
+		}
+	}
+	return devices
+}
+
+// runAutoStart probes the candidates in order until one answers and connects
+// to it. Earlier candidates are preferred on every round, so a fallback is
+// only used while the devices before it are unreachable.
+func (s *Server) runAutoStart(ctx context.Context, candidates []device.Device, source string) {
+	timeout := time.Duration(s.configFile.Settings().AutoStartTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ids := make([]string, len(candidates))
+	for i, pc := range candidates {
+		ids[i] = pc.ID
+	}
+
+	now := time.Now()
+	s.autoStart.begin(AutoStartStatus{
+		State:      AutoStartWaiting,
+		Source:     source,
+		Candidates: ids,
+		StartedAt:  now,
+		Deadline:   now.Add(timeout),
+	}, cancel)
+
+	log.Printf("Auto-start waiting for one of %v to become reachable (timeout %s)", ids, timeout)
+
+	backoff := time.Second
+	for {
+		for _, pc := range candidates {
+			s.autoStart.update(func(status *AutoStartStatus) {
+				status.Current = pc.ID
+				status.Attempts++
+			})
+
+			err := s.probeDevice(ctx, pc)
+			if err == nil {
+				log.Printf("Auto-start: %s is reachable, connecting", pc.Name)
+				err = s.connectToPC(pc)
+				if err != nil {
+					log.Printf("Auto-start failed to connect to %s: %v", pc.Name, err)
+				}
+				s.autoStart.update(func(status *AutoStartStatus) {
+					status.State = AutoStartConnected
+					status.DeviceID = pc.ID
+					status.Current = ""
+					status.Error = ""
+					if err != nil {
+						status.State = AutoStartFailed
+						status.Error = err.Error()
+					}
+				})
+				return
+			}
+
+			s.autoStart.update(func(status *AutoStartStatus) {
+				status.Error = err.Error()
+			})
+
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+			backoff = min(backoff*2, autoStartMaxBackoff)
+			continue
+		}
+
+		state := AutoStartFailed
+		if ctx.Err() == context.Canceled {
+			state = AutoStartCancelled
+			log.Println("Auto-start cancelled")
+		} else {
+			log.Printf("Auto-start failed: none of %v became reachable within %s", ids, timeout)
+		}
+		s.autoStart.update(func(status *AutoStartStatus) {
+			status.State = state
+			status.Current = ""
+		})
+		return
+	}
+}
+
+// Status is returned by the status API
+type Status struct {
+	ConnectedID string          `json:"connected_id"`
+	AutoStart   AutoStartStatus `json:"auto_start"`
+	// Online maps device IDs to the result of their last reachability probe
+	Online map[string]bool `json:"online"`
+}
+
+// HandleStatus reports the current session and auto-start progress
+func (s *Server) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	s.cmdLock.Lock()
+	status := Status{ConnectedID: s.currentDeviceId}
+	s.cmdLock.Unlock()
+	status.AutoStart = s.autoStart.get()
+	status.Online = s.reachability.snapshot()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(status)
+	if err != nil {
+		log.Printf("Error encoding status: %v", err)
+	}
+}
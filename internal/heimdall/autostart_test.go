@@ -0,0 +1,193 @@
+package heimdall
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	configuration "spark-heimdall/internal/config"
+	"spark-heimdall/internal/device"
+	"testing"
+	"time"
+)
+
+// newAutoStartServer returns a server using vncViewer and the given
+// auto-start timeout in seconds
+func newAutoStartServer(t *testing.T, vncViewer string, timeout int) *Server {
+	t.Helper()
+
+	s := newTestServer(t)
+	err := s.configFile.Update(configuration.UpdateConfig{
+		ListenPort:       8080,
+		VncViewer:        vncViewer,
+		AutoStartTimeout: timeout,
+		Force:            true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// fakeVncViewer writes a viewer that exits right away
+func fakeVncViewer(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "vncviewer")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// waitForViewerExit waits until the fake viewer has exited and its session
+// has been cleared, so nothing writes to the test's files afterwards
+func waitForViewerExit(t *testing.T, s *Server) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.cmdLock.Lock()
+		running := s.currentCmd != nil
+		s.cmdLock.Unlock()
+		if !running {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("viewer did not exit")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// openPort returns the port of a listener that accepts connections
+func openPort(t *testing.T) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// closedPort returns a port nothing listens on
+func closedPort(t *testing.T) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+func addVncDevice(t *testing.T, s *Server, id string, port int) device.Device {
+	t.Helper()
+
+	d, err := s.configFile.AddDevice(device.Device{ID: id, Name: id, IPAddress: "127.0.0.1", Port: port, Protocol: "vnc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestAutoStartFallback(t *testing.T) {
+	s := newAutoStartServer(t, fakeVncViewer(t), 10)
+	preferred := addVncDevice(t, s, "preferred", closedPort(t))
+	fallback := addVncDevice(t, s, "fallback", openPort(t))
+
+	s.runAutoStart(context.Background(), []device.Device{preferred, fallback}, "auto_start")
+	waitForViewerExit(t, s)
+
+	status := s.autoStart.get()
+	if status.State != AutoStartConnected || status.DeviceID != "fallback" || status.Error != "" {
+		t.Errorf("status = %+v", status)
+	}
+	// The preferred device was probed first
+	if status.Attempts != 2 || status.Candidates[0] != "preferred" {
+		t.Errorf("attempts = %d, candidates = %v", status.Attempts, status.Candidates)
+	}
+}
+
+func TestAutoStartPrefersFirstReachable(t *testing.T) {
+	s := newAutoStartServer(t, fakeVncViewer(t), 10)
+	preferred := addVncDevice(t, s, "preferred", openPort(t))
+	fallback := addVncDevice(t, s, "fallback", openPort(t))
+
+	s.runAutoStart(context.Background(), []device.Device{preferred, fallback}, "auto_start")
+	waitForViewerExit(t, s)
+
+	if status := s.autoStart.get(); status.DeviceID != "preferred" || status.Attempts != 1 {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestAutoStartViewerFails(t *testing.T) {
+	s := newAutoStartServer(t, filepath.Join(t.TempDir(), "missing-viewer"), 10)
+	pc := addVncDevice(t, s, "pc1", openPort(t))
+
+	s.runAutoStart(context.Background(), []device.Device{pc}, "auto_start")
+
+	status := s.autoStart.get()
+	if status.State != AutoStartFailed || status.DeviceID != "pc1" || status.Error == "" {
+		t.Errorf("status = %+v, want failed with an error", status)
+	}
+	if connected := s.currentDeviceId; connected != "" {
+		t.Errorf("connected to %q", connected)
+	}
+}
+
+func TestAutoStartTimeout(t *testing.T) {
+	s := newAutoStartServer(t, fakeVncViewer(t), 1)
+	pc := addVncDevice(t, s, "pc1", closedPort(t))
+
+	started := time.Now()
+	s.runAutoStart(context.Background(), []device.Device{pc}, "restore")
+
+	status := s.autoStart.get()
+	if status.State != AutoStartFailed || status.Source != "restore" || status.Attempts < 1 || status.Error == "" {
+		t.Errorf("status = %+v", status)
+	}
+	if elapsed := time.Since(started); elapsed < time.Second || elapsed > 5*time.Second {
+		t.Errorf("auto-start gave up after %v, want about a second", elapsed)
+	}
+}
+
+func TestAutoStartCancelled(t *testing.T) {
+	s := newAutoStartServer(t, fakeVncViewer(t), 10)
+	pc := addVncDevice(t, s, "pc1", closedPort(t))
+
+	done := make(chan struct{})
+	go func() {
+		s.runAutoStart(context.Background(), []device.Device{pc}, "auto_start")
+		close(done)
+	}()
+
+	// Wait for the first probe, then connect manually
+	for s.autoStart.get().Attempts == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.autoStart.stop()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("auto-start was not cancelled")
+	}
+	if status := s.autoStart.get(); status.State != AutoStartCancelled {
+		t.Errorf("status = %+v", status)
+	}
+}
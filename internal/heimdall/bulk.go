@@ -0,0 +1,84 @@
+package heimdall
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"spark-heimdall/internal/device"
+)
+
+// BulkRequest is a list of device operations applied together
+type BulkRequest struct {
+	Operations []device.Operation `json:"operations"`
+}
+
+// BulkResponse reports on every operation of a bulk request
+type BulkResponse struct {
+	// Applied is set if all operations succeeded
+	Applied bool                     `json:"applied"`
+	Error   string                   `json:"error,omitempty"`
+	Results []device.OperationResult `json:"results"`
+}
+
+// HandleBulkPCs applies add, update, delete and patch operations
+// all-or-nothing with a single save. If any operation fails, nothing is
+// changed and the response has status 422 with the error of every failed
+// operation.
+func (s *Server) HandleBulkPCs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request BulkRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if s.configFile.RequireIfMatch {
+		for i, op := range request.Operations {
+			if op.Op != device.OpAdd && op.Revision == 0 {
+				http.Error(w, fmt.Sprintf("operation %d: the revision to change is required", i), http.StatusPreconditionRequired)
+				return
+			}
+		}
+	}
+
+	results, err := s.configFile.ApplyDeviceOperations(request.Operations)
+	if results == nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Changes that could not be saved have been undone
+	response := BulkResponse{Applied: err == nil, Results: results}
+	status := http.StatusOK
+	switch {
+	case errors.Is(err, device.ErrBulkFailed):
+		response.Error = err.Error()
+		status = http.StatusUnprocessableEntity
+	case err != nil:
+		log.Printf("Error saving bulk changes: %v", err)
+		response.Error = err.Error()
+		status = http.StatusInternalServerError
+	}
+
+	if response.Applied {
+		for _, result := range results {
+			if result.Op != device.OpDelete {
+				continue
+			}
+			if err := s.state.Forget(result.ID); err != nil {
+				log.Printf("Failed to save device usage: %v", err)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
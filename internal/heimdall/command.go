@@ -0,0 +1,266 @@
+package heimdall
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"spark-heimdall/internal/device"
+	"spark-heimdall/internal/viewer"
+	"strings"
+)
+
+// redacted replaces secrets in command previews and logs
+const redacted = "********"
+
+// CommandPreview describes what connectToPC would execute for a device
+type CommandPreview struct {
+	Path         string               `json:"path"`
+	Args         []string             `json:"args"`
+	Env          []string             `json:"env"`
+	Dir          string               `json:"dir"`
+	PasswordFile *PasswordFilePreview `json:"password_file,omitempty"`
+	Error        string               `json:"error,omitempty"`
+}
+
+// PasswordFilePreview reports on a password file passed to the viewer
+// without revealing its contents
+type PasswordFilePreview struct {
+	Path     string `json:"path"`
+	Exists   bool   `json:"exists"`
+	Contents string `json:"contents,omitempty"`
+}
+
+// buildCommand returns the viewer command for pc without starting it
+func (s *Server) buildCommand(pc device.Device) (*exec.Cmd, error) {
+	settings := s.configFile.Settings()
+	viewers := s.viewers()
+	switch pc.Protocol {
+	case "vnc":
+		profile := viewers.VncViewer.Profile
+		args := []string{vncAddress(profile, pc.IPAddress, pc.ConnectPort())}
+
+		if pc.IsFullScreen() {
+			args = append(args, "-FullScreen")
+		}
+
+		args = append(args, "-PasswordFile", settings.VncPasswordFile)
+		args = append(args, pc.ViewerArgs...)
+
+		return exec.Command(settings.VncViewer, args...), nil
+	case "rdp":
+		if viewers.RdpViewer.Profile == viewer.ProfileFreeRDP {
+			return exec.Command(settings.RdpViewerPath(), freeRDPArgs(pc)...), nil
+		}
+
+		args := []string{"-u", pc.Username}
+
+		if pc.Password != "" {
+			args = append(args, "-p", pc.Password)
+		}
+
+		if pc.IsFullScreen() {
+			args = append(args, "-f")
+		}
+
+		// rdesktop expects the server last
+		args = append(args, pc.ViewerArgs...)
+		args = append(args, rdpAddress(pc.IPAddress, pc.Port))
+
+		return exec.Command(settings.RdpViewerPath(), args...), nil
+	default:
+		return nil, fmt.Errorf("unknown protocol: %s", pc.Protocol)
+	}
+}
+
+// freeRDPArgs formats connection arguments in FreeRDP's /option:value style
+func freeRDPArgs(pc device.Device) []string {
+	args := []string{"/v:" + rdpAddress(pc.IPAddress, pc.Port)}
+
+	if pc.Username != "" {
+		args = append(args, "/u:"+pc.Username)
+	}
+
+	if pc.Password != "" {
+		args = append(args, "/p:"+pc.Password)
+	}
+
+	if pc.IsFullScreen() {
+		args = append(args, "/f")
+	}
+
+	if pc.RDP.Gateway != "" {
+		args = append(args, "/g:"+pc.RDP.Gateway)
+	}
+	if pc.RDP.RedirectClipboard {
+		args = append(args, "+clipboard")
+	}
+	if pc.RDP.RedirectDrives {
+		args = append(args, "+drives")
+	}
+	if pc.RDP.RedirectPrinters {
+		args = append(args, "/printer")
+	}
+	if pc.RDP.RedirectSmartCards {
+		args = append(args, "/smartcard")
+	}
+
+	return append(args, pc.ViewerArgs...)
+}
+
+// viewerHost brackets IPv6 addresses so their colons aren't read as a port
+func viewerHost(host string) string {
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// vncAddress formats the target for a VNC viewer. TigerVNC, TightVNC and
+// RealVNC read "host:N" as display number N if it is below 100, so they are
+// given "host::port", which is always a port. Other viewers get "host:port".
+func vncAddress(profile, host string, port int) string {
+	switch profile {
+	case viewer.ProfileTigerVNC, viewer.ProfileTightVNC, viewer.ProfileRealVNC:
+		return fmt.Sprintf("%s::%d", viewerHost(host), port)
+	default:
+		return fmt.Sprintf("%s:%d", viewerHost(host), port)
+	}
+}
+
+// rdpAddress formats the target for FreeRDP and rdesktop, which both take
+// "host:port" and leave out the port to use the default
+func rdpAddress(host string, port int) string {
+	if port == 0 {
+		return viewerHost(host)
+	}
+	return fmt.Sprintf("%s:%d", viewerHost(host), port)
+}
+
+// previewCommand resolves everything cmd would run with and masks secrets
+func (s *Server) previewCommand(cmd *exec.Cmd, pc device.Device) CommandPreview {
+	preview := CommandPreview{
+		Path: cmd.Path,
+		Args: maskArgs(cmd.Args, pc.Password),
+		Env:  maskEnv(cmd.Env, pc.Password),
+		Dir:  cmd.Dir,
+	}
+
+	if cmd.Env == nil {
+		preview.Env = maskEnv(os.Environ(), pc.Password)
+	}
+
+	if preview.Dir == "" {
+		if wd, err := os.Getwd(); err == nil {
+			preview.Dir = wd
+		}
+	}
+
+	if cmd.Err != nil {
+		preview.Error = cmd.Err.Error()
+	}
+
+	for i, arg := range cmd.Args {
+		if arg == "-PasswordFile" && i+1 < len(cmd.Args) {
+			path := cmd.Args[i+1]
+			_, err := os.Stat(path)
+			preview.PasswordFile = &PasswordFilePreview{Path: path, Exists: err == nil}
+			if err == nil {
+				preview.PasswordFile.Contents = redacted
+			}
+		}
+	}
+
+	return preview
+}
+
+// passwordFlags are options followed by a password, e.g. rdesktop's -p
+var passwordFlags = []string{"-p", "-passwd", "-password", "--password"}
+
+// passwordPrefixes are options with the password attached, e.g. FreeRDP's /p:
+var passwordPrefixes = []string{"/p:", "/password:", "-passwd=", "-password=", "--password="}
+
+// maskArgs hides the passwords given with password options and arguments
+// that are the device password. Other arguments are left as they are, even
+// if they happen to contain the password, e.g. "1" in "/size:1920x1080".
+func maskArgs(args []string, password string) []string {
+	masked := make([]string, len(args))
+	for i, arg := range args {
+		switch {
+		case i > 0 && slices.Contains(passwordFlags, args[i-1]):
+			masked[i] = redacted
+		case password != "" && arg == password:
+			masked[i] = redacted
+		default:
+			masked[i] = maskPasswordOption(arg)
+		}
+	}
+	return masked
+}
+
+// maskPasswordOption hides the password of an option like "/p:secret"
+func maskPasswordOption(arg string) string {
+	for _, prefix := range passwordPrefixes {
+		if len(arg) > len(prefix) && strings.EqualFold(arg[:len(prefix)], prefix) {
+			return arg[:len(prefix)] + redacted
+		}
+	}
+	return arg
+}
+
+// maskEnv hides values of variables that look like credentials
+func maskEnv(env []string, password string) []string {
+	if env == nil {
+		return nil
+	}
+
+	masked := make([]string, len(env))
+	for i, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		upper := strings.ToUpper(key)
+		switch {
+		case strings.Contains(upper, "PASS"), strings.Contains(upper, "SECRET"),
+			strings.Contains(upper, "TOKEN"), strings.Contains(upper, "KEY"):
+			masked[i] = key + "=" + redacted
+		case password != "" && value == password:
+			masked[i] = key + "=" + redacted
+		case value != "":
+			masked[i] = key + "=" + maskPasswordOption(value)
+		default:
+			masked[i] = kv
+		}
+	}
+	return masked
+}
+
+// HandleCommandPreview returns the command that connecting to a device
+// would run, without launching anything
+func (s *Server) HandleCommandPreview(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pc, found := s.configFile.Store.GetEffective(id)
+	if !found {
+		http.Error(w, "PC not found", http.StatusNotFound)
+		return
+	}
+	// Show the address connectToPC would pass, e.g. for .local names
+	pc.IPAddress = s.viewerAddress(pc)
+
+	cmd, err := s.buildCommand(pc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(s.previewCommand(cmd, pc))
+	if err != nil {
+		log.Printf("Error encoding command preview: %v", err)
+	}
+}
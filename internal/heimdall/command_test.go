@@ -0,0 +1,201 @@
+package heimdall
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	configuration "spark-heimdall/internal/config"
+	"spark-heimdall/internal/device"
+	"spark-heimdall/internal/resolver"
+	"spark-heimdall/internal/viewer"
+	"testing"
+)
+
+func TestViewerAddresses(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{vncAddress(viewer.ProfileTigerVNC, "10.0.0.1", 5900), "10.0.0.1::5900"},
+		{vncAddress(viewer.ProfileTigerVNC, "fe80::1%eth0", 5901), "[fe80::1%eth0]::5901"},
+		{vncAddress(viewer.ProfileUnknown, "desk.local", 5900), "desk.local:5900"},
+		{vncAddress(viewer.ProfileUnknown, "::1", 5900), "[::1]:5900"},
+		{rdpAddress("2001:db8::1", 0), "[2001:db8::1]"},
+		{rdpAddress("2001:db8::1", 3390), "[2001:db8::1]:3390"},
+		{rdpAddress("server", 0), "server"},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("got %q, want %q", test.got, test.want)
+		}
+	}
+}
+
+func TestFreeRDPArgs(t *testing.T) {
+	pc := device.Device{
+		IPAddress:  "server",
+		Protocol:   "rdp",
+		Username:   `CORP\alice`,
+		FullScreen: device.Bool(true),
+		RDP: device.RDPOptions{
+			Gateway:           "gw.example.com:443",
+			RedirectClipboard: true,
+			RedirectDrives:    true,
+		},
+		ViewerArgs: []string{"/dynamic-resolution"},
+	}
+
+	want := []string{"/v:server", `/u:CORP\alice`, "/f", "/g:gw.example.com:443", "+clipboard", "+drives", "/dynamic-resolution"}
+	if got := freeRDPArgs(pc); !slices.Equal(got, want) {
+		t.Errorf("freeRDPArgs() = %q, want %q", got, want)
+	}
+}
+
+func TestBuildCommandInspectsViewerOnDemand(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho 'This is FreeRDP version 2.11.5'\n"
+	if err := os.WriteFile(filepath.Join(dir, "xfreerdp"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	// Connecting before the startup probe finished, e.g. on auto-start
+	s := newTestServer(t)
+	if err := s.configFile.Update(configuration.UpdateConfig{ListenPort: 8080, RdpViewer: "xfreerdp", Force: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd, err := s.buildCommand(device.Device{IPAddress: "server", Protocol: "rdp", Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"xfreerdp", "/v:server", "/u:alice"}; !slices.Equal(cmd.Args, want) {
+		t.Errorf("args = %q, want %q", cmd.Args, want)
+	}
+}
+
+func TestMaskArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		password string
+		want     []string
+	}{
+		// rdesktop takes the password after -p
+		{[]string{"rdesktop", "-u", "bob", "-p", "hunter2", "server"}, "hunter2", []string{"rdesktop", "-u", "bob", "-p", redacted, "server"}},
+		// FreeRDP has it inside /p:
+		{[]string{"xfreerdp", "/v:server", "/p:hunter2", "/f"}, "hunter2", []string{"xfreerdp", "/v:server", "/p:" + redacted, "/f"}},
+		// Values after -p are hidden even if they aren't the device password
+		{[]string{"rdesktop", "-p", "other"}, "", []string{"rdesktop", "-p", redacted}},
+		{[]string{"vncviewer", "server::5900"}, "", []string{"vncviewer", "server::5900"}},
+		// A short password only hides the arguments that carry it
+		{
+			[]string{"xfreerdp", "/v:10.0.0.1:3389", "/size:1920x1080", "/P:1", "-passwd", "1", "1"}, "1",
+			[]string{"xfreerdp", "/v:10.0.0.1:3389", "/size:1920x1080", "/P:" + redacted, "-passwd", redacted, redacted},
+		},
+	}
+	for _, test := range tests {
+		if got := maskArgs(test.args, test.password); !slices.Equal(got, test.want) {
+			t.Errorf("maskArgs(%v) = %v, want %v", test.args, got, test.want)
+		}
+	}
+
+	args := []string{"xfreerdp", "/p:hunter2"}
+	maskArgs(args, "hunter2")
+	if args[1] != "/p:hunter2" {
+		t.Error("maskArgs changed its argument")
+	}
+}
+
+func TestMaskEnv(t *testing.T) {
+	if maskEnv(nil, "hunter2") != nil {
+		t.Error("a nil environment should stay nil")
+	}
+
+	env := []string{
+		"HOME=/home/bob",
+		"VNC_PASSWORD=hunter2",
+		"CLIENT_SECRET=abc",
+		"GITHUB_TOKEN=ghp",
+		"SSH_AUTH_KEY=key",
+		"EXTRA=--password=hunter2",
+		"VNC_PW=hunter2",
+		"DISPLAY=:2",
+		"EMPTY",
+	}
+	want := []string{
+		"HOME=/home/bob",
+		"VNC_PASSWORD=" + redacted,
+		"CLIENT_SECRET=" + redacted,
+		"GITHUB_TOKEN=" + redacted,
+		"SSH_AUTH_KEY=" + redacted,
+		"EXTRA=--password=" + redacted,
+		"VNC_PW=" + redacted,
+		"DISPLAY=:2",
+		"EMPTY",
+	}
+	if got := maskEnv(env, "hunter2"); !slices.Equal(got, want) {
+		t.Errorf("maskEnv = %v, want %v", got, want)
+	}
+}
+
+func TestPreviewCommand(t *testing.T) {
+	s := newTestServer(t)
+	passwordFile := filepath.Join(t.TempDir(), "passwd")
+	if err := os.WriteFile(passwordFile, []byte("obfuscated"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pc := device.Device{ID: "pc1", Password: "hunter2"}
+	cmd := exec.Command("xfreerdp", "/v:server", "/p:hunter2", "-PasswordFile", passwordFile)
+	cmd.Env = []string{"PASSWORD=hunter2", "DISPLAY=:0"}
+	cmd.Dir = "/tmp"
+
+	preview := s.previewCommand(cmd, pc)
+	if !slices.Equal(preview.Args, []string{"xfreerdp", "/v:server", "/p:" + redacted, "-PasswordFile", passwordFile}) {
+		t.Errorf("args = %v", preview.Args)
+	}
+	if !slices.Equal(preview.Env, []string{"PASSWORD=" + redacted, "DISPLAY=:0"}) {
+		t.Errorf("env = %v", preview.Env)
+	}
+	if preview.Dir != "/tmp" {
+		t.Errorf("dir = %q", preview.Dir)
+	}
+
+	// The password file is reported but never read out
+	file := preview.PasswordFile
+	if file == nil || file.Path != passwordFile || !file.Exists || file.Contents != redacted {
+		t.Errorf("password file = %+v", file)
+	}
+
+	cmd = exec.Command("vncviewer", "-PasswordFile", filepath.Join(t.TempDir(), "missing"))
+	if file := s.previewCommand(cmd, pc).PasswordFile; file == nil || file.Exists || file.Contents != "" {
+		t.Errorf("missing password file = %+v", file)
+	}
+}
+
+func TestCommandPreviewResolvesLocalNames(t *testing.T) {
+	s := newTestServer(t)
+	s.resolver = resolver.NewWithLookup(func(ctx context.Context, host string) ([]netip.Addr, error) {
+		return []netip.Addr{netip.MustParseAddr("192.168.1.20")}, nil
+	})
+	rec := postJSON(t, s.HandleAddPC, "/api/pcs/add", device.Device{ID: "desk", Name: "Desk", IPAddress: "desk.local", Protocol: "vnc"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add PC: %d %s", rec.Code, rec.Body)
+	}
+
+	// Viewers can't resolve .local names, so connectToPC passes the address
+	rec = httptest.NewRecorder()
+	s.HandlePCRoute(rec, httptest.NewRequest("GET", "/api/pcs/desk/command", nil))
+	var preview CommandPreview
+	if err := json.NewDecoder(rec.Body).Decode(&preview); err != nil {
+		t.Fatal(err)
+	}
+	if len(preview.Args) < 2 || preview.Args[1] != "192.168.1.20:5900" {
+		t.Errorf("args = %q, want the resolved address", preview.Args)
+	}
+}
@@ -0,0 +1,297 @@
+package heimdall
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"spark-heimdall/internal/device"
+	"spark-heimdall/internal/devicecsv"
+	"strconv"
+	"strings"
+)
+
+// CSV import match modes
+const (
+	MatchByID      = "id"
+	MatchByAddress = "address"
+)
+
+// HandleExportCSV downloads the devices as CSV. columns selects the
+// columns, by default all but the password, and the filters of the device
+// API select the devices.
+func (s *Server) HandleExportCSV(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query, err := s.parseDeviceQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	columns := devicecsv.DefaultColumns()
+	if value := r.URL.Query().Get("columns"); value != "" {
+		columns = strings.Split(value, ",")
+		for i := range columns {
+			columns[i] = strings.TrimSpace(columns[i])
+		}
+	}
+	if err := devicecsv.ValidateColumns(columns); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	devices, _ := s.configFile.Store.GetAll().Query(query, s.configFile.Store.GetGroups())
+
+	w.Header().Set("Content-Type", devicecsv.ContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="devices.csv"`)
+	if err := devicecsv.Write(w, devices, columns); err != nil {
+		log.Printf("Error writing CSV export: %v", err)
+	}
+}
+
+// CSVRow reports on one row of a CSV import
+type CSVRow struct {
+	// Line is the row's line number in the file
+	Line int `json:"line"`
+	// Action is "add" or "update", or empty if the row has errors
+	Action string              `json:"action,omitempty"`
+	Device device.Device       `json:"device"`
+	Error  string              `json:"error,omitempty"`
+	Fields []device.FieldError `json:"fields,omitempty"`
+}
+
+// CSVImportResult is returned by the CSV import API
+type CSVImportResult struct {
+	Preview bool   `json:"preview"`
+	Match   string `json:"match"`
+	// Mapping maps the file's headers to the columns they were read into
+	Mapping map[string]string `json:"mapping"`
+	Ignored []string          `json:"ignored,omitempty"`
+	Rows    []CSVRow          `json:"rows"`
+	Added   int               `json:"added"`
+	Updated int               `json:"updated"`
+	Failed  int               `json:"failed"`
+}
+
+// HandleImportCSV adds and updates devices from a CSV file, uploaded as a
+// multipart "file" field or sent as the request body. Rows update the
+// device with the same ID or, with match=address, the same address, and
+// only change the fields that have a column. Other rows add devices. The
+// mapping parameter maps headers to columns as a JSON object. Every row is
+// validated on its own, so invalid rows don't stop the others. Rows see the
+// changes of the rows before them, also in a preview, and the config is
+// saved once at the end.
+func (s *Server) HandleImportCSV(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	preview, err := parsePreview(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	match := r.URL.Query().Get("match")
+	if match == "" {
+		match = MatchByID
+	}
+	if match != MatchByID && match != MatchByAddress {
+		http.Error(w, fmt.Sprintf("match must be %s or %s", MatchByID, MatchByAddress), http.StatusBadRequest)
+		return
+	}
+
+	data, err := readCSVUpload(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var mapping map[string]string
+	if value := r.FormValue("mapping"); value != "" {
+		if err := json.Unmarshal([]byte(value), &mapping); err != nil {
+			http.Error(w, "mapping must be a JSON object of header names to columns: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	table, err := devicecsv.Read(bytes.NewReader(data), mapping)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if match == MatchByAddress && !table.HasColumn("ip_address") {
+		http.Error(w, "matching by address needs an ip_address column", http.StatusBadRequest)
+		return
+	}
+
+	result := CSVImportResult{
+		Preview: preview,
+		Match:   match,
+		Mapping: table.Mapping,
+		Ignored: table.Ignored,
+		Rows:    make([]CSVRow, len(table.Rows)),
+	}
+	importRows := func(work *device.Store) {
+		for i, row := range table.Rows {
+			result.Rows[i] = importCSVRow(work, row, match)
+		}
+	}
+	if preview {
+		importRows(s.configFile.Store.Copy())
+	} else if err := s.configFile.EditDevices(importRows); err != nil {
+		log.Printf("Error saving CSV import: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, row := range result.Rows {
+		switch row.Action {
+		case "":
+			result.Failed++
+		case "add":
+			if !preview {
+				result.Added++
+			}
+		case "update":
+			if !preview {
+				result.Updated++
+			}
+		}
+	}
+
+	if !preview {
+		log.Printf("CSV import: %d added, %d updated, %d failed", result.Added, result.Updated, result.Failed)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// readCSVUpload returns the uploaded file or the request body
+func readCSVUpload(r *http.Request) ([]byte, error) {
+	if err := parseUpload(r); err != nil {
+		return nil, err
+	}
+	if r.MultipartForm == nil {
+		return io.ReadAll(r.Body)
+	}
+
+	files, err := uploadedFiles(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) != 1 {
+		return nil, errors.New("upload one CSV file at a time")
+	}
+	return files[0].data, nil
+}
+
+// importCSVRow adds or updates the device of one row in work
+func importCSVRow(work *device.Store, row devicecsv.Row, match string) CSVRow {
+	result := CSVRow{Line: row.Line}
+
+	existing, found, err := matchCSVRow(work, row, match)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if found {
+		result.Device = existing
+	} else {
+		// Like devices added in the dashboard, new devices default to full screen
+		result.Device.FullScreen = device.Bool(true)
+	}
+
+	if err := row.Apply(&result.Device); err != nil {
+		return result.failed(err)
+	}
+	if found && row.Values["id"] == "" {
+		// An empty id cell doesn't rename the matched device
+		result.Device.ID = existing.ID
+	}
+
+	switch {
+	case found && result.Device.ID != existing.ID:
+		result.Error = fmt.Sprintf("id %s does not match device %s with the same address", result.Device.ID, existing.ID)
+		return result
+	case found:
+		if err = work.Update(result.Device); err == nil {
+			result.Device, _ = work.Get(existing.ID)
+		}
+	default:
+		result.Device, err = work.Add(result.Device)
+	}
+	if err != nil {
+		return result.failed(err)
+	}
+
+	result.Action = "add"
+	if found {
+		result.Action = "update"
+	}
+	return result
+}
+
+// failed records err, including the invalid fields of a validation error
+func (r CSVRow) failed(err error) CSVRow {
+	r.Error = err.Error()
+	r.Fields = fieldErrors(err)
+	return r
+}
+
+// matchCSVRow returns the device of work a row updates, if any
+func matchCSVRow(work *device.Store, row devicecsv.Row, match string) (device.Device, bool, error) {
+	if match == MatchByID {
+		id := row.Values["id"]
+		if id == "" {
+			return device.Device{}, false, nil
+		}
+		d, found := work.Get(id)
+		return d, found, nil
+	}
+
+	address := row.Values["ip_address"]
+	if address == "" {
+		return device.Device{}, false, nil
+	}
+
+	var matches device.Devices
+	for _, d := range work.GetAll() {
+		if strings.EqualFold(d.IPAddress, address) {
+			matches = append(matches, d)
+		}
+	}
+
+	// Several services on one host are told apart by protocol and port
+	if len(matches) > 1 && row.Values["protocol"] != "" {
+		matches = slices.DeleteFunc(matches, func(d device.Device) bool { return work.Effective(d).Protocol != row.Values["protocol"] })
+	}
+	if len(matches) > 1 && row.Values["port"] != "" {
+		if port, err := strconv.Atoi(row.Values["port"]); err == nil {
+			matches = slices.DeleteFunc(matches, func(d device.Device) bool {
+				return d.Port != port && work.Effective(d).ConnectPort() != port
+			})
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return device.Device{}, false, nil
+	case 1:
+		return matches[0], true, nil
+	default:
+		return device.Device{}, false, fmt.Errorf("address %s matches %d devices, add protocol and port columns to choose one", address, len(matches))
+	}
+}
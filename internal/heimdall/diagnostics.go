@@ -0,0 +1,96 @@
+package heimdall
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"spark-heimdall/internal/viewer"
+	"sync"
+)
+
+// Diagnostics reports how the configured viewers resolve on this host
+type Diagnostics struct {
+	VncViewer       viewer.Info         `json:"vnc_viewer"`
+	RdpViewer       viewer.Info         `json:"rdp_viewer"`
+	VncPasswordFile PasswordFilePreview `json:"vnc_password_file"`
+}
+
+type diagnostics struct {
+	lock    sync.RWMutex
+	current Diagnostics
+	// loaded is false until the viewers have been inspected once
+	loaded bool
+}
+
+func (d *diagnostics) get() Diagnostics {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.current
+}
+
+// load returns the diagnostics and whether the viewers have been inspected
+func (d *diagnostics) load() (Diagnostics, bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.current, d.loaded
+}
+
+func (d *diagnostics) set(current Diagnostics) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.current = current
+	d.loaded = true
+}
+
+// viewers returns the viewer diagnostics. Commands built before the startup
+// probe has finished would otherwise use the wrong argument style, so the
+// viewers are inspected here if needed.
+func (s *Server) viewers() Diagnostics {
+	if current, loaded := s.diagnostics.load(); loaded {
+		return current
+	}
+	s.refreshDiagnostics()
+	return s.diagnostics.get()
+}
+
+// refreshDiagnostics resolves the configured viewers and logs any problems
+func (s *Server) refreshDiagnostics() {
+	settings := s.configFile.Settings()
+	if settings.RdpViewer == "" && settings.DetectedRdpViewer != "" {
+		log.Printf("No RDP viewer configured, using %s", settings.DetectedRdpViewer)
+	}
+
+	current := Diagnostics{
+		VncViewer: viewer.Inspect(settings.VncViewer),
+		RdpViewer: viewer.Inspect(settings.RdpViewerPath()),
+		VncPasswordFile: PasswordFilePreview{
+			Path: settings.VncPasswordFile,
+		},
+	}
+	if _, err := os.Stat(settings.VncPasswordFile); err == nil {
+		current.VncPasswordFile.Exists = true
+	}
+
+	logViewerInfo("VNC", current.VncViewer)
+	logViewerInfo("RDP", current.RdpViewer)
+
+	s.diagnostics.set(current)
+}
+
+func logViewerInfo(name string, info viewer.Info) {
+	if info.Found {
+		log.Printf("%s viewer: %s (version %q, profile %q)", name, info.Path, info.Version, info.Profile)
+	} else {
+		log.Printf("⚠️ %s viewer unavailable: %s", name, info.Error)
+	}
+}
+
+// HandleDiagnostics returns the viewer discovery results
+func (s *Server) HandleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(s.diagnostics.get())
+	if err != nil {
+		log.Printf("Error encoding diagnostics: %v", err)
+	}
+}
@@ -0,0 +1,363 @@
+package heimdall
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"spark-heimdall/internal/device"
+	"spark-heimdall/internal/discovery"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discoveryTimeout bounds a whole discovery scan
+const discoveryTimeout = 2 * time.Minute
+
+// Candidate is a discovered service that is not configured as a device yet
+type Candidate struct {
+	// ID identifies the candidate when adopting it, "address:port"
+	ID string `json:"id"`
+	discovery.Service
+	// Adoptable is set for services Heimdall can connect to
+	Adoptable bool `json:"adoptable"`
+}
+
+// DiscoveryResult is returned by the discovery APIs and describes the
+// running or last scan
+type DiscoveryResult struct {
+	Running    bool        `json:"running"`
+	Ranges     []string    `json:"ranges"`
+	Candidates []Candidate `json:"candidates"`
+	// Error is set if the last scan failed
+	Error string `json:"error,omitempty"`
+}
+
+// DiscoverRequest starts a scan. An empty CIDR scans the configured range
+// or the local networks.
+type DiscoverRequest struct {
+	CIDR string `json:"cidr"`
+}
+
+// discoveryState tracks the running scan and holds the candidates of the
+// last scan for adoption
+type discoveryState struct {
+	lock       sync.Mutex
+	result     DiscoveryResult
+	cancel     context.CancelFunc
+	candidates map[string]Candidate
+}
+
+// begin marks a scan of ranges as running, returning false if one already is
+func (d *discoveryState) begin(ranges []netip.Prefix, cancel context.CancelFunc) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.result.Running {
+		return false
+	}
+
+	d.result = DiscoveryResult{Running: true, Candidates: []Candidate{}}
+	for _, prefix := range ranges {
+		d.result.Ranges = append(d.result.Ranges, prefix.String())
+	}
+	d.cancel = cancel
+	return true
+}
+
+// finish records the outcome of the running scan. Candidates of a failed
+// scan are not kept, the previous ones stay adoptable.
+func (d *discoveryState) finish(candidates []Candidate, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.result.Running = false
+	d.cancel()
+	d.cancel = nil
+
+	if err != nil {
+		d.result.Error = err.Error()
+		return
+	}
+	d.result.Candidates = candidates
+	d.candidates = make(map[string]Candidate, len(candidates))
+	for _, c := range candidates {
+		d.candidates[c.ID] = c
+	}
+}
+
+// status returns the running or last scan
+func (d *discoveryState) status() DiscoveryResult {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.result
+}
+
+// stop cancels the running scan, if any
+func (d *discoveryState) stop() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.cancel != nil {
+		d.cancel()
+	}
+}
+
+func (d *discoveryState) get(id string) (Candidate, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	c, found := d.candidates[id]
+	return c, found
+}
+
+func (d *discoveryState) remove(id string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.candidates, id)
+}
+
+// discoveryRanges returns the networks to scan: cidr, the configured range
+// or the local networks. A given cidr must lie within the configured range
+// or a local network, so the API can't be used to scan other networks.
+func (s *Server) discoveryRanges(cidr string) ([]netip.Prefix, error) {
+	var allowed []netip.Prefix
+	if configured := s.configFile.Settings().DiscoveryCIDR; configured != "" {
+		prefix, err := netip.ParsePrefix(configured)
+		if err != nil {
+			return nil, err
+		}
+		allowed = append(allowed, prefix.Masked())
+	}
+
+	if cidr == "" {
+		if len(allowed) > 0 {
+			return allowed, nil
+		}
+		prefixes := discovery.LocalPrefixes()
+		if len(prefixes) == 0 {
+			return nil, errors.New("no discovery range configured and no local network found")
+		}
+		return prefixes, nil
+	}
+
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, err
+	}
+	prefix = prefix.Masked()
+
+	for _, network := range append(allowed, discovery.LocalPrefixes()...) {
+		if network.Bits() <= prefix.Bits() && network.Contains(prefix.Addr()) {
+			return []netip.Prefix{prefix}, nil
+		}
+	}
+	return nil, fmt.Errorf("range %s is not part of the configured discovery range or a local network", prefix)
+}
+
+// HandleDiscover starts a scan of the network in the background. Its
+// progress and the services that don't belong to a configured device are
+// reported by HandleDiscoverStatus.
+func (s *Server) HandleDiscover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Scans are slow and noisy, don't let other sites start them
+	if !sameOrigin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var request DiscoverRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ranges, err := s.discoveryRanges(request.CIDR)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, prefix := range ranges {
+		if _, err := discovery.Hosts(prefix); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The scan outlives the request and is cancelled on shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	if !s.discovery.begin(ranges, cancel) {
+		cancel()
+		http.Error(w, "A discovery scan is already running", http.StatusConflict)
+		return
+	}
+	go s.runDiscovery(ctx, ranges)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(s.discovery.status())
+}
+
+// runDiscovery scans ranges and records the new services
+func (s *Server) runDiscovery(ctx context.Context, ranges []netip.Prefix) {
+	candidates := []Candidate{}
+	for _, prefix := range ranges {
+		log.Printf("Scanning %s for devices", prefix)
+		services, err := discovery.Scan(ctx, prefix, discovery.Options{})
+		if err != nil {
+			log.Printf("Discovery scan of %s failed: %v", prefix, err)
+			s.discovery.finish(nil, fmt.Errorf("discovery scan of %s failed: %w", prefix, err))
+			return
+		}
+		candidates = append(candidates, s.newCandidates(services)...)
+	}
+
+	log.Printf("Discovery found %d new services", len(candidates))
+	s.discovery.finish(candidates, nil)
+}
+
+// HandleDiscoverStatus returns the running or last discovery scan
+func (s *Server) HandleDiscoverStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.discovery.status())
+}
+
+// newCandidates drops services that are already configured. VNC and RDP
+// services match a device on address and port, SSH services match any
+// device on the same address.
+func (s *Server) newCandidates(services []discovery.Service) []Candidate {
+	knownServices := make(map[string]bool)
+	knownHosts := make(map[string]bool)
+	for _, d := range s.configFile.Store.GetAllEffective() {
+		address := d.IPAddress
+		if resolved, found := s.resolver.Cached(d.IPAddress); found {
+			address = resolved
+		}
+		knownHosts[address] = true
+		knownServices[net.JoinHostPort(address, strconv.Itoa(d.ConnectPort()))] = true
+	}
+
+	var candidates []Candidate
+	for _, service := range services {
+		address := service.Address.String()
+		id := net.JoinHostPort(address, strconv.Itoa(service.Port))
+
+		if knownServices[id] || (service.Protocol == discovery.ProtocolSSH && knownHosts[address]) {
+			continue
+		}
+
+		candidates = append(candidates, Candidate{
+			ID:        id,
+			Service:   service,
+			Adoptable: service.Protocol == discovery.ProtocolVNC || service.Protocol == discovery.ProtocolRDP,
+		})
+	}
+	return candidates
+}
+
+// AdoptRequest selects discovered candidates to add as devices
+type AdoptRequest struct {
+	Candidates []struct {
+		ID string `json:"id"`
+		// Name defaults to the candidate's hostname or address
+		Name     string `json:"name"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"candidates"`
+}
+
+// AdoptError reports a candidate that could not be added
+type AdoptError struct {
+	ID     string              `json:"id"`
+	Error  string              `json:"error"`
+	Fields []device.FieldError `json:"fields,omitempty"`
+}
+
+// AdoptResponse is returned by the adopt API
+type AdoptResponse struct {
+	Added  device.Devices `json:"added"`
+	Errors []AdoptError   `json:"errors"`
+}
+
+// HandleAdopt adds the selected candidates of the last scan as devices
+func (s *Server) HandleAdopt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Adding devices changes the config, don't let other sites do it
+	if !sameOrigin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var request AdoptRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := AdoptResponse{Added: device.Devices{}, Errors: []AdoptError{}}
+	for _, selected := range request.Candidates {
+		candidate, found := s.discovery.get(selected.ID)
+		if !found {
+			response.Errors = append(response.Errors, AdoptError{ID: selected.ID, Error: "candidate not found, run a new scan"})
+			continue
+		}
+		if !candidate.Adoptable {
+			response.Errors = append(response.Errors, AdoptError{ID: selected.ID, Error: candidate.Protocol + " services cannot be added as devices"})
+			continue
+		}
+
+		// Full screen is left to the group and default settings
+		d := device.Device{
+			Name:      selected.Name,
+			IPAddress: candidate.Address.String(),
+			Protocol:  candidate.Protocol,
+			Username:  selected.Username,
+			Password:  selected.Password,
+		}
+		if d.Name == "" {
+			d.Name = candidateName(candidate)
+		}
+		if candidate.Port != device.DefaultPort(candidate.Protocol) {
+			d.Port = candidate.Port
+		}
+
+		d, err := s.configFile.AddDevice(d)
+		if err != nil {
+			response.Errors = append(response.Errors, AdoptError{ID: selected.ID, Error: err.Error(), Fields: fieldErrors(err)})
+			continue
+		}
+
+		s.discovery.remove(selected.ID)
+		response.Added = append(response.Added, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// candidateName returns the short hostname of a candidate, or its address
+func candidateName(c Candidate) string {
+	if c.Hostname != "" {
+		name, _, _ := strings.Cut(strings.TrimSuffix(c.Hostname, "."), ".")
+		return name
+	}
+	return c.Address.String()
+}
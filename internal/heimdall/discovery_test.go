@@ -0,0 +1,176 @@
+package heimdall
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	configuration "spark-heimdall/internal/config"
+	"spark-heimdall/internal/discovery"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newDiscoveryServer(t *testing.T, cidr string) *Server {
+	t.Helper()
+
+	s := newTestServer(t)
+	if err := s.configFile.Update(configuration.UpdateConfig{ListenPort: 8080, DiscoveryCIDR: cidr, Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDiscoveryRanges(t *testing.T) {
+	s := newDiscoveryServer(t, "10.99.0.0/24")
+
+	tests := []struct {
+		cidr string
+		want string
+	}{
+		{"", "10.99.0.0/24"},
+		{"10.99.0.128/25", "10.99.0.128/25"},
+		{"10.99.0.7/24", "10.99.0.0/24"},
+		{"10.99.0.0/16", ""},
+		{"203.0.113.0/24", ""},
+		{"not a range", ""},
+	}
+	for _, test := range tests {
+		ranges, err := s.discoveryRanges(test.cidr)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("discoveryRanges(%q) = %v, want an error", test.cidr, ranges)
+			}
+			continue
+		}
+		if err != nil || len(ranges) != 1 || ranges[0].String() != test.want {
+			t.Errorf("discoveryRanges(%q) = %v, %v, want %s", test.cidr, ranges, err, test.want)
+		}
+	}
+}
+
+func TestHandleDiscover(t *testing.T) {
+	s := newDiscoveryServer(t, "127.0.0.1/32")
+
+	discover := func(body, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://heimdall.local/api/discover", strings.NewReader(body))
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		s.HandleDiscover(rec, req)
+		return rec
+	}
+
+	rec := httptest.NewRecorder()
+	s.HandleDiscover(rec, httptest.NewRequest("GET", "/api/discover?cidr=127.0.0.1/32", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if rec := discover("", "http://evil.example"); rec.Code != http.StatusForbidden {
+		t.Errorf("cross-origin scan = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := discover(`{"cidr": "203.0.113.0/24"}`, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("scan of another network = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// The scan runs in the background and is polled for
+	rec = discover("", "http://heimdall.local")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("scan = %d %s", rec.Code, rec.Body)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		s.HandleDiscoverStatus(rec, httptest.NewRequest("GET", "/api/discover/status", nil))
+		var status DiscoveryResult
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		if !status.Running {
+			if status.Error != "" || !slices.Equal(status.Ranges, []string{"127.0.0.1/32"}) || status.Candidates == nil {
+				t.Errorf("status = %+v", status)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("scan did not finish")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestDiscoveryStop(t *testing.T) {
+	s := newTestServer(t)
+
+	stopped := make(chan struct{})
+	if !s.discovery.begin(nil, func() { close(stopped) }) {
+		t.Fatal("begin failed")
+	}
+	if s.discovery.begin(nil, func() {}) {
+		t.Error("a second scan should not start")
+	}
+
+	s.discovery.stop()
+	select {
+	case <-stopped:
+	default:
+		t.Error("stop should cancel the running scan")
+	}
+}
+
+func TestHandleAdopt(t *testing.T) {
+	s := newTestServer(t)
+	s.discovery.begin(nil, func() {})
+	s.discovery.finish([]Candidate{{
+		ID:        "10.0.0.5:5901",
+		Service:   discovery.Service{Address: netip.MustParseAddr("10.0.0.5"), Port: 5901, Protocol: "vnc"},
+		Adoptable: true,
+	}}, nil)
+
+	adopt := func(origin string) *httptest.ResponseRecorder {
+		body := `{"candidates": [{"id": "10.0.0.5:5901", "name": "Desk"}]}`
+		req := httptest.NewRequest("POST", "http://heimdall.local/api/discover/adopt", strings.NewReader(body))
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		s.HandleAdopt(rec, req)
+		return rec
+	}
+
+	if rec := adopt("http://evil.example"); rec.Code != http.StatusForbidden {
+		t.Errorf("cross-origin adopt = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if devices := getPCs(t, s); len(devices) != 0 {
+		t.Fatalf("cross-origin adopt added %+v", devices)
+	}
+
+	rec := adopt("http://heimdall.local")
+	var response AdoptResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Added) != 1 || len(response.Errors) != 0 {
+		t.Fatalf("response = %+v", response)
+	}
+
+	// Full screen is inherited rather than forced on
+	d := response.Added[0]
+	if d.Name != "Desk" || d.Port != 5901 || d.FullScreen != nil {
+		t.Errorf("added device = %+v", d)
+	}
+}
+
+func TestHandleMDNSAdoptOrigin(t *testing.T) {
+	s := newTestServer(t)
+
+	req := httptest.NewRequest("POST", "http://heimdall.local/api/discover/mdns/adopt", strings.NewReader(`{"services": []}`))
+	req.Header.Set("Origin", "http://evil.example")
+	rec := httptest.NewRecorder()
+	s.HandleMDNSAdopt(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("cross-origin adopt = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,85 @@
+package heimdall
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"spark-heimdall/internal/device"
+	"time"
+)
+
+// defaultRecentLimit is the number of devices returned by the recent API
+// unless a limit is given
+const defaultRecentLimit = 10
+
+// RecentDevice is a device with its usage, as returned by the recent API
+type RecentDevice struct {
+	device.Device
+	LastConnected time.Time `json:"last_connected"`
+	ConnectCount  int       `json:"connect_count"`
+}
+
+// HandleGetFavorites lists the favorite devices in custom order
+func (s *Server) HandleGetFavorites(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.configFile.Store.GetAll().Favorites())
+}
+
+// HandleGetRecent lists the devices that have been connected to, most
+// recently used first
+func (s *Server) HandleGetRecent(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseCount(r.URL.Query().Get("limit"))
+	if err != nil {
+		http.Error(w, "limit "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit == 0 {
+		limit = defaultRecentLimit
+	}
+
+	devices := s.configFile.Store.GetAll()
+	devices.Sort(device.SortLastUsed, s.lastUsed, nil)
+
+	recent := []RecentDevice{}
+	for _, d := range devices {
+		usage := s.state.Usage(d.ID)
+		if usage.ConnectCount == 0 || len(recent) == limit {
+			break
+		}
+		recent = append(recent, RecentDevice{
+			Device:        d,
+			LastConnected: usage.LastConnected,
+			ConnectCount:  usage.ConnectCount,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recent)
+}
+
+// HandleFavorite marks or unmarks a device as favorite
+func (s *Server) HandleFavorite(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		Favorite bool `json:"favorite"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.configFile.SetFavorite(id, data.Favorite)
+	if err != nil {
+		log.Printf("Error updating favorite: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"success": true}`))
+}
@@ -0,0 +1,120 @@
+package heimdall
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"spark-heimdall/internal/device"
+)
+
+// deviceSection is a block of device cards on the index page
+type deviceSection struct {
+	// Group is nil for devices that don't belong to a group
+	Group *device.GroupNode
+	// Pinned is set for the favorites shown above all groups
+	Pinned bool
+	PCs    device.Devices
+}
+
+// groupSections splits devices into the pinned favorites, ungrouped devices
+// and one section per group in tree order. Favorites also appear in their group.
+func groupSections(devices device.Devices, groups device.Groups) []deviceSection {
+	byGroup := make(map[string]device.Devices)
+	for _, d := range devices {
+		groupID := d.GroupID
+		if _, found := groups.Get(groupID); !found {
+			groupID = ""
+		}
+		byGroup[groupID] = append(byGroup[groupID], d)
+	}
+
+	var sections []deviceSection
+	if favorites := devices.Favorites(); len(favorites) > 0 {
+		sections = append(sections, deviceSection{Pinned: true, PCs: favorites})
+	}
+
+	sections = append(sections, deviceSection{PCs: byGroup[""]})
+	for _, node := range groups.Tree() {
+		sections = append(sections, deviceSection{Group: &node, PCs: byGroup[node.ID]})
+	}
+	return sections
+}
+
+func (s *Server) HandleGetGroups(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.configFile.Store.GetGroups())
+}
+
+func (s *Server) HandleAddGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var g device.Group
+	err := json.NewDecoder(r.Body).Decode(&g)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	g, err = s.configFile.AddGroup(g)
+	if err != nil {
+		log.Printf("Error adding group: %v", err)
+		writeDeviceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(g)
+}
+
+func (s *Server) HandleEditGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var g device.Group
+	err := json.NewDecoder(r.Body).Decode(&g)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.configFile.UpdateGroup(g)
+	if err != nil {
+		log.Printf("Error updating group: %v", err)
+		writeDeviceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(g)
+}
+
+func (s *Server) HandleDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		ID string `json:"id"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.configFile.DeleteGroup(data.ID)
+	if err != nil {
+		log.Printf("Error deleting group: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"success": true}`))
+}
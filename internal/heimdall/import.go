@@ -0,0 +1,287 @@
+package heimdall
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"spark-heimdall/internal/device"
+	"spark-heimdall/internal/remmina"
+	"strconv"
+	"strings"
+)
+
+// maxImportSize limits the size of an import upload
+const maxImportSize = 32 << 20
+
+// remminaDir is where Remmina keeps its profiles, relative to the home
+// directory. Only paths inside it can be imported from this machine.
+const remminaDir = ".local/share/remmina"
+
+// errRemminaPath is returned for every path that can't be imported, so
+// requests can't tell which files exist elsewhere on this machine
+var errRemminaPath = errors.New("path must be ~/" + remminaDir + " or a profile or archive in it")
+
+// ImportEntry reports on one device of an import
+type ImportEntry struct {
+	// Source identifies where the device came from, e.g. a file name
+	Source string        `json:"source"`
+	Device device.Device `json:"device"`
+	// Unsupported lists settings of the source that were not imported
+	Unsupported []string `json:"unsupported,omitempty"`
+	// Existing is the ID of a configured device or an earlier entry with the
+	// same address, protocol and port. Such entries are not imported.
+	Existing string              `json:"existing,omitempty"`
+	Error    string              `json:"error,omitempty"`
+	Fields   []device.FieldError `json:"fields,omitempty"`
+	Added    bool                `json:"added"`
+}
+
+// ImportResult is returned by the import APIs
+type ImportResult struct {
+	Preview bool          `json:"preview"`
+	Entries []ImportEntry `json:"entries"`
+	Added   int           `json:"added"`
+}
+
+// HandleImportRemmina imports Remmina profiles. They are either uploaded as
+// multipart "file" fields, each a profile or an archive of profiles, or read
+// from the "path" field naming Remmina's profile directory on this machine
+// or a profile or archive in it. With preview set, the result shows what
+// would be imported without adding anything.
+func (s *Server) HandleImportRemmina(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	preview, err := parsePreview(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	profiles, err := readRemminaProfiles(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries := make([]ImportEntry, len(profiles))
+	for i, profile := range profiles {
+		entries[i] = ImportEntry{
+			Source:      profile.Source,
+			Device:      profile.Device,
+			Unsupported: profile.Unsupported,
+			Error:       profile.Error,
+		}
+	}
+
+	result, err := s.importDevices(entries, preview)
+	if err != nil {
+		log.Printf("Error saving Remmina import: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !preview {
+		log.Printf("Imported %d of %d Remmina profiles", result.Added, len(entries))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// parsePreview reads the preview flag from the query or form
+func parsePreview(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("preview")
+	if value == "" {
+		return false, nil
+	}
+	preview, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid preview value %q", value)
+	}
+	return preview, nil
+}
+
+// uploadedFile is a file of a multipart import upload
+type uploadedFile struct {
+	name string
+	data []byte
+}
+
+// parseUpload parses a multipart upload, if the request is one
+func parseUpload(r *http.Request) error {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return nil
+	}
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+	return nil
+}
+
+// uploadedFiles returns the "file" fields of a parsed multipart upload
+func uploadedFiles(r *http.Request) ([]uploadedFile, error) {
+	if r.MultipartForm == nil || len(r.MultipartForm.File["file"]) == 0 {
+		return nil, errors.New("no file uploaded")
+	}
+
+	var files []uploadedFile
+	for _, header := range r.MultipartForm.File["file"] {
+		f, err := header.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", header.Filename, err)
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", header.Filename, err)
+		}
+		files = append(files, uploadedFile{name: header.Filename, data: data})
+	}
+	return files, nil
+}
+
+// readRemminaProfiles reads the uploaded files or the given path
+func readRemminaProfiles(r *http.Request) ([]remmina.Entry, error) {
+	if err := parseUpload(r); err != nil {
+		return nil, err
+	}
+
+	if path := r.FormValue("path"); path != "" {
+		return readRemminaPath(path)
+	}
+
+	files, err := uploadedFiles(r)
+	if err != nil {
+		return nil, errors.New("upload a file or give a path")
+	}
+
+	var profiles []remmina.Entry
+	for _, file := range files {
+		entries, err := remmina.ReadUpload(file.name, file.data)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, entries...)
+	}
+	return profiles, nil
+}
+
+// readRemminaPath reads Remmina's profile directory or a profile or archive
+// in it. A leading "~/" stands for the home directory.
+func readRemminaPath(path string) ([]remmina.Entry, error) {
+	path, err := resolveRemminaPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errRemminaPath
+	}
+	if info.IsDir() {
+		return remmina.ReadDir(path)
+	}
+	if info.Size() > maxImportSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", filepath.Base(path), maxImportSize)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errRemminaPath
+	}
+	return remmina.ReadUpload(filepath.Base(path), data)
+}
+
+// resolveRemminaPath expands path and checks that it is inside Remmina's
+// profile directory once cleaned and with symlinks followed
+func resolveRemminaPath(path string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errRemminaPath
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Join(home, remminaDir))
+	if err != nil {
+		return "", errRemminaPath
+	}
+
+	if rest, found := strings.CutPrefix(path, "~/"); found {
+		path = filepath.Join(home, rest)
+	}
+	if !filepath.IsAbs(path) {
+		return "", errRemminaPath
+	}
+
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(path))
+	if err != nil {
+		return "", errRemminaPath
+	}
+	rel, err := filepath.Rel(dir, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errRemminaPath
+	}
+	return resolved, nil
+}
+
+// importDevices validates the entries and, unless previewing, adds the
+// valid ones that don't duplicate a configured device or an earlier entry.
+// The config is saved once at the end.
+func (s *Server) importDevices(entries []ImportEntry, preview bool) (ImportResult, error) {
+	result := ImportResult{Preview: preview, Entries: entries}
+
+	importEntries := func(work *device.Store) {
+		for i := range entries {
+			entry := &entries[i]
+			if entry.Error != "" {
+				continue
+			}
+
+			entry.Existing = findExisting(work, entry.Device)
+			if entry.Existing != "" {
+				continue
+			}
+
+			d, err := work.Add(entry.Device)
+			if err != nil {
+				entry.Error = err.Error()
+				entry.Fields = fieldErrors(err)
+				continue
+			}
+
+			entry.Device = d
+			if !preview {
+				entry.Added = true
+				result.Added++
+			}
+		}
+	}
+
+	if preview {
+		importEntries(s.configFile.Store.Copy())
+		return result, nil
+	}
+	if err := s.configFile.EditDevices(importEntries); err != nil {
+		return ImportResult{}, err
+	}
+	return result, nil
+}
+
+// findExisting returns the ID of a device of work connecting to the same
+// service as d
+func findExisting(work *device.Store, d device.Device) string {
+	d = work.Effective(d)
+	for _, existing := range work.GetAllEffective() {
+		if strings.EqualFold(existing.IPAddress, d.IPAddress) &&
+			existing.Protocol == d.Protocol && existing.ConnectPort() == d.ConnectPort() {
+			return existing.ID
+		}
+	}
+	return ""
+}
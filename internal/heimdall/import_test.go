@@ -0,0 +1,353 @@
+package heimdall
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"spark-heimdall/internal/device"
+	"strings"
+	"testing"
+)
+
+// postFiles uploads files as multipart "file" fields
+func postFiles(t *testing.T, handler http.HandlerFunc, path string, files map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, content := range files {
+		part, err := mw.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(content))
+	}
+	mw.Close()
+
+	req := httptest.NewRequest("POST", path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func decodeImport(t *testing.T, rec *httptest.ResponseRecorder) ImportResult {
+	t.Helper()
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body)
+	}
+	var result ImportResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestImportRemmina(t *testing.T) {
+	s := newTestServer(t)
+	if _, err := s.configFile.AddDevice(device.Device{Name: "Existing", IPAddress: "10.0.0.9", Protocol: "vnc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"desk.remmina":     "[remmina]\nname=Desk\nprotocol=VNC\nserver=10.0.0.5:5901\nviewmode=2\n",
+		"existing.remmina": "[remmina]\nname=Again\nprotocol=VNC\nserver=10.0.0.9\n",
+		"nouser.remmina":   "[remmina]\nname=Server\nprotocol=RDP\nserver=10.0.0.6\n",
+		"shell.remmina":    "[remmina]\nname=Shell\nprotocol=SSH\nserver=10.0.0.7\n",
+	}
+
+	preview := decodeImport(t, postFiles(t, s.HandleImportRemmina, "/api/pcs/import/remmina?preview=true", files))
+	if !preview.Preview || preview.Added != 0 || len(preview.Entries) != 4 {
+		t.Fatalf("preview = %+v", preview)
+	}
+	if devices := getPCs(t, s); len(devices) != 1 {
+		t.Fatalf("preview added devices: %+v", devices)
+	}
+
+	result := decodeImport(t, postFiles(t, s.HandleImportRemmina, "/api/pcs/import/remmina", files))
+	if result.Added != 1 {
+		t.Fatalf("added %d, want 1: %+v", result.Added, result.Entries)
+	}
+
+	for _, entry := range result.Entries {
+		switch entry.Source {
+		case "desk.remmina":
+			if !entry.Added || entry.Device.ID != "desk" || entry.Device.Port != 5901 || !entry.Device.IsFullScreen() {
+				t.Errorf("desk = %+v", entry)
+			}
+		case "existing.remmina":
+			if entry.Added || entry.Existing == "" {
+				t.Errorf("existing = %+v", entry)
+			}
+		case "nouser.remmina":
+			if entry.Added || len(entry.Fields) != 1 || entry.Fields[0].Field != "username" {
+				t.Errorf("nouser = %+v", entry)
+			}
+		case "shell.remmina":
+			if entry.Added || entry.Error == "" {
+				t.Errorf("shell = %+v", entry)
+			}
+		}
+	}
+}
+
+func TestImportDuplicateEntries(t *testing.T) {
+	s := newTestServer(t)
+	files := map[string]string{
+		"a.remmina": "[remmina]\nname=Desk\nprotocol=VNC\nserver=10.0.0.5\n",
+		"b.remmina": "[remmina]\nname=Desk again\nprotocol=VNC\nserver=10.0.0.5:5900\n",
+	}
+
+	// The second profile duplicates the first, also in the preview
+	for _, query := range []string{"?preview=true", ""} {
+		result := decodeImport(t, postFiles(t, s.HandleImportRemmina, "/api/pcs/import/remmina"+query, files))
+		var existing []string
+		for _, entry := range result.Entries {
+			existing = append(existing, entry.Existing)
+		}
+		slices.Sort(existing)
+		if !slices.Equal(existing, []string{"", "desk"}) && !slices.Equal(existing, []string{"", "desk-again"}) {
+			t.Errorf("%s: existing = %q", query, existing)
+		}
+	}
+	if devices := getPCs(t, s); len(devices) != 1 {
+		t.Errorf("devices = %+v", devices)
+	}
+}
+
+func TestImportAndExportRDP(t *testing.T) {
+	s := newTestServer(t)
+
+	files := map[string]string{
+		"Finance Server.rdp": "full address:s:fin.example.com:3390\r\nusername:s:alice\r\nredirectclipboard:i:1\r\n",
+		"broken.rdp":         "not an rdp file\r\n",
+	}
+	result := decodeImport(t, postFiles(t, s.HandleImportRDP, "/api/pcs/import/rdp", files))
+	if result.Added != 1 {
+		t.Fatalf("added %d, want 1: %+v", result.Added, result.Entries)
+	}
+
+	var id string
+	for _, entry := range result.Entries {
+		if entry.Added {
+			id = entry.Device.ID
+			if entry.Device.Name != "Finance Server" || !entry.Device.RDP.RedirectClipboard {
+				t.Errorf("imported device = %+v", entry.Device)
+			}
+		} else if entry.Source != "broken.rdp" || entry.Error == "" {
+			t.Errorf("entry = %+v", entry)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.HandlePCRoute(rec, httptest.NewRequest("GET", "/api/pcs/"+id+"/export.rdp", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-rdp" {
+		t.Fatalf("export: %d %s", rec.Code, rec.Header())
+	}
+	for _, line := range []string{"full address:s:fin.example.com:3390\r\n", "username:s:alice\r\n", "redirectclipboard:i:1\r\n"} {
+		if !bytes.Contains(rec.Body.Bytes(), []byte(line)) {
+			t.Errorf("export is missing %q:\n%s", line, rec.Body)
+		}
+	}
+}
+
+func postCSV(t *testing.T, s *Server, query, body string) CSVImportResult {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	s.HandleImportCSV(rec, httptest.NewRequest("POST", "/api/pcs/import/csv"+query, strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body)
+	}
+	var result CSVImportResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestCSVImportUpsert(t *testing.T) {
+	s := newTestServer(t)
+	if _, err := s.configFile.AddDevice(device.Device{ID: "desk", Name: "Desk", IPAddress: "10.0.0.1", Protocol: "rdp", Username: "admin", Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	input := "id,name,ip_address,protocol,username,port\n" +
+		"desk,Front Desk,10.0.0.1,rdp,admin,\n" +
+		",Lab,10.0.0.2,vnc,,\n" +
+		",Broken,10.0.0.3,rdp,,http\n"
+
+	preview := postCSV(t, s, "?preview=true", input)
+	if preview.Failed != 1 || preview.Added != 0 || preview.Rows[0].Action != "update" || preview.Rows[1].Action != "add" {
+		t.Fatalf("preview = %+v", preview)
+	}
+	if d, _ := s.configFile.Store.Get("desk"); d.Name != "Desk" {
+		t.Fatalf("preview changed the device: %+v", d)
+	}
+
+	result := postCSV(t, s, "", input)
+	if result.Added != 1 || result.Updated != 1 || result.Failed != 1 {
+		t.Fatalf("result = %+v", result)
+	}
+	if broken := result.Rows[2]; broken.Line != 4 || len(broken.Fields) == 0 || broken.Fields[0].Field != "port" {
+		t.Errorf("broken row = %+v", broken)
+	}
+
+	// Columns missing from the file keep their values
+	d, _ := s.configFile.Store.Get("desk")
+	if d.Name != "Front Desk" || d.Password != "secret" {
+		t.Errorf("updated device = %+v", d)
+	}
+
+	byAddress := postCSV(t, s, "?match=address", "Hostname,Description\n10.0.0.2,Lab machine\n")
+	if byAddress.Updated != 1 || byAddress.Rows[0].Device.Name != "Lab" || byAddress.Rows[0].Device.Description != "Lab machine" {
+		t.Errorf("update by address = %+v", byAddress)
+	}
+}
+
+func TestCSVImportRowsSeeEarlierRows(t *testing.T) {
+	s := newTestServer(t)
+
+	// The preview matches the later row to the device the first one adds,
+	// like the import does
+	input := "id,name,ip_address,protocol\n" +
+		"lab,Lab,10.0.0.2,vnc\n" +
+		"lab,Lab 2,10.0.0.2,vnc\n" +
+		",Desk,10.0.0.3,vnc\n" +
+		",Desk,10.0.0.4,vnc\n"
+	for _, query := range []string{"?preview=true", ""} {
+		result := postCSV(t, s, query, input)
+		var actions, ids []string
+		for _, row := range result.Rows {
+			actions = append(actions, row.Action)
+			ids = append(ids, row.Device.ID)
+		}
+		if !slices.Equal(actions, []string{"add", "update", "add", "add"}) || !slices.Equal(ids, []string{"lab", "lab", "desk", "desk-2"}) {
+			t.Errorf("%s: actions %v, IDs %v", query, actions, ids)
+		}
+	}
+	if d, _ := s.configFile.Store.Get("lab"); d.Name != "Lab 2" {
+		t.Errorf("lab = %+v", d)
+	}
+}
+
+func TestImportSaveFailure(t *testing.T) {
+	s := newTestServer(t)
+
+	// A directory in place of the config file makes saving fail
+	if err := os.Remove(s.configFile.FilePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(s.configFile.FilePath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.HandleImportCSV(rec, httptest.NewRequest("POST", "/api/pcs/import/csv", strings.NewReader("name,ip_address,protocol\nLab,10.0.0.2,vnc\n")))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("CSV import: expected 500, got %d %s", rec.Code, rec.Body)
+	}
+
+	files := map[string]string{"desk.remmina": "[remmina]\nname=Desk\nprotocol=VNC\nserver=10.0.0.5\n"}
+	if rec := postFiles(t, s.HandleImportRemmina, "/api/pcs/import/remmina", files); rec.Code != http.StatusInternalServerError {
+		t.Errorf("Remmina import: expected 500, got %d %s", rec.Code, rec.Body)
+	}
+
+	if devices := getPCs(t, s); len(devices) != 0 {
+		t.Errorf("devices after failed save = %+v", devices)
+	}
+}
+
+func TestCSVExportColumns(t *testing.T) {
+	s := newTestServer(t)
+	if _, err := s.configFile.AddDevice(device.Device{Name: "Desk", IPAddress: "10.0.0.1", Protocol: "vnc", Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.HandleExportCSV(rec, httptest.NewRequest("GET", "/api/pcs/export.csv", nil))
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "secret") {
+		t.Fatalf("default export: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	s.HandleExportCSV(rec, httptest.NewRequest("GET", "/api/pcs/export.csv?columns=name,password", nil))
+	if got := rec.Body.String(); got != "name,password\nDesk,secret\n" {
+		t.Errorf("export with columns = %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	s.HandleExportCSV(rec, httptest.NewRequest("GET", "/api/pcs/export.csv?columns=name,nope", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown column: expected 400, got %d", rec.Code)
+	}
+}
+
+// postForm posts fields as a multipart form
+func postForm(t *testing.T, handler http.HandlerFunc, path string, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, value := range fields {
+		mw.WriteField(name, value)
+	}
+	mw.Close()
+
+	req := httptest.NewRequest("POST", path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestImportRemminaPath(t *testing.T) {
+	s := newTestServer(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	dir := filepath.Join(home, remminaDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	profile := "[remmina]\nname=Desk\nprotocol=VNC\nserver=10.0.0.5\n"
+	if err := os.WriteFile(filepath.Join(dir, "desk.remmina"), []byte(profile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(home, "outside.remmina")
+	if err := os.WriteFile(outside, []byte(profile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link.remmina")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"~/.local/share/remmina", dir + "/desk.remmina"} {
+		result := decodeImport(t, postForm(t, s.HandleImportRemmina, "/api/pcs/import/remmina?preview=true", map[string]string{"path": path}))
+		if len(result.Entries) != 1 || result.Entries[0].Device.Name != "Desk" {
+			t.Errorf("import of %s = %+v", path, result.Entries)
+		}
+	}
+
+	// Paths outside the profile directory all fail alike, whether they exist or not
+	for _, path := range []string{
+		outside,
+		"~/outside.remmina",
+		"~/.local/share/remmina/../../../outside.remmina",
+		"~/.local/share/remmina/link.remmina",
+		"~/missing.remmina",
+		"/etc/passwd",
+		"desk.remmina",
+	} {
+		rec := postForm(t, s.HandleImportRemmina, "/api/pcs/import/remmina?preview=true", map[string]string{"path": path})
+		if rec.Code != http.StatusBadRequest || strings.TrimSpace(rec.Body.String()) != errRemminaPath.Error() {
+			t.Errorf("import of %s = %d %s", path, rec.Code, rec.Body)
+		}
+	}
+}
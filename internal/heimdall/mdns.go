@@ -0,0 +1,173 @@
+package heimdall
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/netip"
+	"spark-heimdall/internal/device"
+	"spark-heimdall/internal/mdns"
+	"spark-heimdall/internal/resolver"
+	"strings"
+)
+
+// startMDNS joins the multicast group and browses until ctx is cancelled.
+// Heimdall works without it, so a failure is only logged.
+func (s *Server) startMDNS(ctx context.Context) {
+	browser, err := mdns.Listen()
+	if err != nil {
+		log.Printf("mDNS browsing disabled: %v", err)
+		s.mdnsErr = err
+		return
+	}
+	s.mdns = browser
+	go browser.Run(ctx)
+}
+
+// lookupHost resolves ".local" names over multicast DNS and everything else
+// with the system resolver
+func (s *Server) lookupHost(ctx context.Context, host string) ([]netip.Addr, error) {
+	if s.mdns != nil && mdns.IsLocal(host) {
+		return s.mdns.Lookup(ctx, host)
+	}
+	return resolver.SystemLookup(ctx, host)
+}
+
+// MDNSService is an advertised service and the device it is configured as
+type MDNSService struct {
+	mdns.Service
+	// DeviceID is set if a device already connects to this service
+	DeviceID  string `json:"device_id,omitempty"`
+	Adoptable bool   `json:"adoptable"`
+}
+
+// MDNSResult is returned by the mDNS catalogue API
+type MDNSResult struct {
+	Services []MDNSService `json:"services"`
+	// Error explains why browsing is unavailable
+	Error string `json:"error,omitempty"`
+}
+
+// mdnsServices returns the catalogue matched against the configured devices
+func (s *Server) mdnsServices() []MDNSService {
+	services := []MDNSService{}
+	if s.mdns == nil {
+		return services
+	}
+
+	devices := s.configFile.Store.GetAllEffective()
+	for _, service := range s.mdns.Services() {
+		entry := MDNSService{
+			Service:   service,
+			Adoptable: service.Protocol == "vnc" || service.Protocol == "rdp",
+		}
+		for _, d := range devices {
+			if strings.EqualFold(strings.TrimSuffix(d.IPAddress, "."), service.Hostname) &&
+				d.Protocol == service.Protocol && d.ConnectPort() == service.Port {
+				entry.DeviceID = d.ID
+				break
+			}
+		}
+		services = append(services, entry)
+	}
+	return services
+}
+
+// HandleMDNSServices lists the services currently advertised over mDNS
+func (s *Server) HandleMDNSServices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result := MDNSResult{Services: s.mdnsServices()}
+	if s.mdnsErr != nil {
+		result.Error = s.mdnsErr.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// MDNSAdoptRequest selects advertised services to add as devices
+type MDNSAdoptRequest struct {
+	Services []struct {
+		ID string `json:"id"`
+		// Name defaults to the advertised instance name
+		Name     string `json:"name"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"services"`
+}
+
+// HandleMDNSAdopt adds advertised services as devices. The devices connect
+// to the advertised hostname, so they follow the host when its address
+// changes.
+func (s *Server) HandleMDNSAdopt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Adding devices changes the config, don't let other sites do it
+	if !sameOrigin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var request MDNSAdoptRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if s.mdns == nil {
+		http.Error(w, "mDNS browsing is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	response := AdoptResponse{Added: device.Devices{}, Errors: []AdoptError{}}
+	for _, selected := range request.Services {
+		service, found := s.mdns.Get(selected.ID)
+		if !found {
+			response.Errors = append(response.Errors, AdoptError{ID: selected.ID, Error: "service is no longer advertised"})
+			continue
+		}
+		if service.Protocol != "vnc" && service.Protocol != "rdp" {
+			response.Errors = append(response.Errors, AdoptError{ID: selected.ID, Error: service.Protocol + " services cannot be added as devices"})
+			continue
+		}
+		if service.Hostname == "" {
+			response.Errors = append(response.Errors, AdoptError{ID: selected.ID, Error: "service host is not known yet"})
+			continue
+		}
+
+		// Full screen is left to the group and default settings
+		d := device.Device{
+			Name:      selected.Name,
+			IPAddress: service.Hostname,
+			Protocol:  service.Protocol,
+			Username:  selected.Username,
+			Password:  selected.Password,
+		}
+		if d.Name == "" {
+			d.Name = service.Name
+		}
+		if service.Port != device.DefaultPort(service.Protocol) {
+			d.Port = service.Port
+		}
+
+		d, err := s.configFile.AddDevice(d)
+		if err != nil {
+			response.Errors = append(response.Errors, AdoptError{ID: selected.ID, Error: err.Error(), Fields: fieldErrors(err)})
+			continue
+		}
+
+		response.Added = append(response.Added, d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
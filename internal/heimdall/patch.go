@@ -0,0 +1,59 @@
+package heimdall
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+)
+
+// MergePatchContentType is the media type of RFC 7396 JSON merge patches
+const MergePatchContentType = "application/merge-patch+json"
+
+// maxPatchSize limits the size of a device patch
+const maxPatchSize = 1 << 20
+
+// HandlePatchPC applies a JSON merge patch to a device. Unlike the edit
+// endpoint, fields the patch omits keep their stored value. The If-Match
+// header names the revision the patch is based on.
+func (s *Server) HandlePatchPC(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != "PATCH" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != MergePatchContentType && mediaType != "application/json") {
+			w.Header().Set("Accept-Patch", MergePatchContentType)
+			http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+
+	revision, ok := s.ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	patch, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d, err := s.configFile.PatchDevice(id, patch, revision)
+	if err != nil {
+		log.Printf("Error patching device %s: %v", id, err)
+		writeDeviceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(d.Revision))
+	err = json.NewEncoder(w).Encode(d)
+	if err != nil {
+		log.Printf("Error encoding device: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package heimdall
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"spark-heimdall/internal/device"
+	"strconv"
+	"time"
+)
+
+// parseDeviceQuery reads the device listing parameters shared by the index
+// page and the device API: group, tag, protocol, online, q, sort, limit and
+// offset
+func (s *Server) parseDeviceQuery(r *http.Request) (device.Query, error) {
+	values := r.URL.Query()
+	query := device.Query{
+		Filter: device.Filter{
+			GroupID:  values.Get("group"),
+			Tag:      values.Get("tag"),
+			Protocol: values.Get("protocol"),
+			IsOnline: s.reachability.isOnline,
+		},
+		Search:   values.Get("q"),
+		Sort:     values.Get("sort"),
+		LastUsed: s.lastUsed,
+	}
+
+	if value := values.Get("online"); value != "" {
+		online, err := strconv.ParseBool(value)
+		if err != nil {
+			return query, fmt.Errorf("online must be true or false")
+		}
+		query.Online = &online
+	}
+
+	if !device.ValidSort(query.Sort) {
+		return query, fmt.Errorf("sort must be one of %s, %s, %s or %s",
+			device.SortName, device.SortLastUsed, device.SortStatus, device.SortCustom)
+	}
+
+	var err error
+	if query.Limit, err = parseCount(values.Get("limit")); err != nil {
+		return query, fmt.Errorf("limit %w", err)
+	}
+	if query.Offset, err = parseCount(values.Get("offset")); err != nil {
+		return query, fmt.Errorf("offset %w", err)
+	}
+
+	return query, nil
+}
+
+// parseCount parses an optional non-negative integer
+func parseCount(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("must be a non-negative integer")
+	}
+	return n, nil
+}
+
+// lastUsed returns when a session to the device was last started
+func (s *Server) lastUsed(id string) time.Time {
+	return s.state.Usage(id).LastConnected
+}
+
+// recordUsage records that a session to the device was started
+func (s *Server) recordUsage(id string) {
+	err := s.state.RecordConnect(id, time.Now())
+	if err != nil {
+		log.Printf("Failed to save device usage: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package heimdall
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"spark-heimdall/internal/rdpfile"
+	"strings"
+)
+
+// HandleImportRDP imports the .rdp files uploaded as multipart "file"
+// fields. Devices are named after their file. With preview set, the result
+// shows what would be imported without adding anything.
+func (s *Server) HandleImportRDP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	preview, err := parsePreview(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := parseUpload(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	files, err := uploadedFiles(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries := make([]ImportEntry, len(files))
+	for i, file := range files {
+		entries[i] = importRDPFile(file)
+	}
+
+	result, err := s.importDevices(entries, preview)
+	if err != nil {
+		log.Printf("Error saving .rdp import: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !preview {
+		log.Printf("Imported %d of %d .rdp files", result.Added, len(entries))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func importRDPFile(file uploadedFile) ImportEntry {
+	entry := ImportEntry{Source: file.name}
+
+	f, err := rdpfile.Parse(bytes.NewReader(file.data))
+	if err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+
+	entry.Device, entry.Unsupported, err = f.Device()
+	if err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+
+	name := strings.TrimSuffix(filepath.Base(file.name), filepath.Ext(file.name))
+	if name != "" {
+		entry.Device.Name = name
+	}
+	return entry
+}
+
+// HandleExportRDP downloads an RDP device as an .rdp file
+func (s *Server) HandleExportRDP(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pc, found := s.configFile.Store.GetEffective(id)
+	if !found {
+		http.Error(w, "PC not found", http.StatusNotFound)
+		return
+	}
+	if pc.Protocol != "rdp" {
+		http.Error(w, "Only RDP devices can be exported as .rdp files", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", rdpfile.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", pc.ID+rdpfile.Extension))
+	if _, err := rdpfile.FromDevice(pc).WriteTo(w); err != nil {
+		log.Printf("Error writing .rdp file: %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package heimdall
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// reachabilityInterval is how often every device is probed
+const reachabilityInterval = 30 * time.Second
+
+// maxConcurrentProbes bounds the number of simultaneous reachability probes
+const maxConcurrentProbes = 8
+
+// reachability records which devices answered their last probe
+type reachability struct {
+	lock   sync.RWMutex
+	online map[string]bool
+}
+
+func (r *reachability) isOnline(id string) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.online[id]
+}
+
+func (r *reachability) snapshot() map[string]bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	online := make(map[string]bool, len(r.online))
+	for id, up := range r.online {
+		online[id] = up
+	}
+	return online
+}
+
+// runReachability probes all devices periodically until ctx is cancelled
+func (s *Server) runReachability(ctx context.Context) {
+	for {
+		s.refreshReachability(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reachabilityInterval):
+		}
+	}
+}
+
+func (s *Server) refreshReachability(ctx context.Context) {
+	devices := s.configFile.Store.GetAll()
+	online := make(map[string]bool, len(devices))
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentProbes)
+
+	for _, pc := range devices {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			up := s.probeDevice(ctx, pc) == nil
+			lock.Lock()
+			online[pc.ID] = up
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// Probes cut short by shutdown say nothing about the devices
+	if ctx.Err() != nil {
+		return
+	}
+
+	s.reachability.lock.Lock()
+	s.reachability.online = online
+	s.reachability.lock.Unlock()
+}
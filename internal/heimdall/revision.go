@@ -0,0 +1,62 @@
+package heimdall
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ConflictResponse is returned with status 412 when a change is based on an
+// outdated revision
+type ConflictResponse struct {
+	Error string `json:"error"`
+	// Current is the device or settings as currently stored
+	Current any `json:"current"`
+}
+
+// etag formats a revision as entity tag
+func etag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+// ifMatch returns the revision named by the If-Match header, or 0 when any
+// revision may be changed. A tag that is no revision is returned as -1,
+// which never matches. When the header is required but missing, 428 is
+// written and ok is false.
+func (s *Server) ifMatch(w http.ResponseWriter, r *http.Request) (revision int64, ok bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		if s.configFile.RequireIfMatch {
+			http.Error(w, "If-Match header with the revision to change is required", http.StatusPreconditionRequired)
+			return 0, false
+		}
+		return 0, true
+	}
+	if header == "*" {
+		return 0, true
+	}
+
+	// Weak tags never match, If-Match uses the strong comparison
+	unquoted, found := strings.CutPrefix(header, `"`)
+	unquoted, suffix := strings.CutSuffix(unquoted, `"`)
+	if !found || !suffix {
+		return -1, true
+	}
+	revision, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || revision <= 0 {
+		return -1, true
+	}
+	return revision, true
+}
+
+// writeConflict reports that current has moved on to another revision
+func writeConflict(w http.ResponseWriter, err error, current any, revision int64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(revision))
+	w.WriteHeader(http.StatusPreconditionFailed)
+	json.NewEncoder(w).Encode(ConflictResponse{
+		Error:   err.Error(),
+		Current: current,
+	})
+}
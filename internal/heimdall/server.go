@@ -1,34 +1,87 @@
 package heimdall
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/exec"
+	"os/signal"
+	"slices"
 	configuration "spark-heimdall/internal/config"
 	"spark-heimdall/internal/device"
+	"spark-heimdall/internal/mdns"
+	"spark-heimdall/internal/resolver"
+	"spark-heimdall/internal/state"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+// shutdownTimeout bounds how long in-flight requests may take to finish
+const shutdownTimeout = 10 * time.Second
+
 type Server struct {
 	configFile      *configuration.Config
 	templates       *template.Template
 	cmdLock         sync.Mutex
 	currentCmd      *exec.Cmd
 	currentDeviceId string
-	Store           *device.Store
+	wsTokens        wsTokens
+	thumbnails      thumbnailCache
+	diagnostics     diagnostics
+	bridges         bridgeSet
+	httpServer      *http.Server
+	stopBackground  context.CancelFunc
+	state           *state.Store
+	shuttingDown    atomic.Bool
+	autoStart       autoStart
+	reachability    reachability
+	resolver        *resolver.Resolver
+	discovery       discoveryState
+	// mdns is nil until Start joins the multicast group, and stays nil
+	// with mdnsErr set if that fails
+	mdns    *mdns.Browser
+	mdnsErr error
+	// novnc holds the vendored noVNC files served at /novnc/, nil if the
+	// browser viewer is not available
+	novnc fs.FS
+	// reconnect is the pending restart of a viewer that exited, see
+	// scheduleReconnect
+	reconnect *time.Timer
 }
 
-func NewServer(configFile *configuration.Config, templates *template.Template) *Server {
-	return &Server{
+func NewServer(configFile *configuration.Config, templates *template.Template, novnc fs.FS) *Server {
+	sessionState, err := state.Load(configFile.StatePath())
+	if err != nil {
+		log.Printf("Ignoring session state: %v", err)
+		sessionState = state.New(configFile.StatePath())
+	}
+
+	s := &Server{
 		configFile: configFile,
 		templates:  templates,
-		Store:      &device.Store{Devices: configFile.Devices},
+		novnc:      novnc,
+		state:      sessionState,
 	}
+	s.resolver = resolver.NewWithLookup(s.lookupHost)
+	return s
+}
+
+// deviceAddress resolves the device's host and returns the host:port to dial
+func (s *Server) deviceAddress(ctx context.Context, pc device.Device) (string, error) {
+	// The port may be inherited from the device's group
+	pc = s.configFile.Store.Effective(pc)
+	return s.resolver.DialAddress(ctx, pc.IPAddress, pc.ConnectPort())
 }
 
 func (s *Server) SetupRoutes() {
@@ -39,8 +92,38 @@ func (s *Server) SetupRoutes() {
 	http.HandleFunc("/api/pcs/add", loggingMiddleware(s.HandleAddPC))
 	http.HandleFunc("/api/pcs/edit", loggingMiddleware(s.HandleEditPC))
 	http.HandleFunc("/api/pcs/delete", loggingMiddleware(s.HandleDeletePC))
+	http.HandleFunc("/api/pcs/reorder", loggingMiddleware(s.HandleReorderPCs))
+	http.HandleFunc("/api/pcs/bulk", loggingMiddleware(s.HandleBulkPCs))
+	http.HandleFunc("/api/pcs/recent", loggingMiddleware(s.HandleGetRecent))
+	http.HandleFunc("/api/pcs/favorites", loggingMiddleware(s.HandleGetFavorites))
+	http.HandleFunc("/api/pcs/import/remmina", loggingMiddleware(s.HandleImportRemmina))
+	http.HandleFunc("/api/pcs/import/rdp", loggingMiddleware(s.HandleImportRDP))
+	http.HandleFunc("/api/pcs/import/csv", loggingMiddleware(s.HandleImportCSV))
+	http.HandleFunc("/api/pcs/export.csv", loggingMiddleware(s.HandleExportCSV))
+	http.HandleFunc("/api/pcs/", loggingMiddleware(s.HandlePCRoute))
+	http.HandleFunc("/api/groups", loggingMiddleware(s.HandleGetGroups))
+	http.HandleFunc("/api/groups/add", loggingMiddleware(s.HandleAddGroup))
+	http.HandleFunc("/api/groups/edit", loggingMiddleware(s.HandleEditGroup))
+	http.HandleFunc("/api/groups/delete", loggingMiddleware(s.HandleDeleteGroup))
+	http.HandleFunc("/api/templates", loggingMiddleware(s.HandleGetTemplates))
+	http.HandleFunc("/api/templates/add", loggingMiddleware(s.HandleAddTemplate))
+	http.HandleFunc("/api/templates/edit", loggingMiddleware(s.HandleEditTemplate))
+	http.HandleFunc("/api/templates/delete", loggingMiddleware(s.HandleDeleteTemplate))
+	http.HandleFunc("/api/templates/", loggingMiddleware(s.HandleTemplateRoute))
+	http.HandleFunc("/api/discover", loggingMiddleware(s.HandleDiscover))
+	http.HandleFunc("/api/discover/status", loggingMiddleware(s.HandleDiscoverStatus))
+	http.HandleFunc("/api/discover/adopt", loggingMiddleware(s.HandleAdopt))
+	http.HandleFunc("/api/discover/mdns", loggingMiddleware(s.HandleMDNSServices))
+	http.HandleFunc("/api/discover/mdns/adopt", loggingMiddleware(s.HandleMDNSAdopt))
 	http.HandleFunc("/api/config", loggingMiddleware(s.HandleGetConfig))
 	http.HandleFunc("/api/config/update", loggingMiddleware(s.HandleUpdateConfig))
+	http.HandleFunc("/api/diagnostics", loggingMiddleware(s.HandleDiagnostics))
+	http.HandleFunc("/api/status", loggingMiddleware(s.HandleStatus))
+	http.HandleFunc("/vnc/", loggingMiddleware(s.HandleVncViewer))
+	http.HandleFunc("/ws/vnc/", loggingMiddleware(s.HandleVncWebSocket))
+	if s.novnc != nil {
+		http.Handle("/novnc/", http.StripPrefix("/novnc/", http.FileServerFS(s.novnc)))
+	}
 
 	// Serve static files (CSS, JS) if they exist
 	if _, err := os.Stat("static"); !os.IsNotExist(err) {
@@ -49,26 +132,87 @@ func (s *Server) SetupRoutes() {
 }
 
 func (s *Server) Start() error {
-	// Auto-start if configured
 	log.Println("Starting server...")
-	if s.configFile.AutoStart && s.configFile.AutoStartID != "" {
-		log.Printf("Auto-starting ID %s", s.configFile.AutoStartID)
-		for _, pc := range s.Store.Devices {
-			if pc.ID == s.configFile.AutoStartID {
-				go s.connectToPC(pc)
-				break
-			}
-		}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Version probes can be slow, don't hold up startup for them.
+	// Connections made before they finish inspect the viewers themselves.
+	go s.refreshDiagnostics()
+
+	background, cancel := context.WithCancel(context.Background())
+	s.stopBackground = cancel
+	s.startMDNS(background)
+	go s.runThumbnails(background)
+	go s.runReachability(background)
+
+	// Restore the previous session or auto-start once the target is reachable
+	if candidates, source := s.autoStartCandidates(); len(candidates) > 0 {
+		go s.runAutoStart(background, candidates, source)
 	}
 
+	s.httpServer = &http.Server{Addr: fmt.Sprintf(":%d", s.configFile.ListenPort)}
+
 	log.Printf("Starting server on port %d", s.configFile.ListenPort)
 	log.Printf("Open http://localhost:%d in your browser", s.configFile.ListenPort)
-	err := http.ListenAndServe(fmt.Sprintf(":%d", s.configFile.ListenPort), nil)
-	if err != nil {
-		log.Fatalf("Server failed: %v", err)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		s.shutdown()
+		return fmt.Errorf("server failed: %w", err)
+	case <-ctx.Done():
 	}
 
-	return nil
+	// A second signal terminates immediately
+	stop()
+	log.Println("Shutting down...")
+
+	return s.shutdown()
+}
+
+// shutdown drains HTTP requests, closes browser sessions and terminates
+// the running viewer
+func (s *Server) shutdown() error {
+	// Sessions ending from here on were ended by shutdown, so they stay
+	// recorded to be restored on next launch and are not reconnected
+	s.shuttingDown.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	var errs []error
+
+	if s.stopBackground != nil {
+		s.stopBackground()
+	}
+	s.discovery.stop()
+
+	// Handlers that save the config finish before Shutdown returns, and
+	// config writes are synchronous, so only session state may need a retry
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to drain HTTP server: %w", err))
+	}
+
+	// Hijacked WebSocket connections are not tracked by http.Server
+	s.bridges.closeAll()
+
+	s.disconnectCurrentPC()
+
+	if err := s.state.Flush(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to save session state: %w", err))
+	}
+
+	err := errors.Join(errs...)
+	if err == nil {
+		log.Println("Shutdown complete")
+	}
+	return err
 }
 
 func (s *Server) HandleIndex(w http.ResponseWriter, r *http.Request) {
@@ -77,16 +221,59 @@ func (s *Server) HandleIndex(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	query, err := s.parseDeviceQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	devices := s.configFile.Store.GetAll()
+	groups := s.configFile.Store.GetGroups()
+	matches, _ := devices.Query(query, groups)
+	// Cards show the settings the devices connect with
+	for i := range matches {
+		matches[i], _ = groups.Effective(matches[i])
+	}
+
+	sections := groupSections(matches, groups)
+	if len(matches) != len(devices) {
+		// Only show the groups containing matches
+		sections = slices.DeleteFunc(sections, func(section deviceSection) bool {
+			return section.Group != nil && len(section.PCs) == 0
+		})
+	}
+
 	data := struct {
 		PCs              device.Devices
+		Groups           []device.GroupNode
+		Templates        device.Templates
+		Sections         []deviceSection
+		Matches          int
+		Search           string
+		Sort             string
+		Reorderable      bool
+		Online           map[string]bool
+		Resolved         map[string]string
 		CurrentlyPlaying string
+		AutoStart        AutoStartStatus
 	}{
-		PCs:              s.configFile.Store.Devices,
+		PCs:       devices,
+		Groups:    groups.Tree(),
+		Templates: s.configFile.Store.GetTemplates(),
+		Sections:  sections,
+		Matches:   len(matches),
+		Search:    query.Search,
+		Sort:      query.Sort,
+		// Dragging only makes sense when all devices are shown in custom order
+		Reorderable:      len(matches) == len(devices) && (query.Sort == "" || query.Sort == device.SortCustom),
+		Online:           s.reachability.snapshot(),
+		Resolved:         s.resolvedHostnames(devices),
 		CurrentlyPlaying: s.currentDeviceId,
+		AutoStart:        s.autoStart.get(),
 	}
 
 	w.Header().Set("Content-Type", "text/html")
-	err := s.templates.ExecuteTemplate(w, "index.html", data)
+	err = s.templates.ExecuteTemplate(w, "index.html", data)
 	if err != nil {
 		log.Printf("Template execution error: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -101,15 +288,20 @@ func (s *Server) HandleConnect(w http.ResponseWriter, r *http.Request) {
 
 	id := r.URL.Path[len("/connect/"):]
 
-	for _, d := range s.Store.Devices {
-		if d.ID == id {
-			go s.connectToPC(d)
-			http.Redirect(w, r, "/", http.StatusSeeOther)
-			return
-		}
+	d, found := s.configFile.Store.Get(id)
+	if !found {
+		http.Error(w, "PC not found", http.StatusNotFound)
+		return
 	}
 
-	http.Error(w, "PC not found", http.StatusNotFound)
+	// A manual connection takes precedence over a pending auto-start
+	s.autoStart.stop()
+	go func() {
+		if err := s.connectToPC(d); err != nil {
+			log.Printf("Failed to connect to %s: %v", d.Name, err)
+		}
+	}()
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func (s *Server) HandleDisconnect(w http.ResponseWriter, r *http.Request) {
@@ -122,9 +314,101 @@ func (s *Server) HandleDisconnect(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// HandleGetPCs lists devices. See parseDeviceQuery for the supported query
+// parameters; the number of matches before pagination is returned in the
+// X-Total-Count header.
 func (s *Server) HandleGetPCs(w http.ResponseWriter, r *http.Request) {
+	query, err := s.parseDeviceQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	devices, total := s.configFile.Store.GetAll().Query(query, s.configFile.Store.GetGroups())
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(s.Store.Devices)
+	json.NewEncoder(w).Encode(s.deviceViews(devices))
+}
+
+// DeviceView is a device as returned by the device API
+type DeviceView struct {
+	device.Device
+	// ResolvedAddress is the address the device's host last resolved to.
+	// It is empty until the host has been resolved by a probe or connection.
+	ResolvedAddress string `json:"resolved_address,omitempty"`
+	// Effective are the settings the device connects with, including those
+	// inherited from its groups
+	Effective device.Settings `json:"effective"`
+	// Sources tells where each effective setting came from: "device",
+	// "group:" and the group ID, or "default"
+	Sources map[string]string `json:"sources"`
+}
+
+func (s *Server) deviceViews(devices device.Devices) []DeviceView {
+	groups := s.configFile.Store.GetGroups()
+	views := make([]DeviceView, len(devices))
+	for i, d := range devices {
+		views[i] = s.deviceView(d, groups)
+	}
+	return views
+}
+
+func (s *Server) deviceView(d device.Device, groups device.Groups) DeviceView {
+	effective, sources := groups.Effective(d)
+	view := DeviceView{Device: d, Effective: effective.Settings(), Sources: sources}
+	view.ResolvedAddress, _ = s.resolver.Cached(d.IPAddress)
+	return view
+}
+
+// resolvedHostnames maps the IDs of devices configured with a hostname to
+// the address it last resolved to
+func (s *Server) resolvedHostnames(devices device.Devices) map[string]string {
+	resolved := make(map[string]string)
+	for _, d := range devices {
+		if _, err := netip.ParseAddr(d.IPAddress); err == nil {
+			continue
+		}
+		if address, found := s.resolver.Cached(d.IPAddress); found {
+			resolved[d.ID] = address
+		}
+	}
+	return resolved
+}
+
+// HandlePCRoute dispatches /api/pcs/{id}/{action} requests
+func (s *Server) HandlePCRoute(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(r.URL.Path[len("/api/pcs/"):], "/")
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if _, found := s.configFile.Store.Get(id); !found {
+		http.Error(w, "PC not found", http.StatusNotFound)
+		return
+	}
+
+	switch action {
+	case "":
+		if r.Method == "PATCH" {
+			s.HandlePatchPC(w, r, id)
+		} else {
+			s.HandleGetPC(w, r, id)
+		}
+	case "thumbnail":
+		s.HandleThumbnail(w, r, id)
+	case "command":
+		s.HandleCommandPreview(w, r, id)
+	case "favorite":
+		s.HandleFavorite(w, r, id)
+	case "clone":
+		s.HandleClonePC(w, r, id)
+	case "export.rdp":
+		s.HandleExportRDP(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
 }
 
 func (s *Server) HandleAddPC(w http.ResponseWriter, r *http.Request) {
@@ -143,17 +427,10 @@ func (s *Server) HandleAddPC(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("Adding new device...")
-	err = s.configFile.AddDevice(d)
-	if err != nil {
-		log.Printf("Error adding device: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	err = s.Store.Add(d)
+	d, err = s.configFile.AddDevice(d)
 	if err != nil {
 		log.Printf("Error adding device: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDeviceError(w, err)
 		return
 	}
 
@@ -164,35 +441,112 @@ func (s *Server) HandleAddPC(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// HandleGetPC returns a device with its effective settings and its revision
+// as ETag
+func (s *Server) HandleGetPC(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	d, found := s.configFile.Store.Get(id)
+	if !found {
+		http.Error(w, "PC not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(d.Revision))
+	json.NewEncoder(w).Encode(s.deviceView(d, s.configFile.Store.GetGroups()))
+}
+
+// HandleEditPC replaces a device. The If-Match header names the revision the
+// change is based on; the revision in the body is ignored.
 func (s *Server) HandleEditPC(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	revision, ok := s.ifMatch(w, r)
+	if !ok {
+		return
+	}
+
 	var d device.Device
 	err := json.NewDecoder(r.Body).Decode(&d)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	d.Revision = revision
 
 	err = s.configFile.UpdateDevice(d)
 	if err != nil {
 		log.Printf("Error updating device: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDeviceError(w, err)
 		return
 	}
 
+	// Return the device as stored, e.g. with its position
+	d, _ = s.configFile.Store.Get(d.ID)
+
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(d.Revision))
 	json.NewEncoder(w).Encode(d)
 }
+
+// ValidationErrorResponse is returned with status 422 when a device is invalid
+type ValidationErrorResponse struct {
+	Error  string              `json:"error"`
+	Fields []device.FieldError `json:"fields"`
+}
+
+// fieldErrors returns the invalid fields of a validation error, or nil for
+// other errors
+func fieldErrors(err error) []device.FieldError {
+	var validationErr *device.ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Fields
+	}
+	return nil
+}
+
+// writeDeviceError reports a failed device change. Validation errors are
+// returned as JSON listing each invalid field so the UI can highlight them,
+// conflicts with the current version of the device.
+func writeDeviceError(w http.ResponseWriter, err error) {
+	var conflictErr *device.ConflictError
+	if errors.As(err, &conflictErr) {
+		writeConflict(w, err, conflictErr.Current, conflictErr.Current.Revision)
+		return
+	}
+
+	var validationErr *device.ValidationError
+	if !errors.As(err, &validationErr) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(ValidationErrorResponse{
+		Error:  validationErr.Error(),
+		Fields: validationErr.Fields,
+	})
+}
 func (s *Server) HandleDeletePC(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	revision, ok := s.ifMatch(w, r)
+	if !ok {
+		return
+	}
+
 	var data struct {
 		ID string `json:"id"`
 	}
@@ -202,32 +556,82 @@ func (s *Server) HandleDeletePC(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.configFile.DeleteDevice(data.ID)
+	err = s.configFile.DeleteDevice(data.ID, revision)
 	if err != nil {
 		log.Printf("Error deleting device: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeDeviceError(w, err)
 		return
 	}
 
+	err = s.state.Forget(data.ID)
+	if err != nil {
+		log.Printf("Failed to save device usage: %v", err)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(`{"success": true}`))
 }
-func (s *Server) HandleGetConfig(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
 
-	// Create a copy of the config without sensitive data
-	safeConfig := SafeEncodeConfig{
-		ListenPort:    s.configFile.ListenPort,
-		AutoStart:     s.configFile.AutoStart,
-		AutoStartID:   s.configFile.AutoStartID,
-		VncViewer:     s.configFile.VncViewer,
-		VncPasswdFile: s.configFile.VncPasswordFile,
-		RdpViewer:     s.configFile.RdpViewer,
+// HandleReorderPCs rearranges devices in the custom order. The IDs may list
+// all devices or only some of them, which then swap among their positions.
+func (s *Server) HandleReorderPCs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		IDs []string `json:"ids"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.configFile.ReorderDevices(data.IDs)
+	if err != nil {
+		log.Printf("Error reordering devices: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.configFile.Store.GetAll())
+}
+
+func (s *Server) HandleGetConfig(w http.ResponseWriter, r *http.Request) {
+	safeConfig := s.safeConfig()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(safeConfig.Revision))
 	json.NewEncoder(w).Encode(safeConfig)
 }
 
+// safeConfig returns a copy of the config without sensitive data
+func (s *Server) safeConfig() SafeEncodeConfig {
+	settings := s.configFile.Settings()
+	return SafeEncodeConfig{
+		ListenPort:    settings.ListenPort,
+		AutoStart:     settings.AutoStart,
+		AutoStartID:   settings.AutoStartID,
+		VncViewer:     settings.VncViewer,
+		VncPasswdFile: settings.VncPasswordFile,
+		RdpViewer:     settings.RdpViewer,
+
+		ThumbnailInterval: settings.ThumbnailInterval,
+		RestoreSession:    settings.RestoreSession,
+
+		AutoStartFallbackIDs: settings.AutoStartFallbackIDs,
+		AutoStartTimeout:     settings.AutoStartTimeout,
+
+		DiscoveryCIDR: settings.DiscoveryCIDR,
+
+		Revision:       settings.Revision,
+		RequireIfMatch: settings.RequireIfMatch,
+	}
+}
+
 type SafeEncodeConfig struct {
 	ListenPort    int    `json:"listen_port"`
 	AutoStart     bool   `json:"auto_start"`
@@ -235,6 +639,17 @@ type SafeEncodeConfig struct {
 	VncViewer     string `json:"vnc_viewer"`
 	VncPasswdFile string `json:"vnc_passwd_file"`
 	RdpViewer     string `json:"rdp_viewer"`
+
+	ThumbnailInterval int  `json:"thumbnail_interval"`
+	RestoreSession    bool `json:"restore_session"`
+
+	AutoStartFallbackIDs []string `json:"auto_start_fallback_ids"`
+	AutoStartTimeout     int      `json:"auto_start_timeout"`
+
+	DiscoveryCIDR string `json:"discovery_cidr"`
+
+	Revision       int64 `json:"revision"`
+	RequireIfMatch bool  `json:"require_if_match"`
 }
 
 type SafeDecodeConfig struct {
@@ -244,6 +659,16 @@ type SafeDecodeConfig struct {
 	VncViewer     string `json:"vnc_viewer"`
 	VncPasswdFile string `json:"vnc_passwd_file"`
 	RdpViewer     string `json:"rdp_viewer"`
+
+	ThumbnailInterval int  `json:"thumbnail_interval"`
+	RestoreSession    bool `json:"restore_session"`
+
+	AutoStartFallbackIDs []string `json:"auto_start_fallback_ids"`
+	AutoStartTimeout     int      `json:"auto_start_timeout"`
+
+	DiscoveryCIDR string `json:"discovery_cidr"`
+
+	Force bool `json:"force"`
 }
 
 func (s *Server) HandleUpdateConfig(w http.ResponseWriter, r *http.Request) {
@@ -252,6 +677,11 @@ func (s *Server) HandleUpdateConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	revision, ok := s.ifMatch(w, r)
+	if !ok {
+		return
+	}
+
 	var decodedConfig SafeDecodeConfig
 	err := json.NewDecoder(r.Body).Decode(&decodedConfig)
 	if err != nil {
@@ -276,22 +706,43 @@ func (s *Server) HandleUpdateConfig(w http.ResponseWriter, r *http.Request) {
 	newConfig.VncViewer = decodedConfig.VncViewer
 	newConfig.RdpViewer = decodedConfig.RdpViewer
 	newConfig.VncPasswordFile = decodedConfig.VncPasswdFile
+	newConfig.ThumbnailInterval = decodedConfig.ThumbnailInterval
+	newConfig.RestoreSession = decodedConfig.RestoreSession
+	newConfig.AutoStartFallbackIDs = decodedConfig.AutoStartFallbackIDs
+	newConfig.AutoStartTimeout = decodedConfig.AutoStartTimeout
+	newConfig.DiscoveryCIDR = decodedConfig.DiscoveryCIDR
+	newConfig.Force = decodedConfig.Force
+	newConfig.Revision = revision
 
 	err = s.configFile.Update(newConfig)
+	if errors.Is(err, configuration.ErrConflict) {
+		current := s.safeConfig()
+		writeConflict(w, err, current, current.Revision)
+		return
+	}
 	if err != nil {
 		log.Printf("Error updating config: %v", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	go s.refreshDiagnostics()
+
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(s.configFile.Settings().Revision))
 	w.Write([]byte(`{"success": true}`))
 }
 
-func (s *Server) connectToPC(pc device.Device) {
+// connectToPC replaces the running viewer with one for pc
+func (s *Server) connectToPC(pc device.Device) error {
+	// Lookups can be slow, don't block other connections meanwhile
+	address := s.viewerAddress(pc)
+
 	s.cmdLock.Lock()
 	defer s.cmdLock.Unlock()
 
+	s.stopReconnect()
+
 	// First disconnect any current connection
 	if s.currentCmd != nil && s.currentCmd.Process != nil {
 		log.Printf("Killing current process")
@@ -303,84 +754,150 @@ func (s *Server) connectToPC(pc device.Device) {
 		s.currentCmd = nil
 	}
 
-	var cmd *exec.Cmd
-	switch pc.Protocol {
-	case "vnc":
-		args := []string{pc.IPAddress}
-		if pc.Port != 0 {
-			args[0] = fmt.Sprintf("%s:%d", pc.IPAddress, pc.Port)
-		} else {
-			args[0] = fmt.Sprintf("%s:5900", pc.IPAddress)
-		}
-
-		if pc.FullScreen {
-			args = append(args, "-FullScreen")
-		}
-
-		args = append(args, "-PasswordFile", s.configFile.VncPasswordFile)
-
-		log.Println(args)
-
-		cmd = exec.Command(s.configFile.VncViewer, args...)
-	case "rdp":
-		args := []string{"-u", pc.Username}
+	return s.startViewer(pc, address, 0)
+}
 
-		if pc.Password != "" {
-			args = append(args, "-p", pc.Password)
-		}
+// viewerAddress resolves the address of pc and returns what the viewer
+// should connect to. It is called without s.cmdLock held.
+func (s *Server) viewerAddress(pc device.Device) string {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	address, err := s.resolver.Resolve(ctx, pc.IPAddress)
+	cancel()
+	if err != nil {
+		// The viewer resolves the host itself and may still succeed
+		log.Printf("Warning: cannot resolve %s: %v", pc.IPAddress, err)
+		return pc.IPAddress
+	}
 
-		if pc.FullScreen {
-			args = append(args, "-f")
+	if _, err := netip.ParseAddr(pc.IPAddress); err != nil {
+		log.Printf("%s resolves to %s", pc.IPAddress, address)
+		// Viewers usually can't resolve multicast DNS names themselves
+		if mdns.IsLocal(pc.IPAddress) {
+			return address
 		}
+	}
+	return pc.IPAddress
+}
 
-		args = append(args, pc.IPAddress)
-		if pc.Port != 0 {
-			args[len(args)-1] = fmt.Sprintf("%s:%d", pc.IPAddress, pc.Port)
-		}
+// startViewer runs the viewer for pc at address, see viewerAddress, with the
+// settings it inherits from its groups. attempt counts the reconnects in a
+// row. s.cmdLock must be held.
+func (s *Server) startViewer(pc device.Device, address string, attempt int) error {
+	pc = s.configFile.Store.Effective(pc)
+	log.Printf("Connecting to %s (%s)", pc.Name, pc.IPAddress)
+	pc.IPAddress = address
 
-		cmd = exec.Command(s.configFile.RdpViewer, args...)
-	default:
-		log.Printf("Unknown protocol: %s", pc.Protocol)
-		return
+	cmd, err := s.buildCommand(pc)
+	if err != nil {
+		return fmt.Errorf("failed to build command: %w", err)
 	}
 
-	log.Printf("Running command: %v %v", cmd.Path, cmd.Args)
-	log.Printf("Connecting to %s (%s)", pc.Name, pc.IPAddress)
+	preview := s.previewCommand(cmd, pc)
+	log.Printf("Running command: %v %v", preview.Path, preview.Args)
 
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	err := cmd.Start()
+	err = cmd.Start()
 	if err != nil {
-		log.Printf("Failed to start command: %v", err)
-		return
+		return fmt.Errorf("failed to start viewer: %w", err)
 	}
 
 	s.currentCmd = cmd
 	s.currentDeviceId = pc.ID
+	s.recordSession(pc.ID)
+	s.recordUsage(pc.ID)
+	started := time.Now()
 
 	go func() {
 		err := cmd.Wait()
 		if err != nil {
 			log.Printf("Command exited with error: %v", err)
-			s.currentCmd = nil
-			s.currentDeviceId = ""
 		}
 
 		s.cmdLock.Lock()
 		defer s.cmdLock.Unlock()
 
-		if s.currentCmd == cmd {
-			s.currentCmd = nil
-			s.currentDeviceId = ""
+		// Only clear the session if it wasn't replaced or disconnected in
+		// the meantime
+		if s.currentCmd != cmd {
+			return
+		}
+		s.currentCmd = nil
+		s.currentDeviceId = ""
+		s.recordSession()
+
+		if !pc.Reconnect.ShouldReconnect(err) || s.shuttingDown.Load() {
+			return
+		}
+		// A session that ran for a while starts a new series of attempts
+		if time.Since(started) >= stableSession {
+			attempt = 0
 		}
+		if attempt >= pc.Reconnect.Attempts() {
+			log.Printf("Not reconnecting to %s after %d attempts", pc.Name, attempt)
+			return
+		}
+		s.scheduleReconnect(pc, attempt+1)
 	}()
+
+	return nil
+}
+
+// stableSession is how long a viewer must run for its exit not to count as
+// a failed reconnect attempt
+const stableSession = time.Minute
+
+// scheduleReconnect starts the viewer for pc again after the delay of its
+// reconnect policy, unless another connection is made or the current one is
+// disconnected first. s.cmdLock must be held.
+func (s *Server) scheduleReconnect(pc device.Device, attempt int) {
+	delay := time.Duration(pc.Reconnect.DelaySeconds()) * time.Second
+	log.Printf("Reconnecting to %s in %v (attempt %d of %d)", pc.Name, delay, attempt, pc.Reconnect.Attempts())
+
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		// The device may have been changed or deleted while waiting. It is
+		// resolved before taking the lock, like in connectToPC.
+		current, found := s.configFile.Store.Get(pc.ID)
+		var address string
+		if found {
+			address = s.viewerAddress(current)
+		}
+
+		s.cmdLock.Lock()
+		defer s.cmdLock.Unlock()
+
+		if s.reconnect != timer || s.shuttingDown.Load() {
+			return
+		}
+		s.reconnect = nil
+
+		if !found {
+			log.Printf("Not reconnecting, PC %s was deleted", pc.ID)
+			return
+		}
+		if err := s.startViewer(current, address, attempt); err != nil {
+			log.Printf("Failed to reconnect to %s: %v", current.Name, err)
+		}
+	})
+	s.reconnect = timer
+}
+
+// stopReconnect cancels a pending reconnect. s.cmdLock must be held.
+func (s *Server) stopReconnect() {
+	if s.reconnect != nil {
+		s.reconnect.Stop()
+		s.reconnect = nil
+	}
 }
 
 func (s *Server) disconnectCurrentPC() {
 	s.cmdLock.Lock()
 	defer s.cmdLock.Unlock()
 
+	s.stopReconnect()
+
 	if s.currentCmd != nil && s.currentCmd.Process != nil {
 		log.Printf("Disconnecting current PC")
 		err := s.currentCmd.Process.Kill()
@@ -390,5 +907,19 @@ func (s *Server) disconnectCurrentPC() {
 		s.currentCmd.Wait()
 		s.currentCmd = nil
 		s.currentDeviceId = ""
+		s.recordSession()
+	}
+}
+
+// recordSession persists the devices with a running session so they can be
+// restored after a restart. Sessions ended by shutdown are not recorded.
+func (s *Server) recordSession(ids ...string) {
+	if s.shuttingDown.Load() {
+		return
+	}
+
+	err := s.state.SetActiveDevices(ids)
+	if err != nil {
+		log.Printf("Failed to save session state: %v", err)
 	}
 }
@@ -0,0 +1,594 @@
+package heimdall
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	configuration "spark-heimdall/internal/config"
+	"spark-heimdall/internal/device"
+	"sync"
+	"testing"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	configFile := configuration.NewConfig(path, "")
+	// Most tests send changes without If-Match, like older clients
+	configFile.RequireIfMatch = false
+	if err := configFile.Update(configuration.UpdateConfig{ListenPort: 8080, Force: true}); err != nil {
+		t.Fatalf("save config: %v", err)
+	}
+
+	return NewServer(configFile, nil, nil)
+}
+
+func postJSON(t *testing.T, handler http.HandlerFunc, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("POST", path, bytes.NewReader(data)))
+	return rec
+}
+
+func getPCs(t *testing.T, s *Server) device.Devices {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	s.HandleGetPCs(rec, httptest.NewRequest("GET", "/api/pcs", nil))
+
+	var devices device.Devices
+	if err := json.NewDecoder(rec.Body).Decode(&devices); err != nil {
+		t.Fatalf("decode devices: %v", err)
+	}
+	return devices
+}
+
+func TestHandlersShareOneStore(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := postJSON(t, s.HandleAddPC, "/api/pcs/add", device.Device{ID: "pc1", Name: "Before", IPAddress: "10.0.0.1", Protocol: "vnc"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = postJSON(t, s.HandleEditPC, "/api/pcs/edit", device.Device{ID: "pc1", Name: "After", IPAddress: "10.0.0.1", Protocol: "vnc"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("edit: %d %s", rec.Code, rec.Body)
+	}
+
+	devices := getPCs(t, s)
+	if len(devices) != 1 || devices[0].Name != "After" {
+		t.Fatalf("edit not visible in device list: %+v", devices)
+	}
+
+	rec = postJSON(t, s.HandleDeletePC, "/api/pcs/delete", map[string]string{"id": "pc1"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s", rec.Code, rec.Body)
+	}
+
+	if devices := getPCs(t, s); len(devices) != 0 {
+		t.Fatalf("delete not visible in device list: %+v", devices)
+	}
+
+	rec = httptest.NewRecorder()
+	s.HandleConnect(rec, httptest.NewRequest("POST", "/connect/pc1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("connect to deleted device: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestHandlersConcurrentRequests(t *testing.T) {
+	s := newTestServer(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			id := fmt.Sprintf("pc%d", 100+i)
+			postJSON(t, s.HandleAddPC, "/api/pcs/add", device.Device{ID: id, Name: id, IPAddress: "10.0.0.1", Protocol: "vnc"})
+			postJSON(t, s.HandleEditPC, "/api/pcs/edit", device.Device{ID: id, Name: id + "-edited", IPAddress: "10.0.0.1", Protocol: "vnc"})
+			getPCs(t, s)
+			if i%2 == 0 {
+				postJSON(t, s.HandleDeletePC, "/api/pcs/delete", map[string]string{"id": id})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	devices := getPCs(t, s)
+	if len(devices) != 4 {
+		t.Fatalf("expected 4 devices, got %d", len(devices))
+	}
+	for _, d := range devices {
+		if d.Name != d.ID+"-edited" {
+			t.Errorf("device %s lost its edit: %q", d.ID, d.Name)
+		}
+	}
+}
+
+func TestGetPCsQuery(t *testing.T) {
+	s := newTestServer(t)
+	for _, d := range []device.Device{
+		{ID: "pc1", Name: "Reception", IPAddress: "10.0.0.1", Protocol: "vnc"},
+		{ID: "pc2", Name: "Lab", IPAddress: "10.0.0.2", Protocol: "vnc", Tags: []string{"reception"}},
+		{ID: "pc3", Name: "Server", IPAddress: "10.0.1.1", Protocol: "rdp", Username: "admin"},
+	} {
+		if rec := postJSON(t, s.HandleAddPC, "/api/pcs/add", d); rec.Code != http.StatusOK {
+			t.Fatalf("add %s: %d %s", d.ID, rec.Code, rec.Body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.HandleGetPCs(rec, httptest.NewRequest("GET", "/api/pcs?q=reception&sort=name&limit=1", nil))
+
+	var devices device.Devices
+	if err := json.NewDecoder(rec.Body).Decode(&devices); err != nil {
+		t.Fatalf("decode devices: %v", err)
+	}
+	if len(devices) != 1 || devices[0].ID != "pc2" {
+		t.Fatalf("unexpected page: %+v", devices)
+	}
+	if total := rec.Header().Get("X-Total-Count"); total != "2" {
+		t.Fatalf("expected X-Total-Count 2, got %q", total)
+	}
+
+	for _, query := range []string{"sort=size", "limit=-1", "offset=x", "online=maybe"} {
+		rec := httptest.NewRecorder()
+		s.HandleGetPCs(rec, httptest.NewRequest("GET", "/api/pcs?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestFavoritesAndRecent(t *testing.T) {
+	s := newTestServer(t)
+	for _, id := range []string{"pc1", "pc2", "pc3"} {
+		postJSON(t, s.HandleAddPC, "/api/pcs/add", device.Device{ID: id, Name: id, IPAddress: "10.0.0.1", Protocol: "vnc"})
+	}
+
+	rec := postJSON(t, s.HandlePCRoute, "/api/pcs/pc2/favorite", map[string]bool{"favorite": true})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("favorite: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	s.HandleGetFavorites(rec, httptest.NewRequest("GET", "/api/pcs/favorites", nil))
+	var favorites device.Devices
+	if err := json.NewDecoder(rec.Body).Decode(&favorites); err != nil {
+		t.Fatal(err)
+	}
+	if len(favorites) != 1 || favorites[0].ID != "pc2" {
+		t.Fatalf("unexpected favorites: %+v", favorites)
+	}
+
+	s.recordUsage("pc3")
+	s.recordUsage("pc1")
+	s.recordUsage("pc1")
+
+	rec = httptest.NewRecorder()
+	s.HandleGetRecent(rec, httptest.NewRequest("GET", "/api/pcs/recent", nil))
+	var recent []RecentDevice
+	if err := json.NewDecoder(rec.Body).Decode(&recent); err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 2 || recent[0].ID != "pc1" || recent[0].ConnectCount != 2 || recent[1].ID != "pc3" {
+		t.Fatalf("unexpected recent devices: %+v", recent)
+	}
+}
+
+func TestReservedPCIDs(t *testing.T) {
+	s := newTestServer(t)
+
+	// The same routes as SetupRoutes, which registers on the default mux
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/pcs/recent", s.HandleGetRecent)
+	mux.HandleFunc("/api/pcs/", s.HandlePCRoute)
+
+	rec := postJSON(t, s.HandleAddPC, "/api/pcs/add", device.Device{Name: "Recent", IPAddress: "10.0.0.1", Protocol: "vnc"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add: %d %s", rec.Code, rec.Body)
+	}
+	var added device.Device
+	if err := json.NewDecoder(rec.Body).Decode(&added); err != nil {
+		t.Fatal(err)
+	}
+	if added.ID != "recent-2" {
+		t.Fatalf("ID = %q, want recent-2", added.ID)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/pcs/"+added.ID, nil))
+	var view DeviceView
+	if err := json.NewDecoder(rec.Body).Decode(&view); err != nil {
+		t.Fatalf("get: %d %v", rec.Code, err)
+	}
+	if rec.Code != http.StatusOK || view.Name != "Recent" {
+		t.Errorf("get: %d %+v", rec.Code, view)
+	}
+
+	rec = postJSON(t, s.HandleAddPC, "/api/pcs/add", device.Device{ID: "recent", Name: "Clash", IPAddress: "10.0.0.1", Protocol: "vnc"})
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reserved ID: expected 422, got %d", rec.Code)
+	}
+}
+
+func TestAddPCValidationErrors(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := postJSON(t, s.HandleAddPC, "/api/pcs/add", device.Device{
+		Name:      "Broken",
+		IPAddress: "999.1.1.1",
+		Protocol:  "rdp",
+		Port:      99999,
+	})
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d %s", rec.Code, rec.Body)
+	}
+
+	var response ValidationErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	fields := make(map[string]bool)
+	for _, f := range response.Fields {
+		fields[f.Field] = true
+	}
+	for _, field := range []string{"ip_address", "port", "username"} {
+		if !fields[field] {
+			t.Errorf("expected an error for %s, got %+v", field, response.Fields)
+		}
+	}
+
+	if devices := getPCs(t, s); len(devices) != 0 {
+		t.Fatalf("invalid device was saved: %+v", devices)
+	}
+}
+
+func TestBulkPCs(t *testing.T) {
+	s := newTestServer(t)
+	for _, name := range []string{"One", "Two"} {
+		if _, err := s.configFile.AddDevice(device.Device{Name: name, IPAddress: "10.0.0.1", Protocol: "vnc"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := postJSON(t, s.HandleBulkPCs, "/api/pcs/bulk", map[string]any{
+		"operations": []map[string]any{
+			{"op": "patch", "id": "one", "patch": map[string]any{"full_screen": true}},
+			{"op": "patch", "id": "two", "add_tags": []string{"lab"}},
+			{"op": "delete", "id": "missing"},
+		},
+	})
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d %s", rec.Code, rec.Body)
+	}
+	if d, _ := s.configFile.Store.Get("one"); d.IsFullScreen() {
+		t.Fatal("failed bulk request changed a device")
+	}
+
+	rec = postJSON(t, s.HandleBulkPCs, "/api/pcs/bulk", map[string]any{
+		"operations": []map[string]any{
+			{"op": "patch", "id": "one", "patch": map[string]any{"full_screen": true}},
+			{"op": "patch", "id": "two", "add_tags": []string{"lab"}},
+			{"op": "delete", "id": "two"},
+		},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body)
+	}
+
+	var response BulkResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if !response.Applied || len(response.Results) != 3 || !response.Results[0].Device.IsFullScreen() {
+		t.Fatalf("response = %+v", response)
+	}
+
+	// The changes were saved
+	data, err := os.ReadFile(s.configFile.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := configuration.NewConfig(s.configFile.FilePath, "")
+	if err := json.Unmarshal(data, reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if devices := reloaded.Store.GetAll(); len(devices) != 1 || !devices[0].IsFullScreen() {
+		t.Errorf("saved devices = %+v", devices)
+	}
+}
+
+func TestPatchPC(t *testing.T) {
+	s := newTestServer(t)
+	_, err := s.configFile.AddDevice(device.Device{
+		Name: "Server", IPAddress: "10.0.0.1", Protocol: "rdp", Username: "admin", Password: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patch := func(contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/api/pcs/server", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		s.HandlePCRoute(rec, req)
+		return rec
+	}
+
+	rec := patch(MergePatchContentType, `{"name": "Renamed", "full_screen": true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rec.Code, rec.Body)
+	}
+	var d device.Device
+	if err := json.NewDecoder(rec.Body).Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "Renamed" || !d.IsFullScreen() || d.Password != "secret" {
+		t.Errorf("patched device = %+v", d)
+	}
+
+	if rec := patch(MergePatchContentType, `{"port": 99999}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := patch("text/plain", `{}`); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest("PATCH", "/api/pcs/missing", bytes.NewReader([]byte(`{}`)))
+	rec = httptest.NewRecorder()
+	s.HandlePCRoute(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+}
+
+func TestOptimisticConcurrency(t *testing.T) {
+	s := newTestServer(t)
+	s.configFile.RequireIfMatch = true
+	if _, err := s.configFile.AddDevice(device.Device{Name: "Desk", IPAddress: "10.0.0.1", Protocol: "vnc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(handler http.HandlerFunc, method, path, ifMatch string, body any) *httptest.ResponseRecorder {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(data))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	rec := send(s.HandlePCRoute, "GET", "/api/pcs/desk", "", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"1"` {
+		t.Fatalf("get: %d, ETag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	edit := device.Device{ID: "desk", Name: "Desk 2", IPAddress: "10.0.0.1", Protocol: "vnc"}
+	if rec := send(s.HandleEditPC, "POST", "/api/pcs/edit", "", edit); rec.Code != http.StatusPreconditionRequired {
+		t.Errorf("edit without If-Match: expected 428, got %d", rec.Code)
+	}
+	rec = send(s.HandleEditPC, "POST", "/api/pcs/edit", `"1"`, edit)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"2"` {
+		t.Fatalf("edit: %d %s, ETag %q", rec.Code, rec.Body, rec.Header().Get("ETag"))
+	}
+
+	// Someone still looking at revision 1 is told about the newer version
+	edit.Name = "Desk 3"
+	rec = send(s.HandleEditPC, "POST", "/api/pcs/edit", `"1"`, edit)
+	if rec.Code != http.StatusPreconditionFailed || rec.Header().Get("ETag") != `"2"` {
+		t.Fatalf("stale edit: expected 412, got %d %s", rec.Code, rec.Body)
+	}
+	var conflict struct {
+		Current device.Device `json:"current"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&conflict); err != nil {
+		t.Fatal(err)
+	}
+	if conflict.Current.Name != "Desk 2" || conflict.Current.Revision != 2 {
+		t.Errorf("current = %+v", conflict.Current)
+	}
+
+	if rec := send(s.HandlePCRoute, "PATCH", "/api/pcs/desk", `W/"2"`, map[string]any{"name": "Weak"}); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("weak If-Match: expected 412, got %d", rec.Code)
+	}
+	if rec := send(s.HandleDeletePC, "POST", "/api/pcs/delete", `"1"`, map[string]string{"id": "desk"}); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale delete: expected 412, got %d", rec.Code)
+	}
+	bulk := map[string]any{"operations": []map[string]any{{"op": "delete", "id": "desk"}}}
+	if rec := send(s.HandleBulkPCs, "POST", "/api/pcs/bulk", "", bulk); rec.Code != http.StatusPreconditionRequired {
+		t.Errorf("bulk without revision: expected 428, got %d", rec.Code)
+	}
+	if rec := send(s.HandleDeletePC, "POST", "/api/pcs/delete", `"2"`, map[string]string{"id": "desk"}); rec.Code != http.StatusOK {
+		t.Errorf("delete: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = send(s.HandleGetConfig, "GET", "/api/config", "", nil)
+	revision := rec.Header().Get("ETag")
+	settings := map[string]any{"listen_port": "9090", "force": true}
+	if rec := send(s.HandleUpdateConfig, "POST", "/api/config/update", revision, settings); rec.Code != http.StatusOK {
+		t.Fatalf("update config: %d %s", rec.Code, rec.Body)
+	}
+	if rec := send(s.HandleUpdateConfig, "POST", "/api/config/update", revision, settings); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale config update: expected 412, got %d", rec.Code)
+	}
+}
+
+func TestTemplatesAndClone(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := postJSON(t, s.HandleAddTemplate, "/api/templates/add", device.Template{
+		ID:     "kiosk",
+		Name:   "Kiosk",
+		Device: device.Device{Protocol: "vnc", FullScreen: device.Bool(true), ViewerArgs: []string{"-ViewOnly"}},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add template: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = postJSON(t, s.HandleTemplateRoute, "/api/templates/kiosk/create", map[string]string{
+		"name": "Lobby", "ip_address": "10.0.0.20",
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create from template: %d %s", rec.Code, rec.Body)
+	}
+	var d device.Device
+	if err := json.NewDecoder(rec.Body).Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+	if d.ID != "lobby" || !d.IsFullScreen() || len(d.ViewerArgs) != 1 {
+		t.Errorf("device from template = %+v", d)
+	}
+
+	rec = httptest.NewRecorder()
+	s.HandlePCRoute(rec, httptest.NewRequest("POST", "/api/pcs/lobby/clone", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("clone: %d %s", rec.Code, rec.Body)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+	if d.ID != "lobby-copy" || d.Name != "Lobby (copy)" || d.IPAddress != "10.0.0.20" {
+		t.Errorf("clone = %+v", d)
+	}
+
+	rec = postJSON(t, s.HandleTemplateRoute, "/api/templates/kiosk/create", map[string]string{"name": "No Address"})
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for a device without address, got %d", rec.Code)
+	}
+	rec = postJSON(t, s.HandleTemplateRoute, "/api/templates/missing/create", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing template, got %d", rec.Code)
+	}
+}
+
+func TestGroupDefaults(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := postJSON(t, s.HandleAddGroup, "/api/groups/add", device.Group{
+		ID:       "office",
+		Name:     "Office",
+		Defaults: device.Settings{Protocol: "rdp", Username: "staff", FullScreen: device.Bool(true)},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add group: %d %s", rec.Code, rec.Body)
+	}
+	rec = postJSON(t, s.HandleAddGroup, "/api/groups/add", device.Group{Name: "Broken", Defaults: device.Settings{Port: -1}})
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for invalid defaults, got %d", rec.Code)
+	}
+
+	rec = postJSON(t, s.HandleAddPC, "/api/pcs/add", device.Device{
+		ID: "desk", Name: "Desk", IPAddress: "10.0.0.1", GroupID: "office", FullScreen: device.Bool(false),
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	s.HandlePCRoute(rec, httptest.NewRequest("GET", "/api/pcs/desk", nil))
+	var view DeviceView
+	if err := json.NewDecoder(rec.Body).Decode(&view); err != nil {
+		t.Fatal(err)
+	}
+	if view.Protocol != "" || view.Effective.Protocol != "rdp" || view.Effective.Username != "staff" || *view.Effective.FullScreen {
+		t.Errorf("raw and effective settings = %+v", view)
+	}
+	if view.Sources["protocol"] != "group:office" || view.Sources["full_screen"] != device.SourceDevice || view.Sources["port"] != device.SourceDefault {
+		t.Errorf("sources = %v", view.Sources)
+	}
+
+	// Filters match the inherited protocol
+	rec = httptest.NewRecorder()
+	s.HandleGetPCs(rec, httptest.NewRequest("GET", "/api/pcs?protocol=rdp", nil))
+	if rec.Header().Get("X-Total-Count") != "1" {
+		t.Errorf("expected the device to match protocol=rdp, got %s", rec.Header().Get("X-Total-Count"))
+	}
+}
+
+func TestShutdownKeepsSession(t *testing.T) {
+	s := newTestServer(t)
+	s.recordSession("pc1")
+
+	// Viewers exiting while requests drain must see the shutdown already
+	draining := make(chan bool, 1)
+	s.httpServer = &http.Server{}
+	s.httpServer.RegisterOnShutdown(func() {
+		draining <- s.shuttingDown.Load()
+	})
+
+	if err := s.shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if !<-draining {
+		t.Error("shuttingDown should be set before the HTTP server drains")
+	}
+
+	// Sessions ending during shutdown are not recorded
+	s.recordSession()
+	if active := s.state.ActiveDevices(); len(active) != 1 || active[0] != "pc1" {
+		t.Errorf("active devices after shutdown = %v, want [pc1]", active)
+	}
+}
+
+func TestBulkPCsSaveFailure(t *testing.T) {
+	s := newTestServer(t)
+	if _, err := s.configFile.AddDevice(device.Device{Name: "One", IPAddress: "10.0.0.1", Protocol: "vnc"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.configFile.Update(configuration.UpdateConfig{ListenPort: 8080, AutoStart: true, AutoStartID: "one", Force: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory in place of the config file makes saving fail
+	if err := os.Remove(s.configFile.FilePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(s.configFile.FilePath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := postJSON(t, s.HandleBulkPCs, "/api/pcs/bulk", map[string]any{
+		"operations": []map[string]any{
+			{"op": "add", "device": map[string]any{"name": "Two", "ip_address": "10.0.0.2", "protocol": "vnc"}},
+			{"op": "delete", "id": "one"},
+		},
+	})
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d %s", rec.Code, rec.Body)
+	}
+	var response BulkResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Applied || response.Error == "" {
+		t.Errorf("response = %+v, want not applied with an error", response)
+	}
+
+	// Nothing changed in memory either
+	devices := getPCs(t, s)
+	if len(devices) != 1 || devices[0].ID != "one" {
+		t.Errorf("devices after failed save = %+v", devices)
+	}
+	if settings := s.configFile.Settings(); settings.AutoStartID != "one" {
+		t.Errorf("auto-start device after failed save = %q", settings.AutoStartID)
+	}
+}
@@ -0,0 +1,169 @@
+package heimdall
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"spark-heimdall/internal/device"
+	"strings"
+)
+
+func (s *Server) HandleGetTemplates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.configFile.Store.GetTemplates())
+}
+
+func (s *Server) HandleAddTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var t device.Template
+	err := json.NewDecoder(r.Body).Decode(&t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t, err = s.configFile.AddTemplate(t)
+	if err != nil {
+		log.Printf("Error adding template: %v", err)
+		writeDeviceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+func (s *Server) HandleEditTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var t device.Template
+	err := json.NewDecoder(r.Body).Decode(&t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.configFile.UpdateTemplate(t)
+	if err != nil {
+		log.Printf("Error updating template: %v", err)
+		writeDeviceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+func (s *Server) HandleDeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		ID string `json:"id"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.configFile.DeleteTemplate(data.ID)
+	if err != nil {
+		log.Printf("Error deleting template: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"success": true}`))
+}
+
+// HandleTemplateRoute dispatches /api/templates/{id}/{action}
+func (s *Server) HandleTemplateRoute(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(r.URL.Path[len("/api/templates/"):], "/")
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if _, found := s.configFile.Store.GetTemplate(id); !found {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+
+	switch action {
+	case "create":
+		s.HandleCreateFromTemplate(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// HandleCreateFromTemplate adds a device from a template. The optional body
+// is a JSON merge patch with the fields of the new device, e.g. its name
+// and address.
+func (s *Server) HandleCreateFromTemplate(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	overrides, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d, err := s.configFile.AddDeviceFromTemplate(id, overrides)
+	if err != nil {
+		log.Printf("Error adding device from template %s: %v", id, err)
+		writeDeviceError(w, err)
+		return
+	}
+
+	writeCreatedDevice(w, d)
+}
+
+// HandleClonePC adds a copy of a device with a new ID. The optional body is
+// a JSON merge patch with fields that differ from the original, e.g. its
+// name and address.
+func (s *Server) HandleClonePC(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	overrides, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d, err := s.configFile.CloneDevice(id, overrides)
+	if err != nil {
+		log.Printf("Error cloning device %s: %v", id, err)
+		writeDeviceError(w, err)
+		return
+	}
+
+	writeCreatedDevice(w, d)
+}
+
+func writeCreatedDevice(w http.ResponseWriter, d device.Device) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(d.Revision))
+	err := json.NewEncoder(w).Encode(d)
+	if err != nil {
+		log.Printf("Error encoding device: %v", err)
+	}
+}
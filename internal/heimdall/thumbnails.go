@@ -0,0 +1,216 @@
+package heimdall
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"net/http"
+	"spark-heimdall/internal/device"
+	"spark-heimdall/internal/rfb"
+	"sync"
+	"time"
+)
+
+// thumbnailWidth is the maximum width of generated thumbnails in pixels
+const thumbnailWidth = 320
+
+const thumbnailTimeout = 10 * time.Second
+
+// maxConcurrentCaptures limits how many devices are captured at once
+const maxConcurrentCaptures = 4
+
+type thumbnail struct {
+	png     []byte
+	takenAt time.Time
+}
+
+// thumbnailCache holds the most recent screenshot of each VNC device
+type thumbnailCache struct {
+	lock   sync.RWMutex
+	images map[string]thumbnail
+}
+
+func (c *thumbnailCache) get(id string) (thumbnail, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	t, ok := c.images[id]
+	return t, ok
+}
+
+func (c *thumbnailCache) set(id string, t thumbnail) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.images == nil {
+		c.images = make(map[string]thumbnail)
+	}
+	c.images[id] = t
+}
+
+// prune removes thumbnails of devices that no longer exist
+func (c *thumbnailCache) prune(devices device.Devices) {
+	known := make(map[string]bool, len(devices))
+	for _, d := range devices {
+		known[d.ID] = true
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for id := range c.images {
+		if !known[id] {
+			delete(c.images, id)
+		}
+	}
+}
+
+// runThumbnails refreshes the thumbnail of every VNC device on the
+// configured interval. An interval of 0 disables thumbnails.
+func (s *Server) runThumbnails(ctx context.Context) {
+	for {
+		interval := time.Duration(s.configFile.Settings().ThumbnailInterval) * time.Second
+		if interval <= 0 {
+			interval = time.Minute
+		} else {
+			s.refreshThumbnails(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// refreshThumbnails captures the VNC devices a few at a time. Devices the
+// reachability probe found offline are skipped rather than waiting for
+// their connections to time out.
+func (s *Server) refreshThumbnails(ctx context.Context) {
+	devices := s.configFile.Store.GetAllEffective()
+	s.thumbnails.prune(devices)
+
+	passwordFile := s.configFile.Settings().VncPasswordFile
+	online := s.reachability.snapshot()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentCaptures)
+
+	for _, pc := range devices {
+		if pc.Protocol != "vnc" {
+			continue
+		}
+		if up, probed := online[pc.ID]; probed && !up {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			data, err := s.captureThumbnail(ctx, pc, passwordFile)
+			if err != nil {
+				log.Printf("Failed to capture thumbnail for %s: %v", pc.Name, err)
+				return
+			}
+			s.thumbnails.set(pc.ID, thumbnail{png: data, takenAt: time.Now()})
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Server) captureThumbnail(ctx context.Context, pc device.Device, passwordFile string) ([]byte, error) {
+	password := pc.Password
+	if password == "" && passwordFile != "" {
+		// A missing password file is fine, the server may not need one
+		password, _ = rfb.ReadPasswordFile(passwordFile)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, thumbnailTimeout)
+	address, err := s.deviceAddress(ctx, pc)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := rfb.Dial(address, password, thumbnailTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	if client.Width == 0 || client.Height == 0 {
+		return nil, fmt.Errorf("empty framebuffer")
+	}
+
+	img, err := client.Capture(thumbnailTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleImage(img, thumbnailWidth)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleImage downsamples src to at most maxWidth pixels wide, averaging
+// each block of source pixels that maps onto a destination pixel
+func scaleImage(src *image.RGBA, maxWidth int) *image.RGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	if sw <= maxWidth {
+		return src
+	}
+
+	dw := maxWidth
+	dh := max(1, sh*dw/sw)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+
+			var r, g, b, count int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					offset := src.PixOffset(src.Rect.Min.X+sx, src.Rect.Min.Y+sy)
+					r += int(src.Pix[offset])
+					g += int(src.Pix[offset+1])
+					b += int(src.Pix[offset+2])
+					count++
+				}
+			}
+
+			offset := dst.PixOffset(x, y)
+			dst.Pix[offset] = uint8(r / count)
+			dst.Pix[offset+1] = uint8(g / count)
+			dst.Pix[offset+2] = uint8(b / count)
+			dst.Pix[offset+3] = 0xFF
+		}
+	}
+
+	return dst
+}
+
+// HandleThumbnail serves the cached thumbnail of a VNC device
+func (s *Server) HandleThumbnail(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	t, found := s.thumbnails.get(id)
+	if !found {
+		http.Error(w, "Thumbnail not available", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, id+".png", t.takenAt, bytes.NewReader(t.png))
+}
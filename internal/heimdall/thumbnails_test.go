@@ -0,0 +1,39 @@
+package heimdall
+
+import (
+	"image"
+	"testing"
+)
+
+func TestScaleImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	reds := []uint8{0, 100, 200, 50, 20, 120, 220, 70}
+	for i, r := range reds {
+		offset := src.PixOffset(i%4, i/4)
+		src.Pix[offset] = r
+		src.Pix[offset+3] = 0xFF
+	}
+
+	// Each destination pixel averages a 2x2 block
+	dst := scaleImage(src, 2)
+	if dst.Rect.Dx() != 2 || dst.Rect.Dy() != 1 {
+		t.Fatalf("scaled size = %v, want 2x1", dst.Rect.Size())
+	}
+	for x, want := range []uint8{60, 135} {
+		offset := dst.PixOffset(x, 0)
+		if got := dst.Pix[offset]; got != want || dst.Pix[offset+3] != 0xFF {
+			t.Errorf("pixel %d = %v, want red %d", x, dst.Pix[offset:offset+4], want)
+		}
+	}
+
+	// Very wide images keep at least one row
+	wide := image.NewRGBA(image.Rect(0, 0, 1000, 1))
+	if dst := scaleImage(wide, 10); dst.Rect.Dx() != 10 || dst.Rect.Dy() != 1 {
+		t.Errorf("scaled size = %v, want 10x1", dst.Rect.Size())
+	}
+
+	// Images that already fit are returned as they are
+	if dst := scaleImage(src, 320); dst != src {
+		t.Error("a small image should not be scaled")
+	}
+}
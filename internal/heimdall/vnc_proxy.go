@@ -0,0 +1,267 @@
+package heimdall
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"spark-heimdall/internal/device"
+	"spark-heimdall/internal/websocket"
+	"sync"
+	"time"
+)
+
+// wsTokenTTL is how long a viewer page has to open its WebSocket
+const wsTokenTTL = 30 * time.Second
+
+type wsToken struct {
+	deviceId string
+	expires  time.Time
+}
+
+// wsTokens hands out single-use tokens that authorise one WebSocket bridge
+type wsTokens struct {
+	lock   sync.Mutex
+	tokens map[string]wsToken
+}
+
+func (t *wsTokens) issue(deviceId string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.tokens == nil {
+		t.tokens = make(map[string]wsToken)
+	}
+
+	// Drop expired tokens so abandoned viewer pages don't accumulate
+	now := time.Now()
+	for k, v := range t.tokens {
+		if now.After(v.expires) {
+			delete(t.tokens, k)
+		}
+	}
+
+	t.tokens[token] = wsToken{deviceId: deviceId, expires: now.Add(wsTokenTTL)}
+	return token, nil
+}
+
+// consume validates and invalidates token for deviceId
+func (t *wsTokens) consume(token, deviceId string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	entry, ok := t.tokens[token]
+	if !ok {
+		return false
+	}
+	delete(t.tokens, token)
+
+	return entry.deviceId == deviceId && time.Now().Before(entry.expires)
+}
+
+// bridgeSet tracks open WebSocket bridges so they can be closed on shutdown
+type bridgeSet struct {
+	lock  sync.Mutex
+	conns map[*websocket.Conn]struct{}
+}
+
+func (b *bridgeSet) add(ws *websocket.Conn) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.conns == nil {
+		b.conns = make(map[*websocket.Conn]struct{})
+	}
+	b.conns[ws] = struct{}{}
+}
+
+func (b *bridgeSet) remove(ws *websocket.Conn) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.conns, ws)
+}
+
+func (b *bridgeSet) closeAll() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for ws := range b.conns {
+		ws.Close()
+	}
+}
+
+// HandleVncViewer serves the in-browser VNC viewer page for a device
+func (s *Server) HandleVncViewer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Path[len("/vnc/"):]
+
+	pc, found := s.configFile.Store.GetEffective(id)
+	if !found {
+		http.Error(w, "PC not found", http.StatusNotFound)
+		return
+	}
+	if pc.Protocol != "vnc" {
+		http.Error(w, "PC does not use VNC", http.StatusBadRequest)
+		return
+	}
+
+	token, err := s.wsTokens.issue(pc.ID)
+	if err != nil {
+		log.Printf("Failed to issue WebSocket token: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		PC            device.Device
+		WebSocketPath string
+	}{
+		PC:            pc,
+		WebSocketPath: "/ws/vnc/" + url.PathEscape(pc.ID) + "?token=" + token,
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	err = s.templates.ExecuteTemplate(w, "vnc.html", data)
+	if err != nil {
+		log.Printf("Template execution error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// HandleVncWebSocket bridges a websockify-compatible WebSocket to the
+// device's VNC port. The request must carry a token issued by HandleVncViewer.
+func (s *Server) HandleVncWebSocket(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Path[len("/ws/vnc/"):]
+
+	if !s.wsTokens.consume(r.URL.Query().Get("token"), id) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if !sameOrigin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	pc, found := s.configFile.Store.GetEffective(id)
+	if !found {
+		http.Error(w, "PC not found", http.StatusNotFound)
+		return
+	}
+	if pc.Protocol != "vnc" {
+		http.Error(w, "PC does not use VNC", http.StatusBadRequest)
+		return
+	}
+
+	address, err := s.deviceAddress(r.Context(), pc)
+	if err != nil {
+		log.Printf("Failed to resolve %s (%s): %v", pc.Name, pc.IPAddress, err)
+		http.Error(w, "Failed to resolve PC address", http.StatusBadGateway)
+		return
+	}
+
+	target, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		log.Printf("Failed to connect to %s (%s): %v", pc.Name, address, err)
+		http.Error(w, "Failed to connect to PC", http.StatusBadGateway)
+		return
+	}
+
+	ws, err := websocket.Upgrade(w, r, []string{"binary", "base64"})
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		target.Close()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Bridging browser VNC session to %s (%s)", pc.Name, address)
+	s.bridges.add(ws)
+	bridgeWebSocket(ws, target)
+	s.bridges.remove(ws)
+	log.Printf("Browser VNC session to %s closed", pc.Name)
+}
+
+// bridgeWebSocket copies data in both directions until either side closes.
+// Clients negotiating the legacy "base64" subprotocol exchange text frames.
+func bridgeWebSocket(ws *websocket.Conn, target net.Conn) {
+	useBase64 := ws.Subprotocol == "base64"
+	done := make(chan struct{}, 2)
+
+	go func() {
+		defer func() { done <- struct{}{} }()
+		for {
+			op, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if op == websocket.OpText && useBase64 {
+				data, err = base64.StdEncoding.DecodeString(string(data))
+				if err != nil {
+					return
+				}
+			}
+			if _, err := target.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer func() { done <- struct{}{} }()
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := target.Read(buf)
+			if n > 0 {
+				var werr error
+				if useBase64 {
+					werr = ws.WriteMessage(websocket.OpText, []byte(base64.StdEncoding.EncodeToString(buf[:n])))
+				} else {
+					werr = ws.WriteMessage(websocket.OpBinary, buf[:n])
+				}
+				if werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+					log.Printf("VNC bridge read error: %v", err)
+				}
+				return
+			}
+		}
+	}()
+
+	<-done
+	ws.Close()
+	target.Close()
+	<-done
+}
+
+// sameOrigin rejects cross-site WebSocket requests. Browsers always send
+// Origin on WebSocket handshakes; non-browser clients may omit it.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == r.Host
+}
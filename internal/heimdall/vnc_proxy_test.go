@@ -0,0 +1,108 @@
+package heimdall
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"spark-heimdall/internal/device"
+	"testing"
+	"time"
+)
+
+func TestWsTokens(t *testing.T) {
+	var tokens wsTokens
+
+	token, err := tokens.issue("pc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tokens.consume(token, "pc1") {
+		t.Error("a fresh token should be accepted")
+	}
+	if tokens.consume(token, "pc1") {
+		t.Error("a token should only be accepted once")
+	}
+
+	// A token for another device is used up too
+	token, _ = tokens.issue("pc1")
+	if tokens.consume(token, "pc2") || tokens.consume(token, "pc1") {
+		t.Error("a token should only be accepted for its device")
+	}
+
+	token, _ = tokens.issue("pc1")
+	tokens.tokens[token] = wsToken{deviceId: "pc1", expires: time.Now().Add(-time.Second)}
+	if tokens.consume(token, "pc1") {
+		t.Error("an expired token should be rejected")
+	}
+}
+
+func TestHandleVncWebSocketTokens(t *testing.T) {
+	s := newTestServer(t)
+
+	// The handler gets as far as dialling the device before the upgrade
+	// fails on the recorder, which shows the token was accepted
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	rec := postJSON(t, s.HandleAddPC, "/api/pcs/add", device.Device{ID: "pc1", Name: "PC", IPAddress: "127.0.0.1", Port: port, Protocol: "vnc"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add PC: %d %s", rec.Code, rec.Body.String())
+	}
+
+	connect := func(token, origin string) int {
+		req := httptest.NewRequest("GET", "http://heimdall.local/ws/vnc/pc1?token="+token, nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		s.HandleVncWebSocket(rec, req)
+		return rec.Code
+	}
+
+	token, _ := s.wsTokens.issue("pc1")
+	if code := connect(token, "http://heimdall.local"); code != http.StatusBadRequest {
+		t.Errorf("first use = %d, want the upgrade to fail after the token check", code)
+	}
+	if code := connect(token, "http://heimdall.local"); code != http.StatusForbidden {
+		t.Errorf("reused token = %d, want %d", code, http.StatusForbidden)
+	}
+
+	if code := connect("", ""); code != http.StatusForbidden {
+		t.Errorf("missing token = %d, want %d", code, http.StatusForbidden)
+	}
+
+	token, _ = s.wsTokens.issue("pc1")
+	s.wsTokens.tokens[token] = wsToken{deviceId: "pc1", expires: time.Now().Add(-time.Second)}
+	if code := connect(token, ""); code != http.StatusForbidden {
+		t.Errorf("expired token = %d, want %d", code, http.StatusForbidden)
+	}
+
+	token, _ = s.wsTokens.issue("pc1")
+	if code := connect(token, "http://evil.example"); code != http.StatusForbidden {
+		t.Errorf("cross-origin request = %d, want %d", code, http.StatusForbidden)
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	for origin, want := range map[string]bool{
+		"":                           true,
+		"http://heimdall.local":      true,
+		"https://heimdall.local":     true,
+		"http://heimdall.local:80":   false,
+		"http://evil.example":        false,
+		"null":                       false,
+		"http://heimdall.local.evil": false,
+	} {
+		req := httptest.NewRequest("GET", "http://heimdall.local/ws/vnc/pc1", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if got := sameOrigin(req); got != want {
+			t.Errorf("sameOrigin with Origin %q = %v, want %v", origin, got, want)
+		}
+	}
+}
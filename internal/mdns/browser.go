@@ -0,0 +1,452 @@
+// Package mdns browses multicast DNS service advertisements (DNS-SD) for
+// remote desktop services and resolves ".local" hostnames.
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServiceTypes maps the browsed DNS-SD service types to device protocols
+var ServiceTypes = map[string]string{
+	"_rfb._tcp": "vnc",
+	"_rdp._tcp": "rdp",
+	"_ssh._tcp": "ssh",
+}
+
+var groupAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+const (
+	// maxQueryInterval caps the interval between browse queries, which
+	// starts at a second and doubles after every query
+	maxQueryInterval = 5 * time.Minute
+	// lookupRetry is how often Lookup repeats an unanswered address query
+	lookupRetry = time.Second
+	// firstRetry is how long an unanswered SRV or address question waits
+	// before it is asked again. The wait doubles up to maxQueryInterval.
+	firstRetry = time.Second
+)
+
+// Service is an advertised service instance
+type Service struct {
+	// ID is the full instance name, e.g. "Office Mac._rfb._tcp.local"
+	ID string `json:"id"`
+	// Name is the instance's user-visible name, e.g. "Office Mac"
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Protocol string `json:"protocol"`
+	Hostname string `json:"hostname,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	// Addresses are the known addresses of Hostname, IPv4 first
+	Addresses []string  `json:"addresses"`
+	Text      []string  `json:"text,omitempty"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+type instance struct {
+	service Service
+	expires time.Time
+}
+
+// Browser keeps a live catalogue of advertised services. It is safe for
+// concurrent use.
+type Browser struct {
+	conn *net.UDPConn
+
+	lock      sync.Mutex
+	instances map[string]*instance
+	// hosts maps lowercase hostnames to their addresses and when each
+	// expires. Only the hosts of instances and of running lookups are kept.
+	hosts map[string]map[netip.Addr]time.Time
+	// lookups counts the running Lookup calls per lowercase hostname
+	lookups map[string]int
+	// asked tracks when unanswered questions may be asked again, so that
+	// every packet on the network doesn't trigger another query
+	asked map[question]retry
+	// changed is closed and replaced whenever the catalogue changes
+	changed chan struct{}
+}
+
+// retry is the backoff of an unanswered question
+type retry struct {
+	next     time.Time
+	interval time.Duration
+}
+
+func newBrowser() *Browser {
+	return &Browser{
+		instances: make(map[string]*instance),
+		hosts:     make(map[string]map[netip.Addr]time.Time),
+		lookups:   make(map[string]int),
+		asked:     make(map[question]retry),
+		changed:   make(chan struct{}),
+	}
+}
+
+// Listen joins the mDNS multicast group on all interfaces
+func Listen() (*Browser, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, groupAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join mDNS group: %w", err)
+	}
+
+	b := newBrowser()
+	b.conn = conn
+	return b, nil
+}
+
+// Run browses until ctx is cancelled and then closes the connection
+func (b *Browser) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		b.conn.Close()
+	}()
+	go b.browse(ctx)
+
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := b.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("mDNS browser stopped: %v", err)
+			}
+			return
+		}
+
+		if missing := b.handle(buf[:n], time.Now()); len(missing) > 0 {
+			b.send(buildQuery(missing...))
+		}
+	}
+}
+
+// browse sends browse queries with an increasing interval
+func (b *Browser) browse(ctx context.Context) {
+	var questions []question
+	for serviceType := range ServiceTypes {
+		questions = append(questions, question{name: serviceType + ".local", qtype: typePTR})
+	}
+	query := buildQuery(questions...)
+
+	interval := time.Second
+	for {
+		b.send(query)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			interval = min(interval*2, maxQueryInterval)
+		}
+	}
+}
+
+func (b *Browser) send(msg []byte) {
+	if _, err := b.conn.WriteToUDP(msg, groupAddr); err != nil {
+		log.Printf("Failed to send mDNS query: %v", err)
+	}
+}
+
+// handle updates the catalogue from a received message and returns the
+// questions needed to complete it, e.g. for instances without an SRV record.
+// Questions asked recently are left out, see missing.
+func (b *Browser) handle(msg []byte, now time.Time) []question {
+	response, records, err := parseMessage(msg)
+	if !response || (err != nil && len(records) == 0) {
+		return nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// Instances are announced by PTR records, so handle those first
+	for _, r := range records {
+		if r.rtype == typePTR {
+			b.handlePTR(r, now)
+		}
+	}
+	// Addresses are only kept for known hosts, so SRV records go next
+	for _, r := range records {
+		if r.rtype == typeSRV || r.rtype == typeTXT {
+			b.handleInstanceRecord(r)
+		}
+	}
+	for _, r := range records {
+		if r.rtype == typeA || r.rtype == typeAAAA {
+			b.handleAddress(r, now)
+		}
+	}
+	b.prune(now)
+
+	close(b.changed)
+	b.changed = make(chan struct{})
+
+	return b.missing(now)
+}
+
+func (b *Browser) handlePTR(r record, now time.Time) {
+	serviceType := strings.TrimSuffix(strings.ToLower(r.name), ".local")
+	protocol, browsed := ServiceTypes[serviceType]
+	if !browsed {
+		return
+	}
+
+	key := strings.ToLower(r.target)
+	if r.ttl == 0 {
+		// Goodbye packet
+		delete(b.instances, key)
+		return
+	}
+
+	inst, found := b.instances[key]
+	if !found {
+		labels := splitName(r.target)
+		if len(labels) == 0 {
+			return
+		}
+		inst = &instance{service: Service{
+			ID:       r.target,
+			Name:     labels[0],
+			Type:     serviceType,
+			Protocol: protocol,
+		}}
+		b.instances[key] = inst
+	}
+	inst.service.LastSeen = now
+	inst.expires = now.Add(time.Duration(r.ttl) * time.Second)
+}
+
+func (b *Browser) handleInstanceRecord(r record) {
+	inst, found := b.instances[strings.ToLower(r.name)]
+	if !found {
+		return
+	}
+
+	switch r.rtype {
+	case typeSRV:
+		if r.ttl == 0 {
+			inst.service.Hostname, inst.service.Port = "", 0
+			return
+		}
+		inst.service.Hostname = strings.TrimSuffix(r.target, ".")
+		inst.service.Port = int(r.port)
+	case typeTXT:
+		inst.service.Text = r.text
+	}
+}
+
+func (b *Browser) handleAddress(r record, now time.Time) {
+	if !r.addr.IsValid() {
+		return
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(r.name, "."))
+	if r.ttl == 0 {
+		delete(b.hosts[host], r.addr)
+		return
+	}
+	if !b.wanted(host) {
+		return
+	}
+
+	if b.hosts[host] == nil {
+		b.hosts[host] = make(map[netip.Addr]time.Time)
+	}
+	b.hosts[host][r.addr] = now.Add(time.Duration(r.ttl) * time.Second)
+}
+
+// wanted reports whether the addresses of host are kept: it is the host of
+// an instance or being looked up
+func (b *Browser) wanted(host string) bool {
+	if b.lookups[host] > 0 {
+		return true
+	}
+	for _, inst := range b.instances {
+		if strings.EqualFold(strings.TrimSuffix(inst.service.Hostname, "."), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// prune removes expired instances and addresses, and hosts that are no
+// longer wanted
+func (b *Browser) prune(now time.Time) {
+	for key, inst := range b.instances {
+		if !now.Before(inst.expires) {
+			delete(b.instances, key)
+		}
+	}
+	for host, addrs := range b.hosts {
+		for addr, expires := range addrs {
+			if !now.Before(expires) {
+				delete(addrs, addr)
+			}
+		}
+		if len(addrs) == 0 || !b.wanted(host) {
+			delete(b.hosts, host)
+		}
+	}
+}
+
+// missing returns questions for instances without a host and hosts
+// without addresses. A question is asked again only after its backoff has
+// passed, starting at firstRetry and doubling up to maxQueryInterval.
+func (b *Browser) missing(now time.Time) []question {
+	needed := make(map[question]bool)
+	for _, inst := range b.instances {
+		switch {
+		case inst.service.Hostname == "":
+			needed[question{name: inst.service.ID, qtype: typeSRV}] = true
+		case len(b.addresses(inst.service.Hostname, now)) == 0:
+			needed[question{name: inst.service.Hostname, qtype: typeA}] = true
+			needed[question{name: inst.service.Hostname, qtype: typeAAAA}] = true
+		}
+	}
+
+	// Answered questions start over with the first retry
+	for q := range b.asked {
+		if !needed[q] {
+			delete(b.asked, q)
+		}
+	}
+
+	var questions []question
+	for q := range needed {
+		r, found := b.asked[q]
+		switch {
+		case !found:
+			r.interval = firstRetry
+		case now.Before(r.next):
+			continue
+		default:
+			r.interval = min(r.interval*2, maxQueryInterval)
+		}
+		r.next = now.Add(r.interval)
+		b.asked[q] = r
+		questions = append(questions, q)
+	}
+
+	// Keep the order stable, A before AAAA
+	slices.SortFunc(questions, func(a, b question) int {
+		if c := strings.Compare(a.name, b.name); c != 0 {
+			return c
+		}
+		return int(a.qtype) - int(b.qtype)
+	})
+	return questions
+}
+
+// addresses returns the unexpired addresses of host, IPv4 first
+func (b *Browser) addresses(host string, now time.Time) []netip.Addr {
+	var addrs []netip.Addr
+	for addr, expires := range b.hosts[strings.ToLower(strings.TrimSuffix(host, "."))] {
+		if now.Before(expires) {
+			addrs = append(addrs, addr)
+		}
+	}
+	slices.SortFunc(addrs, func(a, b netip.Addr) int {
+		if a.Is4() != b.Is4() {
+			if a.Is4() {
+				return -1
+			}
+			return 1
+		}
+		return a.Compare(b)
+	})
+	return addrs
+}
+
+// Services returns the currently advertised services sorted by name
+func (b *Browser) Services() []Service {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	services := []Service{}
+	for key, inst := range b.instances {
+		if !now.Before(inst.expires) {
+			delete(b.instances, key)
+			continue
+		}
+
+		service := inst.service
+		service.Addresses = []string{}
+		for _, addr := range b.addresses(service.Hostname, now) {
+			service.Addresses = append(service.Addresses, addr.String())
+		}
+		services = append(services, service)
+	}
+
+	slices.SortFunc(services, func(a, b Service) int {
+		if c := strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Type, b.Type)
+	})
+	return services
+}
+
+// Get returns the advertised service with the given ID
+func (b *Browser) Get(id string) (Service, bool) {
+	for _, service := range b.Services() {
+		if strings.EqualFold(service.ID, id) {
+			return service, true
+		}
+	}
+	return Service{}, false
+}
+
+// IsLocal reports whether host is a multicast DNS name
+func IsLocal(host string) bool {
+	return strings.HasSuffix(strings.ToLower(strings.TrimSuffix(host, ".")), ".local")
+}
+
+// Lookup resolves a ".local" hostname from the catalogue, querying the
+// network until it answers or ctx is done
+func (b *Browser) Lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	query := buildQuery(question{name: host, qtype: typeA}, question{name: host, qtype: typeAAAA})
+
+	// Answers are only kept for wanted hosts
+	key := strings.ToLower(strings.TrimSuffix(host, "."))
+	b.lock.Lock()
+	b.lookups[key]++
+	b.lock.Unlock()
+	defer func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if b.lookups[key]--; b.lookups[key] == 0 {
+			delete(b.lookups, key)
+		}
+	}()
+
+	// The query is only repeated every lookupRetry. Catalogue changes, e.g.
+	// from other hosts' traffic, just check for an answer again.
+	retry := time.NewTimer(0)
+	defer retry.Stop()
+	for {
+		b.lock.Lock()
+		addrs := b.addresses(host, time.Now())
+		changed := b.changed
+		b.lock.Unlock()
+
+		if len(addrs) > 0 {
+			return addrs, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no mDNS answer for %s: %w", host, ctx.Err())
+		case <-changed:
+		case <-retry.C:
+			b.send(query)
+			retry.Reset(lookupRetry)
+		}
+	}
+}
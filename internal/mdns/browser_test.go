@@ -0,0 +1,152 @@
+package mdns
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestBrowserCatalogue(t *testing.T) {
+	b := newBrowser()
+	now := time.Now()
+
+	missing := b.handle(buildResponse(
+		record{name: "_rfb._tcp.local", rtype: typePTR, ttl: 4500, target: "Office Mac._rfb._tcp.local"},
+		record{name: "_http._tcp.local", rtype: typePTR, ttl: 4500, target: "Printer._http._tcp.local"},
+	), now)
+	if len(missing) != 1 || missing[0].qtype != typeSRV {
+		t.Fatalf("missing = %+v, want an SRV question", missing)
+	}
+
+	missing = b.handle(buildResponse(
+		record{name: "Office Mac._rfb._tcp.local", rtype: typeSRV, ttl: 120, target: "office-mac.local", port: 5901},
+	), now)
+	if len(missing) != 2 || missing[0].name != "office-mac.local" {
+		t.Fatalf("missing = %+v, want address questions", missing)
+	}
+
+	missing = b.handle(buildResponse(
+		record{name: "Office-Mac.local", rtype: typeAAAA, ttl: 120, addr: netip.MustParseAddr("fe80::1")},
+		record{name: "office-mac.local", rtype: typeA, ttl: 120, addr: netip.MustParseAddr("192.168.1.20")},
+	), now)
+	if len(missing) != 0 {
+		t.Fatalf("missing = %+v, want none", missing)
+	}
+
+	services := b.Services()
+	if len(services) != 1 {
+		t.Fatalf("got %d services, want 1", len(services))
+	}
+	s := services[0]
+	if s.Name != "Office Mac" || s.Protocol != "vnc" || s.Hostname != "office-mac.local" || s.Port != 5901 {
+		t.Errorf("service = %+v", s)
+	}
+	if len(s.Addresses) != 2 || s.Addresses[0] != "192.168.1.20" {
+		t.Errorf("addresses = %v, want IPv4 first", s.Addresses)
+	}
+
+	if _, found := b.Get("office mac._RFB._tcp.local"); !found {
+		t.Error("Get should ignore case")
+	}
+
+	// A goodbye packet removes the instance
+	b.handle(buildResponse(
+		record{name: "_rfb._tcp.local", rtype: typePTR, ttl: 0, target: "Office Mac._rfb._tcp.local"},
+	), now)
+	if services := b.Services(); len(services) != 0 {
+		t.Errorf("services after goodbye = %+v", services)
+	}
+}
+
+func TestBrowserExpiry(t *testing.T) {
+	b := newBrowser()
+	past := time.Now().Add(-time.Hour)
+
+	b.handle(buildResponse(
+		record{name: "_ssh._tcp.local", rtype: typePTR, ttl: 60, target: "server._ssh._tcp.local"},
+		record{name: "server.local", rtype: typeA, ttl: 60, addr: netip.MustParseAddr("10.0.0.5")},
+	), past)
+
+	if services := b.Services(); len(services) != 0 {
+		t.Errorf("expired services = %+v", services)
+	}
+	if addrs := b.addresses("server.local", time.Now()); len(addrs) != 0 {
+		t.Errorf("expired addresses = %v", addrs)
+	}
+}
+
+func TestBrowserQuestionBackoff(t *testing.T) {
+	b := newBrowser()
+	now := time.Now()
+	ptr := buildResponse(record{name: "_rfb._tcp.local", rtype: typePTR, ttl: 4500, target: "Office Mac._rfb._tcp.local"})
+
+	if missing := b.handle(ptr, now); len(missing) != 1 {
+		t.Fatalf("missing = %+v, want an SRV question", missing)
+	}
+
+	// Other traffic doesn't repeat the question right away
+	if missing := b.handle(ptr, now.Add(100*time.Millisecond)); len(missing) != 0 {
+		t.Errorf("missing = %+v, want none before the first retry", missing)
+	}
+
+	now = now.Add(firstRetry)
+	if missing := b.handle(ptr, now); len(missing) != 1 {
+		t.Errorf("missing = %+v, want the SRV question again", missing)
+	}
+
+	// The wait has doubled
+	if missing := b.handle(ptr, now.Add(firstRetry)); len(missing) != 0 {
+		t.Errorf("missing = %+v, want none before the second retry", missing)
+	}
+	if missing := b.handle(ptr, now.Add(2*firstRetry)); len(missing) != 1 {
+		t.Errorf("missing = %+v, want the SRV question after the second retry", missing)
+	}
+}
+
+func TestBrowserKeepsOnlyWantedHosts(t *testing.T) {
+	b := newBrowser()
+	now := time.Now()
+
+	b.handle(buildResponse(
+		record{name: "_rfb._tcp.local", rtype: typePTR, ttl: 4500, target: "Office Mac._rfb._tcp.local"},
+		record{name: "Office Mac._rfb._tcp.local", rtype: typeSRV, ttl: 120, target: "office-mac.local", port: 5900},
+		record{name: "office-mac.local", rtype: typeA, ttl: 120, addr: netip.MustParseAddr("192.168.1.20")},
+		record{name: "phone.local", rtype: typeA, ttl: 120, addr: netip.MustParseAddr("192.168.1.30")},
+	), now)
+
+	if _, found := b.hosts["phone.local"]; found {
+		t.Error("addresses of an unrelated host were kept")
+	}
+	if addrs := b.addresses("office-mac.local", now); len(addrs) != 1 {
+		t.Errorf("addresses = %v, want the instance host", addrs)
+	}
+
+	// Hosts being looked up are kept too
+	b.lookups["phone.local"] = 1
+	b.handle(buildResponse(
+		record{name: "phone.local", rtype: typeA, ttl: 1, addr: netip.MustParseAddr("192.168.1.30")},
+	), now)
+	if addrs := b.addresses("phone.local", now); len(addrs) != 1 {
+		t.Errorf("addresses = %v, want the looked up host", addrs)
+	}
+
+	// Expired addresses are pruned with the next packet
+	b.handle(buildResponse(), now.Add(2*time.Second))
+	if _, found := b.hosts["phone.local"]; found {
+		t.Error("expired addresses were kept")
+	}
+}
+
+func TestIsLocal(t *testing.T) {
+	for host, want := range map[string]bool{
+		"office-mac.local":  true,
+		"Office-Mac.LOCAL.": true,
+		"local":             false,
+		"example.com":       false,
+		"192.168.1.20":      false,
+	} {
+		if got := IsLocal(host); got != want {
+			t.Errorf("IsLocal(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
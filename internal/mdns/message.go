@@ -0,0 +1,246 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"strings"
+)
+
+// DNS record types used by DNS-SD
+const (
+	typeA    = 1
+	typePTR  = 12
+	typeTXT  = 16
+	typeAAAA = 28
+	typeSRV  = 33
+)
+
+const (
+	classIN = 1
+	// classMask strips the cache-flush bit mDNS sets in record classes
+	classMask = 0x7fff
+	// flagResponse is the QR bit of the header flags
+	flagResponse = 0x8000
+)
+
+var errMalformed = errors.New("malformed DNS message")
+
+type question struct {
+	name  string
+	qtype uint16
+}
+
+// record is a resource record with its data decoded according to its type
+type record struct {
+	name  string
+	rtype uint16
+	ttl   uint32
+	// target is the name a PTR record points to or the host of an SRV record
+	target string
+	port   uint16
+	addr   netip.Addr
+	text   []string
+}
+
+// buildQuery encodes a multicast query for the questions
+func buildQuery(questions ...question) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[4:], uint16(len(questions)))
+	for _, q := range questions {
+		msg = appendName(msg, q.name)
+		msg = binary.BigEndian.AppendUint16(msg, q.qtype)
+		msg = binary.BigEndian.AppendUint16(msg, classIN)
+	}
+	return msg
+}
+
+// appendName encodes a dotted name without compression. Dots escaped with a
+// backslash belong to the label.
+func appendName(msg []byte, name string) []byte {
+	for _, label := range splitName(name) {
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+// splitName splits a dotted name into its unescaped labels
+func splitName(name string) []string {
+	var labels []string
+	var label strings.Builder
+	escaped := false
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case escaped:
+			label.WriteByte(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '.':
+			if label.Len() > 0 {
+				labels = append(labels, label.String())
+			}
+			label.Reset()
+		default:
+			label.WriteByte(c)
+		}
+	}
+	if label.Len() > 0 {
+		labels = append(labels, label.String())
+	}
+	return labels
+}
+
+// escapeLabel escapes dots and backslashes so a label can be part of a dotted name
+func escapeLabel(label string) string {
+	label = strings.ReplaceAll(label, `\`, `\\`)
+	return strings.ReplaceAll(label, ".", `\.`)
+}
+
+// parseMessage decodes the records of a DNS message. Queries are reported
+// with response set to false and their records are not decoded.
+func parseMessage(msg []byte) (response bool, records []record, err error) {
+	if len(msg) < 12 {
+		return false, nil, errMalformed
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&flagResponse == 0 {
+		return false, nil, nil
+	}
+
+	qdCount := int(binary.BigEndian.Uint16(msg[4:]))
+	rrCount := int(binary.BigEndian.Uint16(msg[6:])) +
+		int(binary.BigEndian.Uint16(msg[8:])) +
+		int(binary.BigEndian.Uint16(msg[10:]))
+
+	offset := 12
+	for i := 0; i < qdCount; i++ {
+		if _, offset, err = readName(msg, offset); err != nil {
+			return true, nil, err
+		}
+		offset += 4
+	}
+
+	for i := 0; i < rrCount; i++ {
+		var r record
+		r, offset, err = readRecord(msg, offset)
+		if err != nil {
+			return true, records, err
+		}
+		records = append(records, r)
+	}
+
+	return true, records, nil
+}
+
+func readRecord(msg []byte, offset int) (record, int, error) {
+	name, offset, err := readName(msg, offset)
+	if err != nil {
+		return record{}, 0, err
+	}
+	if offset+10 > len(msg) {
+		return record{}, 0, errMalformed
+	}
+
+	r := record{
+		name:  name,
+		rtype: binary.BigEndian.Uint16(msg[offset:]),
+		ttl:   binary.BigEndian.Uint32(msg[offset+4:]),
+	}
+	class := binary.BigEndian.Uint16(msg[offset+2:]) & classMask
+	length := int(binary.BigEndian.Uint16(msg[offset+8:]))
+	offset += 10
+
+	end := offset + length
+	if end > len(msg) {
+		return record{}, 0, errMalformed
+	}
+	if class != classIN {
+		return r, end, nil
+	}
+
+	data := msg[offset:end]
+	switch r.rtype {
+	case typeA:
+		if len(data) == 4 {
+			r.addr = netip.AddrFrom4([4]byte(data))
+		}
+	case typeAAAA:
+		if len(data) == 16 {
+			r.addr = netip.AddrFrom16([16]byte(data))
+		}
+	case typePTR:
+		if r.target, _, err = readName(msg, offset); err != nil {
+			return record{}, 0, err
+		}
+	case typeSRV:
+		if len(data) < 7 {
+			return record{}, 0, errMalformed
+		}
+		r.port = binary.BigEndian.Uint16(data[4:])
+		if r.target, _, err = readName(msg, offset+6); err != nil {
+			return record{}, 0, err
+		}
+	case typeTXT:
+		for i := 0; i < len(data); {
+			n := int(data[i])
+			if i+1+n > len(data) {
+				return record{}, 0, errMalformed
+			}
+			if n > 0 {
+				r.text = append(r.text, string(data[i+1:i+1+n]))
+			}
+			i += 1 + n
+		}
+	}
+
+	return r, end, nil
+}
+
+// readName decodes a possibly compressed name starting at offset and
+// returns it with the offset following it
+func readName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+	// Every pointer must go backwards, which rules out loops
+	limit := offset
+
+	for {
+		if offset >= len(msg) {
+			return "", 0, errMalformed
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if next == -1 {
+				next = offset + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(msg) {
+				return "", 0, errMalformed
+			}
+			pointer := int(binary.BigEndian.Uint16(msg[offset:]) & 0x3fff)
+			if pointer >= limit {
+				return "", 0, errMalformed
+			}
+			if next == -1 {
+				next = offset + 2
+			}
+			offset, limit = pointer, pointer
+		case length&0xc0 != 0:
+			return "", 0, errMalformed
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, escapeLabel(string(msg[offset+1:offset+1+length])))
+			offset += 1 + length
+		}
+	}
+}
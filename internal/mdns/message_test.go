@@ -0,0 +1,107 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+// buildResponse encodes records as an uncompressed mDNS response
+func buildResponse(records ...record) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[2:], flagResponse|0x0400)
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(records)))
+	for _, r := range records {
+		msg = appendName(msg, r.name)
+		msg = binary.BigEndian.AppendUint16(msg, r.rtype)
+		msg = binary.BigEndian.AppendUint16(msg, classIN|0x8000)
+		msg = binary.BigEndian.AppendUint32(msg, r.ttl)
+
+		var data []byte
+		switch r.rtype {
+		case typeA, typeAAAA:
+			data = r.addr.AsSlice()
+		case typePTR:
+			data = appendName(nil, r.target)
+		case typeSRV:
+			data = binary.BigEndian.AppendUint16(data, 0)
+			data = binary.BigEndian.AppendUint16(data, 0)
+			data = binary.BigEndian.AppendUint16(data, r.port)
+			data = appendName(data, r.target)
+		case typeTXT:
+			for _, text := range r.text {
+				data = append(data, byte(len(text)))
+				data = append(data, text...)
+			}
+		}
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(data)))
+		msg = append(msg, data...)
+	}
+	return msg
+}
+
+func TestParseMessage(t *testing.T) {
+	want := []record{
+		{name: "_rfb._tcp.local", rtype: typePTR, ttl: 4500, target: `Office\.Mac._rfb._tcp.local`},
+		{name: `Office\.Mac._rfb._tcp.local`, rtype: typeSRV, ttl: 120, target: "office-mac.local", port: 5900},
+		{name: `Office\.Mac._rfb._tcp.local`, rtype: typeTXT, ttl: 4500, text: []string{"a=1", "b"}},
+		{name: "office-mac.local", rtype: typeA, ttl: 120, addr: netip.MustParseAddr("192.168.1.20")},
+		{name: "office-mac.local", rtype: typeAAAA, ttl: 120, addr: netip.MustParseAddr("fe80::1")},
+	}
+
+	response, records, err := parseMessage(buildResponse(want...))
+	if err != nil || !response {
+		t.Fatalf("parseMessage() = %v, %v", response, err)
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d", len(records), len(want))
+	}
+	for i := range want {
+		got := records[i]
+		if got.name != want[i].name || got.rtype != want[i].rtype || got.ttl != want[i].ttl ||
+			got.target != want[i].target || got.port != want[i].port || got.addr != want[i].addr ||
+			!slices.Equal(got.text, want[i].text) {
+			t.Errorf("record %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestParseMessageCompression(t *testing.T) {
+	// PTR _rfb._tcp.local -> "Desk" + pointer to _rfb._tcp.local at offset 12
+	msg := buildResponse(record{name: "_rfb._tcp.local", rtype: typePTR, ttl: 10})
+	msg = msg[:len(msg)-3]
+	msg = append(msg, 0, 7, 4, 'D', 'e', 's', 'k', 0xc0, 12)
+
+	_, records, err := parseMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := records[0].target; got != "Desk._rfb._tcp.local" {
+		t.Errorf("target = %q", got)
+	}
+}
+
+func TestParseMessageMalformed(t *testing.T) {
+	valid := buildResponse(record{name: "host.local", rtype: typeA, ttl: 10, addr: netip.MustParseAddr("10.0.0.1")})
+
+	loop := slices.Clone(valid[:12])
+	loop = append(loop, 0xc0, 12)
+
+	for name, msg := range map[string][]byte{
+		"short header": valid[:5],
+		"truncated":    valid[:len(valid)-3],
+		"pointer loop": loop,
+	} {
+		if _, _, err := parseMessage(msg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestParseMessageIgnoresQueries(t *testing.T) {
+	response, records, err := parseMessage(buildQuery(question{name: "_rfb._tcp.local", qtype: typePTR}))
+	if response || records != nil || err != nil {
+		t.Errorf("parseMessage(query) = %v, %v, %v", response, records, err)
+	}
+}
@@ -0,0 +1,343 @@
+// Package rdpfile reads and writes Microsoft Remote Desktop Connection
+// (.rdp) files and converts them to and from devices.
+package rdpfile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"spark-heimdall/internal/device"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// Extension is the file extension of .rdp files
+const Extension = ".rdp"
+
+// ContentType is the MIME type .rdp files are served with
+const ContentType = "application/x-rdp"
+
+// maxFileSize limits the size of a parsed file
+const maxFileSize = 1 << 20
+
+// Setting types
+const (
+	TypeString  = "s"
+	TypeInteger = "i"
+	TypeBinary  = "b"
+)
+
+// Setting is one "name:type:value" line
+type Setting struct {
+	Name  string
+	Type  string
+	Value string
+}
+
+// File is the settings of an .rdp file in file order
+type File []Setting
+
+// Get returns the value of the named setting. Names are case-insensitive.
+func (f File) Get(name string) (string, bool) {
+	for _, setting := range f {
+		if strings.EqualFold(setting.Name, name) {
+			return setting.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set replaces the named setting or appends it
+func (f *File) Set(name, settingType, value string) {
+	for i := range *f {
+		if strings.EqualFold((*f)[i].Name, name) {
+			(*f)[i] = Setting{Name: name, Type: settingType, Value: value}
+			return
+		}
+	}
+	*f = append(*f, Setting{Name: name, Type: settingType, Value: value})
+}
+
+// Parse reads an .rdp file. Files saved by Windows are usually UTF-16 with
+// a byte order mark, others UTF-8.
+func Parse(r io.Reader) (File, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxFileSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .rdp file: %w", err)
+	}
+	text := decode(data)
+
+	var f File
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxFileSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		// The value may contain colons, e.g. "full address:s:host:3389"
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid line %q: expected name:type:value", line)
+		}
+		f = append(f, Setting{
+			Name:  strings.TrimSpace(parts[0]),
+			Type:  strings.ToLower(strings.TrimSpace(parts[1])),
+			Value: parts[2],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read .rdp file: %w", err)
+	}
+
+	if len(f) == 0 {
+		return nil, fmt.Errorf("no settings found")
+	}
+	return f, nil
+}
+
+// decode converts UTF-16 files with a byte order mark and strips a UTF-8 one
+func decode(data []byte) string {
+	var order func([]byte) uint16
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		order = func(b []byte) uint16 { return uint16(b[0]) | uint16(b[1])<<8 }
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		order = func(b []byte) uint16 { return uint16(b[1]) | uint16(b[0])<<8 }
+	default:
+		return string(bytes.TrimPrefix(data, []byte{0xef, 0xbb, 0xbf}))
+	}
+
+	units := make([]uint16, 0, len(data)/2)
+	for i := 2; i+1 < len(data); i += 2 {
+		units = append(units, order(data[i:]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// WriteTo writes the file as UTF-8 with Windows line endings
+func (f File) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	for _, setting := range f {
+		fmt.Fprintf(&buf, "%s:%s:%s\r\n", setting.Name, setting.Type, setting.Value)
+	}
+	return buf.WriteTo(w)
+}
+
+// Screen modes of the "screen mode id" setting
+const (
+	screenModeWindowed   = "1"
+	screenModeFullScreen = "2"
+)
+
+// Gateway usage methods of the "gatewayusagemethod" setting
+const (
+	gatewayNotUsed = "0"
+	gatewayAlways  = "1"
+	gatewayBypass  = "4"
+)
+
+// mapped lists the settings that are turned into device fields
+var mapped = []string{
+	"full address", "alternate full address", "server port", "username", "domain",
+	"screen mode id", "desktopwidth", "desktopheight",
+	"gatewayhostname", "gatewayusagemethod", "gatewayprofileusagemethod", "gatewaycredentialssource",
+	"redirectclipboard", "redirectdrives", "drivestoredirect", "redirectprinters", "redirectsmartcards",
+}
+
+// ignored lists settings that only affect the Windows client or that are
+// written with their defaults by every client
+var ignored = []string{
+	"allow desktop composition", "allow font smoothing", "audiocapturemode", "audiomode",
+	"authentication level", "autoreconnection enabled", "bitmapcachepersistenable",
+	"compression", "connection type", "disable cursor setting", "disable full window drag",
+	"disable menu anims", "disable themes", "disable wallpaper", "displayconnectionbar",
+	"enablecredsspsupport", "enableworkspacereconnect", "kdcproxyname", "keyboardhook",
+	"negotiate security layer", "networkautodetect", "prompt for credentials",
+	"promptcredentialonce", "remoteapplicationmode", "session bpp", "smart sizing",
+	"use multimon", "use redirection server name", "videoplaybackmode", "winposstr",
+	"bandwidthautodetect", "redirectcomports", "redirectposdevices", "redirectwebauthn",
+	"redirectdirectx", "devicestoredirect", "camerastoredirect", "usbdevicestoredirect",
+	"audioqualitymode", "administrative session", "alternate shell", "shell working directory",
+	"gatewaybrokeringtype", "rdgiskdcproxy", "encode redirected video capture",
+	"redirected video capture encoding quality", "dynamic resolution", "desktop size id",
+	"selectedmonitors", "maximizetocurrentdisplays", "singlemoninwindowedmode",
+}
+
+// Device converts the file to an RDP device and returns the settings that
+// have a value but could not be imported
+func (f File) Device() (device.Device, []string, error) {
+	d := device.Device{Protocol: "rdp"}
+
+	address, found := f.Get("full address")
+	if !found || address == "" {
+		address, _ = f.Get("alternate full address")
+	}
+	host, port, err := splitAddress(address)
+	if err != nil {
+		return d, nil, err
+	}
+	d.IPAddress = host
+	if port == 0 {
+		if value, found := f.Get("server port"); found {
+			port, _ = strconv.Atoi(value)
+		}
+	}
+	if port != device.DefaultPort("rdp") {
+		d.Port = port
+	}
+	d.Name = host
+
+	d.Username, _ = f.Get("username")
+	if domain, _ := f.Get("domain"); domain != "" && d.Username != "" && !strings.Contains(d.Username, `\`) {
+		d.Username = domain + `\` + d.Username
+	}
+
+	if mode, _ := f.Get("screen mode id"); mode == screenModeFullScreen {
+		d.FullScreen = device.Bool(true)
+	}
+	width, _ := f.Get("desktopwidth")
+	height, _ := f.Get("desktopheight")
+	if isSet(width) && isSet(height) {
+		d.Screen = width + "x" + height
+	}
+
+	if usage, _ := f.Get("gatewayusagemethod"); usage != gatewayNotUsed && usage != gatewayBypass {
+		d.RDP.Gateway, _ = f.Get("gatewayhostname")
+	}
+	d.RDP.RedirectClipboard = f.enabled("redirectclipboard")
+	drives, _ := f.Get("drivestoredirect")
+	d.RDP.RedirectDrives = f.enabled("redirectdrives") || drives != ""
+	d.RDP.RedirectPrinters = f.enabled("redirectprinters")
+	d.RDP.RedirectSmartCards = f.enabled("redirectsmartcards")
+
+	return d, f.unsupported(), nil
+}
+
+func (f File) enabled(name string) bool {
+	value, _ := f.Get(name)
+	return value == "1"
+}
+
+// unsupported returns the sorted names of settings with a value that are
+// neither mapped nor ignored
+func (f File) unsupported() []string {
+	var names []string
+	for _, setting := range f {
+		name := strings.ToLower(setting.Name)
+		if !isSet(setting.Value) || slices.Contains(mapped, name) || slices.Contains(ignored, name) {
+			continue
+		}
+		names = append(names, setting.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// isSet reports whether a value differs from the empty and disabled defaults
+func isSet(value string) bool {
+	return value != "" && value != "0"
+}
+
+// splitAddress splits "host", "host:port", "[v6 address]" or
+// "[v6 address]:port"
+func splitAddress(address string) (string, int, error) {
+	if address == "" {
+		return "", 0, fmt.Errorf("no full address set")
+	}
+
+	// A bare IPv6 address has more than one colon and no brackets
+	if !strings.HasPrefix(address, "[") && strings.Count(address, ":") != 1 {
+		return address, 0, nil
+	}
+
+	// A bracketed address without a port uses the default
+	if host, found := strings.CutPrefix(address, "["); found && strings.HasSuffix(host, "]") {
+		return strings.TrimSuffix(host, "]"), 0, nil
+	}
+
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid full address %q: %w", address, err)
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in full address %q", address)
+	}
+	return host, port, nil
+}
+
+// FromDevice returns the .rdp file for an RDP device. Passwords are not
+// written: .rdp files can only hold them encrypted for the Windows user
+// that saved them.
+func FromDevice(d device.Device) File {
+	var f File
+
+	address := d.IPAddress
+	if strings.Contains(address, ":") {
+		address = "[" + address + "]"
+	}
+	if d.Port != 0 {
+		address += ":" + strconv.Itoa(d.Port)
+	}
+	f.Set("full address", TypeString, address)
+
+	screenMode := screenModeWindowed
+	if d.IsFullScreen() {
+		screenMode = screenModeFullScreen
+	}
+	f.Set("screen mode id", TypeInteger, screenMode)
+	if width, height, found := strings.Cut(d.Screen, "x"); found && isInteger(width) && isInteger(height) {
+		f.Set("desktopwidth", TypeInteger, width)
+		f.Set("desktopheight", TypeInteger, height)
+	}
+
+	// Devices are validated, but a line break in a value would let it add
+	// any setting to the file, so they are dropped here as well
+	if username := stripControl(d.Username); username != "" {
+		f.Set("username", TypeString, username)
+	}
+
+	if gateway := stripControl(d.RDP.Gateway); gateway != "" {
+		f.Set("gatewayhostname", TypeString, gateway)
+		f.Set("gatewayusagemethod", TypeInteger, gatewayAlways)
+		f.Set("gatewayprofileusagemethod", TypeInteger, "1")
+	}
+
+	f.Set("redirectclipboard", TypeInteger, boolValue(d.RDP.RedirectClipboard))
+	if d.RDP.RedirectDrives {
+		f.Set("drivestoredirect", TypeString, "*")
+	}
+	f.Set("redirectprinters", TypeInteger, boolValue(d.RDP.RedirectPrinters))
+	f.Set("redirectsmartcards", TypeInteger, boolValue(d.RDP.RedirectSmartCards))
+
+	return f
+}
+
+func isInteger(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// stripControl removes control characters such as line breaks from s
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func boolValue(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,197 @@
+package rdpfile
+
+import (
+	"bytes"
+	"reflect"
+	"slices"
+	"spark-heimdall/internal/device"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+const windowsFile = "screen mode id:i:2\r\n" +
+	"desktopwidth:i:1920\r\n" +
+	"desktopheight:i:1080\r\n" +
+	"full address:s:[2001:db8::5]:3390\r\n" +
+	"username:s:alice\r\n" +
+	"domain:s:CORP\r\n" +
+	"gatewayhostname:s:gw.example.com\r\n" +
+	"gatewayusagemethod:i:1\r\n" +
+	"redirectclipboard:i:1\r\n" +
+	"redirectprinters:i:0\r\n" +
+	"drivestoredirect:s:*\r\n" +
+	"audiomode:i:0\r\n" +
+	"password 51:b:01000000D08C9DDF\r\n" +
+	"remoteapplicationprogram:s:||notepad\r\n"
+
+// utf16File encodes text the way Windows saves .rdp files
+func utf16File(text string) []byte {
+	data := []byte{0xff, 0xfe}
+	for _, unit := range utf16.Encode([]rune(text)) {
+		data = append(data, byte(unit), byte(unit>>8))
+	}
+	return data
+}
+
+func TestParseDevice(t *testing.T) {
+	for name, data := range map[string][]byte{"utf-8": []byte(windowsFile), "utf-16": utf16File(windowsFile)} {
+		f, err := Parse(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		d, unsupported, err := f.Device()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		want := device.Device{
+			Name:       "2001:db8::5",
+			IPAddress:  "2001:db8::5",
+			Protocol:   "rdp",
+			Port:       3390,
+			Username:   `CORP\alice`,
+			FullScreen: device.Bool(true),
+			Screen:     "1920x1080",
+			RDP: device.RDPOptions{
+				Gateway:           "gw.example.com",
+				RedirectClipboard: true,
+				RedirectDrives:    true,
+			},
+		}
+		if !reflect.DeepEqual(d, want) {
+			t.Errorf("%s: device = %+v, want %+v", name, d, want)
+		}
+		if want := []string{"password 51", "remoteapplicationprogram"}; !slices.Equal(unsupported, want) {
+			t.Errorf("%s: unsupported = %v, want %v", name, unsupported, want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, text := range map[string]string{
+		"empty":      "",
+		"no type":    "full address\r\n",
+		"no address": "username:s:alice\r\n",
+	} {
+		f, err := Parse(strings.NewReader(text))
+		if err == nil {
+			_, _, err = f.Device()
+		}
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	d := device.Device{
+		Name:      "server",
+		IPAddress: "server",
+		Protocol:  "rdp",
+		Username:  "bob",
+		Password:  "secret",
+		Screen:    "1280x720",
+		RDP:       device.RDPOptions{Gateway: "gw:443", RedirectPrinters: true, RedirectSmartCards: true},
+	}
+
+	var buf bytes.Buffer
+	if _, err := FromDevice(d).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Error("password was exported")
+	}
+
+	f, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, unsupported, err := f.Device()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Password = ""
+	if !reflect.DeepEqual(got, d) || len(unsupported) != 0 {
+		t.Errorf("round trip = %+v (unsupported %v), want %+v", got, unsupported, d)
+	}
+}
+
+func TestFromDeviceControlCharacters(t *testing.T) {
+	d := device.Device{
+		Name:      "server",
+		IPAddress: "server",
+		Protocol:  "rdp",
+		Username:  "bob\r\nalternate shell:s:cmd.exe",
+		Screen:    "wide x tall",
+		RDP:       device.RDPOptions{Gateway: "gw\nremoteapplicationprogram:s:calc"},
+	}
+
+	var buf bytes.Buffer
+	if _, err := FromDevice(d).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+	f, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alternate shell", "remoteapplicationprogram", "desktopwidth", "desktopheight"} {
+		if _, found := f.Get(name); found {
+			t.Errorf("%s was written:\n%s", name, text)
+		}
+	}
+	if username, _ := f.Get("username"); username != "bobalternate shell:s:cmd.exe" {
+		t.Errorf("username = %q", username)
+	}
+}
+
+func TestRoundTripIPv6(t *testing.T) {
+	for _, port := range []int{0, 3390} {
+		d := device.Device{Name: "v6", IPAddress: "fe80::1", Port: port, Protocol: "rdp", Username: "bob"}
+
+		var buf bytes.Buffer
+		if _, err := FromDevice(d).WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		f, err := Parse(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _, err := f.Device()
+		if err != nil {
+			t.Fatalf("port %d: %v", port, err)
+		}
+		if got.IPAddress != "fe80::1" || got.Port != port {
+			t.Errorf("port %d: round trip = %s port %d", port, got.IPAddress, got.Port)
+		}
+	}
+}
+
+func TestSplitAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		host    string
+		port    int
+	}{
+		{"server", "server", 0},
+		{"server:3390", "server", 3390},
+		{"2001:db8::5", "2001:db8::5", 0},
+		{"[2001:db8::5]", "2001:db8::5", 0},
+		{"[2001:db8::5]:3390", "2001:db8::5", 3390},
+	}
+	for _, test := range tests {
+		host, port, err := splitAddress(test.address)
+		if err != nil || host != test.host || port != test.port {
+			t.Errorf("splitAddress(%q) = %q, %d, %v", test.address, host, port, err)
+		}
+	}
+
+	for _, address := range []string{"", "server:rdp", "[2001:db8::5]:x", "[2001:db8::5"} {
+		if _, _, err := splitAddress(address); err == nil {
+			t.Errorf("splitAddress(%q) should fail", address)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package remmina
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// maxArchiveProfiles limits the number of profiles read from one archive
+const maxArchiveProfiles = 1000
+
+// ReadUpload imports an uploaded file, which is either a single profile or
+// a .zip, .tar, .tar.gz or .tgz archive of profiles
+func ReadUpload(name string, data []byte) ([]Entry, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return readZip(data)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		defer gz.Close()
+		return readTar(gz)
+	case strings.HasSuffix(lower, ".tar"):
+		return readTar(bytes.NewReader(data))
+	default:
+		return []Entry{importProfile(name, bytes.NewReader(data))}, nil
+	}
+}
+
+func readZip(data []byte) ([]Entry, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zip archive: %w", err)
+	}
+
+	var entries []Entry
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() || !strings.HasSuffix(file.Name, Extension) {
+			continue
+		}
+		if len(entries) == maxArchiveProfiles {
+			return nil, fmt.Errorf("archive contains more than %d profiles", maxArchiveProfiles)
+		}
+
+		f, err := file.Open()
+		if err != nil {
+			entries = append(entries, Entry{Source: file.Name, Error: err.Error()})
+			continue
+		}
+		entries = append(entries, importProfile(file.Name, f))
+		f.Close()
+	}
+	return entries, nil
+}
+
+func readTar(r io.Reader) ([]Entry, error) {
+	archive := tar.NewReader(r)
+
+	var entries []Entry
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar archive: %w", err)
+		}
+
+		if header.Typeflag != tar.TypeReg || !strings.HasSuffix(header.Name, Extension) {
+			continue
+		}
+		if len(entries) == maxArchiveProfiles {
+			return nil, fmt.Errorf("archive contains more than %d profiles", maxArchiveProfiles)
+		}
+		entries = append(entries, importProfile(path.Clean(header.Name), archive))
+	}
+}
@@ -0,0 +1,225 @@
+// Package remmina imports connection profiles from Remmina's ".remmina"
+// files.
+package remmina
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"spark-heimdall/internal/device"
+	"strconv"
+	"strings"
+)
+
+// Extension is the file extension of Remmina profiles
+const Extension = ".remmina"
+
+// Profile holds the keys of a profile's [remmina] section
+type Profile map[string]string
+
+// Remmina view modes that show the connection full screen
+const (
+	viewModeFullscreen         = "2"
+	viewModeScrolledFullscreen = "3"
+	viewModeViewportFullscreen = "4"
+)
+
+// resolutionModeCustom selects the resolution_width and resolution_height
+// of the profile rather than the client's resolution
+const resolutionModeCustom = "0"
+
+const maxProfileSize = 1 << 20
+
+// mapped lists the keys that are turned into device fields
+var mapped = []string{
+	"name", "protocol", "server", "username", "domain", "group",
+	"resolution_mode", "resolution_width", "resolution_height", "viewmode",
+}
+
+// ignored lists keys that only affect Remmina's own window or bookkeeping
+// and are not worth reporting
+var ignored = []string{
+	"colordepth", "disableclipboard", "disableencryption", "disablepasswordstoring",
+	"disableserverinput", "enable-autostart", "keymap", "labels", "last_success",
+	"notes_text", "precommand", "postcommand", "quality", "scale", "showcursor",
+	"toolbar_opacity", "viewonly", "window_height", "window_maximize", "window_width",
+	"ignore-tls-errors", "profile-lock",
+}
+
+// Parse reads the [remmina] section of a profile
+func Parse(r io.Reader) (Profile, error) {
+	profile := Profile{}
+	inSection := false
+
+	scanner := bufio.NewScanner(io.LimitReader(r, maxProfileSize))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxProfileSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			inSection = strings.EqualFold(line, "[remmina]")
+		case inSection:
+			key, value, found := strings.Cut(line, "=")
+			if found {
+				profile[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read profile: %w", err)
+	}
+
+	if len(profile) == 0 {
+		return nil, fmt.Errorf("no [remmina] section found")
+	}
+	return profile, nil
+}
+
+// Device converts the profile to a device and returns the keys that have a
+// value but could not be imported. Profiles for protocols other than VNC and
+// RDP are rejected.
+func (p Profile) Device() (device.Device, []string, error) {
+	var d device.Device
+
+	d.Protocol = strings.ToLower(p["protocol"])
+	if d.Protocol != "vnc" && d.Protocol != "rdp" {
+		return d, nil, fmt.Errorf("unsupported protocol %q", p["protocol"])
+	}
+
+	host, port, err := splitServer(p["server"])
+	if err != nil {
+		return d, nil, err
+	}
+	d.IPAddress = host
+	if port != device.DefaultPort(d.Protocol) {
+		d.Port = port
+	}
+
+	d.Name = p["name"]
+	if d.Name == "" {
+		d.Name = host
+	}
+
+	d.Username = p["username"]
+	if domain := p["domain"]; domain != "" && d.Username != "" {
+		d.Username = domain + `\` + d.Username
+	}
+
+	if group := p["group"]; group != "" {
+		d.Tags = []string{group}
+	}
+
+	switch p["viewmode"] {
+	case viewModeFullscreen, viewModeScrolledFullscreen, viewModeViewportFullscreen:
+		d.FullScreen = device.Bool(true)
+	}
+
+	width, height := p["resolution_width"], p["resolution_height"]
+	if mode := p["resolution_mode"]; (mode == "" || mode == resolutionModeCustom) && isSet(width) && isSet(height) {
+		d.Screen = width + "x" + height
+	}
+
+	return d, p.unsupported(), nil
+}
+
+// unsupported returns the sorted keys with a value that are neither mapped
+// nor ignored
+func (p Profile) unsupported() []string {
+	var keys []string
+	for key, value := range p {
+		if !isSet(value) || slices.Contains(mapped, key) || slices.Contains(ignored, key) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// isSet reports whether a profile value differs from Remmina's empty and
+// disabled defaults
+func isSet(value string) bool {
+	return value != "" && value != "0"
+}
+
+// splitServer splits Remmina's "host", "host:port" or "[v6 address]:port"
+func splitServer(server string) (string, int, error) {
+	if server == "" {
+		return "", 0, fmt.Errorf("no server set")
+	}
+
+	// A bare IPv6 address has more than one colon and no brackets
+	if !strings.HasPrefix(server, "[") && strings.Count(server, ":") != 1 {
+		return server, 0, nil
+	}
+
+	host, portText, err := net.SplitHostPort(server)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid server %q: %w", server, err)
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in server %q", server)
+	}
+	return host, port, nil
+}
+
+// Entry is the result of importing one profile
+type Entry struct {
+	// Source is the profile's file name, including its path in an archive
+	Source string        `json:"source"`
+	Device device.Device `json:"device"`
+	// Unsupported lists profile keys with a value that were not imported
+	Unsupported []string `json:"unsupported,omitempty"`
+	// Error is set if the profile could not be imported at all
+	Error string `json:"error,omitempty"`
+}
+
+// importProfile parses one profile into an entry
+func importProfile(source string, r io.Reader) Entry {
+	entry := Entry{Source: source}
+
+	profile, err := Parse(r)
+	if err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+
+	entry.Device, entry.Unsupported, err = profile.Device()
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	return entry
+}
+
+// ReadFile imports a single profile
+func ReadFile(path string) Entry {
+	f, err := os.Open(path)
+	if err != nil {
+		return Entry{Source: filepath.Base(path), Error: err.Error()}
+	}
+	defer f.Close()
+
+	return importProfile(filepath.Base(path), f)
+}
+
+// ReadDir imports every profile in dir, e.g. ~/.local/share/remmina
+func ReadDir(dir string) ([]Entry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile directory: %w", err)
+	}
+
+	var entries []Entry
+	for _, file := range files {
+		if file.Type().IsRegular() && strings.HasSuffix(file.Name(), Extension) {
+			entries = append(entries, ReadFile(filepath.Join(dir, file.Name())))
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,124 @@
+package remmina
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const rdpProfile = `[remmina]
+name=Office PC
+protocol=RDP
+server=office.example.com:3390
+username=alice
+domain=CORP
+group=Office
+password=c2VjcmV0
+resolution_mode=0
+resolution_width=1920
+resolution_height=1080
+viewmode=4
+window_width=640
+gateway_server=gw.example.com
+sound=off
+shareprinter=0
+`
+
+func TestProfileDevice(t *testing.T) {
+	profile, err := Parse(strings.NewReader(rdpProfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, unsupported, err := profile.Device()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.Name != "Office PC" || d.Protocol != "rdp" || d.IPAddress != "office.example.com" || d.Port != 3390 {
+		t.Errorf("device = %+v", d)
+	}
+	if d.Username != `CORP\alice` || !d.IsFullScreen() || d.Screen != "1920x1080" || !slices.Equal(d.Tags, []string{"Office"}) {
+		t.Errorf("device = %+v", d)
+	}
+	if want := []string{"gateway_server", "password", "sound"}; !slices.Equal(unsupported, want) {
+		t.Errorf("unsupported = %v, want %v", unsupported, want)
+	}
+}
+
+func TestProfileDeviceErrors(t *testing.T) {
+	for name, text := range map[string]string{
+		"no section":  "name=x\n",
+		"ssh":         "[remmina]\nprotocol=SSH\nserver=host\n",
+		"no server":   "[remmina]\nprotocol=VNC\n",
+		"bad port":    "[remmina]\nprotocol=VNC\nserver=host:abc\n",
+		"other group": "[other]\nprotocol=VNC\nserver=host\n",
+	} {
+		profile, err := Parse(strings.NewReader(text))
+		if err == nil {
+			_, _, err = profile.Device()
+		}
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSplitServer(t *testing.T) {
+	for server, want := range map[string]struct {
+		host string
+		port int
+	}{
+		"host":             {"host", 0},
+		"host:5901":        {"host", 5901},
+		"fe80::1":          {"fe80::1", 0},
+		"[fe80::1]:5902":   {"fe80::1", 5902},
+		"192.168.1.5:3389": {"192.168.1.5", 3389},
+	} {
+		host, port, err := splitServer(server)
+		if err != nil || host != want.host || port != want.port {
+			t.Errorf("splitServer(%q) = %q, %d, %v", server, host, port, err)
+		}
+	}
+}
+
+func TestReadUploadArchives(t *testing.T) {
+	vnc := "[remmina]\nprotocol=VNC\nserver=10.0.0.5:5900\nviewmode=1\n"
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	for name, text := range map[string]string{"remmina/a.remmina": rdpProfile, "remmina/b.remmina": vnc, "README": "x"} {
+		f, _ := zw.Create(name)
+		f.Write([]byte(text))
+	}
+	zw.Close()
+
+	var tarred bytes.Buffer
+	tw := tar.NewWriter(&tarred)
+	tw.WriteHeader(&tar.Header{Name: "b.remmina", Mode: 0o600, Size: int64(len(vnc)), Typeflag: tar.TypeReg})
+	tw.Write([]byte(vnc))
+	tw.Close()
+
+	for name, data := range map[string][]byte{"profiles.zip": zipped.Bytes(), "profiles.tar": tarred.Bytes(), "b.remmina": []byte(vnc)} {
+		entries, err := ReadUpload(name, data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		var vncEntry *Entry
+		for i := range entries {
+			if strings.HasSuffix(entries[i].Source, "b.remmina") {
+				vncEntry = &entries[i]
+			}
+		}
+		if vncEntry == nil || vncEntry.Error != "" || vncEntry.Device.IPAddress != "10.0.0.5" || vncEntry.Device.Port != 0 || vncEntry.Device.IsFullScreen() {
+			t.Errorf("%s: entries = %+v", name, entries)
+		}
+		if name == "profiles.zip" && len(entries) != 2 {
+			t.Errorf("%s: got %d entries, want 2", name, len(entries))
+		}
+	}
+}
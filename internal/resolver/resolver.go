@@ -0,0 +1,128 @@
+// Package resolver resolves device hostnames with a small cache, so probes
+// and connections made every few seconds don't hit DNS each time.
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default cache lifetimes for successful and failed lookups
+const (
+	DefaultTTL         = time.Minute
+	DefaultNegativeTTL = 10 * time.Second
+)
+
+// LookupFunc returns the addresses of a hostname
+type LookupFunc func(ctx context.Context, host string) ([]netip.Addr, error)
+
+// Resolver resolves hostnames and caches the results. It is safe for
+// concurrent use.
+type Resolver struct {
+	lookup      LookupFunc
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	lock  sync.Mutex
+	cache map[string]entry
+}
+
+type entry struct {
+	addr    netip.Addr
+	err     error
+	expires time.Time
+}
+
+// New returns a resolver using the system resolver
+func New() *Resolver {
+	return NewWithLookup(SystemLookup)
+}
+
+// NewWithLookup returns a resolver that uses lookup for hostnames
+func NewWithLookup(lookup LookupFunc) *Resolver {
+	return &Resolver{
+		lookup:      lookup,
+		ttl:         DefaultTTL,
+		negativeTTL: DefaultNegativeTTL,
+		cache:       make(map[string]entry),
+	}
+}
+
+// SystemLookup resolves host with the system resolver
+func SystemLookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+}
+
+// Resolve returns the address to connect to for host. IP addresses,
+// including IPv6 addresses with a zone, are returned unchanged.
+func (r *Resolver) Resolve(ctx context.Context, host string) (string, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.String(), nil
+	}
+
+	key := strings.ToLower(strings.TrimSuffix(host, "."))
+
+	r.lock.Lock()
+	cached, found := r.cache[key]
+	r.lock.Unlock()
+	if found && time.Now().Before(cached.expires) {
+		return formatResult(cached)
+	}
+
+	addrs, err := r.lookup(ctx, host)
+	result := entry{err: err, expires: time.Now().Add(r.negativeTTL)}
+	if err == nil && len(addrs) == 0 {
+		result.err = fmt.Errorf("no addresses found for %s", host)
+	} else if err == nil {
+		result.addr = addrs[0].Unmap()
+		result.expires = time.Now().Add(r.ttl)
+	}
+
+	// A cancelled lookup says nothing about the name
+	if ctx.Err() == nil {
+		r.lock.Lock()
+		r.cache[key] = result
+		r.lock.Unlock()
+	}
+
+	return formatResult(result)
+}
+
+func formatResult(e entry) (string, error) {
+	if e.err != nil {
+		return "", e.err
+	}
+	return e.addr.String(), nil
+}
+
+// Cached returns the cached address of host without doing a lookup. IP
+// addresses are returned unchanged.
+func (r *Resolver) Cached(host string) (string, bool) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.String(), true
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	cached, found := r.cache[strings.ToLower(strings.TrimSuffix(host, "."))]
+	if !found || cached.err != nil {
+		return "", false
+	}
+	return cached.addr.String(), true
+}
+
+// DialAddress resolves host and joins it with port, bracketing IPv6 addresses
+func (r *Resolver) DialAddress(ctx context.Context, host string, port int) (string, error) {
+	addr, err := r.Resolve(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(addr, strconv.Itoa(port)), nil
+}
@@ -0,0 +1,63 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestResolveLiteralsWithoutLookup(t *testing.T) {
+	r := NewWithLookup(func(ctx context.Context, host string) ([]netip.Addr, error) {
+		t.Fatalf("unexpected lookup of %s", host)
+		return nil, nil
+	})
+
+	for host, want := range map[string]string{
+		"10.0.0.1":     "10.0.0.1",
+		"fe80::1%eth0": "fe80::1%eth0",
+		"2001:db8::1":  "2001:db8::1",
+	} {
+		got, err := r.Resolve(context.Background(), host)
+		if err != nil || got != want {
+			t.Errorf("Resolve(%q) = %q, %v; want %q", host, got, err, want)
+		}
+	}
+
+	address, err := r.DialAddress(context.Background(), "fe80::1%eth0", 5900)
+	if err != nil || address != "[fe80::1%eth0]:5900" {
+		t.Errorf("DialAddress = %q, %v", address, err)
+	}
+}
+
+func TestResolveCachesResults(t *testing.T) {
+	lookups := 0
+	r := NewWithLookup(func(ctx context.Context, host string) ([]netip.Addr, error) {
+		lookups++
+		if host == "missing.example" {
+			return nil, errors.New("no such host")
+		}
+		return []netip.Addr{netip.MustParseAddr("::ffff:192.168.1.5")}, nil
+	})
+
+	if _, found := r.Cached("desk.example"); found {
+		t.Fatal("nothing should be cached before the first lookup")
+	}
+
+	for i := 0; i < 3; i++ {
+		got, err := r.Resolve(context.Background(), "Desk.Example.")
+		if err != nil || got != "192.168.1.5" {
+			t.Fatalf("Resolve = %q, %v", got, err)
+		}
+		if _, err := r.Resolve(context.Background(), "missing.example"); err == nil {
+			t.Fatal("expected lookup error")
+		}
+	}
+	if lookups != 2 {
+		t.Fatalf("expected 2 lookups, got %d", lookups)
+	}
+
+	if address, found := r.Cached("desk.example"); !found || address != "192.168.1.5" {
+		t.Fatalf("Cached = %q, %v", address, found)
+	}
+}
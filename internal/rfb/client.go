@@ -0,0 +1,443 @@
+package rfb
+
+import (
+	"bufio"
+	"crypto/des"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// Security types
+const (
+	securityNone    = 1
+	securityVncAuth = 2
+)
+
+// Encodings requested from the server
+const (
+	encodingRaw      = 0
+	encodingCopyRect = 1
+)
+
+// Server to client message types
+const (
+	msgFramebufferUpdate   = 0
+	msgSetColourMapEntries = 1
+	msgBell                = 2
+	msgServerCutText       = 3
+)
+
+// obfuscationKey is the fixed DES key vncpasswd uses to store passwords,
+// already bit-reversed for use with crypto/des
+var obfuscationKey = []byte{0xe8, 0x4a, 0xd6, 0x60, 0xc4, 0x72, 0x1a, 0xe0}
+
+var ErrAuthFailed = errors.New("rfb: authentication failed")
+
+// Client is a minimal RFB (VNC) client that can grab framebuffer snapshots
+type Client struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	minor  int
+	Width  int
+	Height int
+	Name   string
+}
+
+// Dial connects to address, performs the handshake and negotiates a 32-bit
+// true colour pixel format. password is used if the server requires VNC
+// authentication.
+func Dial(address, password string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	c := &Client{conn: conn, r: bufio.NewReaderSize(conn, 64*1024)}
+	if err := c.handshake(password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Close closes the connection to the server
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Capture requests a full framebuffer update and returns the decoded image
+func (c *Client) Capture(timeout time.Duration) (*image.RGBA, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+
+	img := image.NewRGBA(image.Rect(0, 0, c.Width, c.Height))
+
+	request := make([]byte, 10)
+	request[0] = 3 // FramebufferUpdateRequest
+	request[1] = 0 // non-incremental
+	binary.BigEndian.PutUint16(request[6:], uint16(c.Width))
+	binary.BigEndian.PutUint16(request[8:], uint16(c.Height))
+	if _, err := c.conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	for {
+		msgType, err := c.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch msgType {
+		case msgFramebufferUpdate:
+			if err := c.readFramebufferUpdate(img); err != nil {
+				return nil, err
+			}
+			return img, nil
+		case msgSetColourMapEntries:
+			var header [5]byte
+			if _, err := io.ReadFull(c.r, header[:]); err != nil {
+				return nil, err
+			}
+			count := int(binary.BigEndian.Uint16(header[3:]))
+			if _, err := c.r.Discard(count * 6); err != nil {
+				return nil, err
+			}
+		case msgBell:
+		case msgServerCutText:
+			var header [7]byte
+			if _, err := io.ReadFull(c.r, header[:]); err != nil {
+				return nil, err
+			}
+			length := int(binary.BigEndian.Uint32(header[3:]))
+			if _, err := c.r.Discard(length); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("rfb: unexpected message type %d", msgType)
+		}
+	}
+}
+
+func (c *Client) handshake(password string) error {
+	var version [12]byte
+	if _, err := io.ReadFull(c.r, version[:]); err != nil {
+		return fmt.Errorf("rfb: failed to read protocol version: %w", err)
+	}
+
+	var major, minor int
+	if _, err := fmt.Sscanf(string(version[:]), "RFB %03d.%03d\n", &major, &minor); err != nil || major != 3 {
+		return fmt.Errorf("rfb: unsupported protocol version %q", version[:11])
+	}
+
+	// Anything newer than 3.8 must accept 3.8; unknown minors fall back to 3.3
+	switch {
+	case minor >= 8:
+		minor = 8
+	case minor == 7:
+	default:
+		minor = 3
+	}
+	c.minor = minor
+
+	if _, err := fmt.Fprintf(c.conn, "RFB 003.%03d\n", minor); err != nil {
+		return err
+	}
+
+	securityType, err := c.negotiateSecurity(password)
+	if err != nil {
+		return err
+	}
+
+	if securityType == securityVncAuth {
+		if err := c.vncAuth(password); err != nil {
+			return err
+		}
+	}
+
+	// 3.8 reports a result for every security type, older versions only for VNC auth
+	if securityType == securityVncAuth || c.minor >= 8 {
+		if err := c.readSecurityResult(); err != nil {
+			return err
+		}
+	}
+
+	// ClientInit: request a shared session so existing viewers stay connected
+	if _, err := c.conn.Write([]byte{1}); err != nil {
+		return err
+	}
+
+	var serverInit [24]byte
+	if _, err := io.ReadFull(c.r, serverInit[:]); err != nil {
+		return fmt.Errorf("rfb: failed to read server init: %w", err)
+	}
+	c.Width = int(binary.BigEndian.Uint16(serverInit[0:]))
+	c.Height = int(binary.BigEndian.Uint16(serverInit[2:]))
+	nameLength := binary.BigEndian.Uint32(serverInit[20:])
+	if nameLength > 4096 {
+		return errors.New("rfb: desktop name too long")
+	}
+	name := make([]byte, nameLength)
+	if _, err := io.ReadFull(c.r, name); err != nil {
+		return err
+	}
+	c.Name = string(name)
+
+	return c.setup()
+}
+
+func (c *Client) negotiateSecurity(password string) (int, error) {
+	if c.minor == 3 {
+		var buf [4]byte
+		if _, err := io.ReadFull(c.r, buf[:]); err != nil {
+			return 0, err
+		}
+		securityType := int(binary.BigEndian.Uint32(buf[:]))
+		if securityType == 0 {
+			return 0, c.readFailureReason()
+		}
+		if securityType != securityNone && securityType != securityVncAuth {
+			return 0, fmt.Errorf("rfb: unsupported security type %d", securityType)
+		}
+		return securityType, nil
+	}
+
+	count, err := c.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, c.readFailureReason()
+	}
+	types := make([]byte, count)
+	if _, err := io.ReadFull(c.r, types); err != nil {
+		return 0, err
+	}
+
+	selected := 0
+	for _, t := range types {
+		if t == securityNone {
+			selected = securityNone
+			break
+		}
+		if t == securityVncAuth {
+			selected = securityVncAuth
+		}
+	}
+	if selected == 0 {
+		return 0, fmt.Errorf("rfb: no supported security type offered (%v)", types)
+	}
+
+	if _, err := c.conn.Write([]byte{byte(selected)}); err != nil {
+		return 0, err
+	}
+	return selected, nil
+}
+
+func (c *Client) vncAuth(password string) error {
+	var challenge [16]byte
+	if _, err := io.ReadFull(c.r, challenge[:]); err != nil {
+		return err
+	}
+
+	block, err := des.NewCipher(passwordKey(password))
+	if err != nil {
+		return err
+	}
+	var response [16]byte
+	block.Encrypt(response[0:8], challenge[0:8])
+	block.Encrypt(response[8:16], challenge[8:16])
+
+	_, err = c.conn.Write(response[:])
+	return err
+}
+
+func (c *Client) readSecurityResult() error {
+	var buf [4]byte
+	if _, err := io.ReadFull(c.r, buf[:]); err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint32(buf[:]) == 0 {
+		return nil
+	}
+	if c.minor >= 8 {
+		if reason := c.readFailureReason(); reason != nil {
+			return fmt.Errorf("%w: %v", ErrAuthFailed, reason)
+		}
+	}
+	return ErrAuthFailed
+}
+
+func (c *Client) readFailureReason() error {
+	var buf [4]byte
+	if _, err := io.ReadFull(c.r, buf[:]); err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint32(buf[:])
+	if length > 4096 {
+		return errors.New("rfb: connection failed")
+	}
+	reason := make([]byte, length)
+	if _, err := io.ReadFull(c.r, reason); err != nil {
+		return err
+	}
+	return fmt.Errorf("rfb: server refused connection: %s", reason)
+}
+
+// setup asks for 32bpp little-endian true colour and Raw/CopyRect encodings
+func (c *Client) setup() error {
+	pixelFormat := []byte{
+		0, 0, 0, 0, // SetPixelFormat + padding
+		32, 24, 0, 1, // bpp, depth, big-endian, true-colour
+		0, 255, 0, 255, 0, 255, // red, green, blue max
+		16, 8, 0, // red, green, blue shift
+		0, 0, 0, // padding
+	}
+	if _, err := c.conn.Write(pixelFormat); err != nil {
+		return err
+	}
+
+	encodings := []int32{encodingCopyRect, encodingRaw}
+	msg := make([]byte, 4, 4+4*len(encodings))
+	msg[0] = 2 // SetEncodings
+	binary.BigEndian.PutUint16(msg[2:], uint16(len(encodings)))
+	for _, e := range encodings {
+		msg = binary.BigEndian.AppendUint32(msg, uint32(e))
+	}
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+func (c *Client) readFramebufferUpdate(img *image.RGBA) error {
+	var header [3]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return err
+	}
+	rects := int(binary.BigEndian.Uint16(header[1:]))
+
+	for i := 0; i < rects; i++ {
+		var rect [12]byte
+		if _, err := io.ReadFull(c.r, rect[:]); err != nil {
+			return err
+		}
+		x := int(binary.BigEndian.Uint16(rect[0:]))
+		y := int(binary.BigEndian.Uint16(rect[2:]))
+		w := int(binary.BigEndian.Uint16(rect[4:]))
+		h := int(binary.BigEndian.Uint16(rect[6:]))
+		encoding := int32(binary.BigEndian.Uint32(rect[8:]))
+
+		switch encoding {
+		case encodingRaw:
+			if err := c.readRaw(img, x, y, w, h); err != nil {
+				return err
+			}
+		case encodingCopyRect:
+			var src [4]byte
+			if _, err := io.ReadFull(c.r, src[:]); err != nil {
+				return err
+			}
+			srcX := int(binary.BigEndian.Uint16(src[0:]))
+			srcY := int(binary.BigEndian.Uint16(src[2:]))
+			copyRect(img, srcX, srcY, x, y, w, h)
+		default:
+			return fmt.Errorf("rfb: unsupported encoding %d", encoding)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) readRaw(img *image.RGBA, x, y, w, h int) error {
+	row := make([]byte, w*4)
+	for dy := 0; dy < h; dy++ {
+		if _, err := io.ReadFull(c.r, row); err != nil {
+			return err
+		}
+		if y+dy >= c.Height {
+			continue
+		}
+		for dx := 0; dx < w; dx++ {
+			if x+dx >= c.Width {
+				break
+			}
+			// Little-endian 0x00RRGGBB arrives as B, G, R, X
+			offset := img.PixOffset(x+dx, y+dy)
+			img.Pix[offset+0] = row[dx*4+2]
+			img.Pix[offset+1] = row[dx*4+1]
+			img.Pix[offset+2] = row[dx*4+0]
+			img.Pix[offset+3] = 0xFF
+		}
+	}
+	return nil
+}
+
+func copyRect(img *image.RGBA, srcX, srcY, x, y, w, h int) {
+	src := image.Rect(srcX, srcY, srcX+w, srcY+h).Intersect(img.Rect)
+	tmp := image.NewRGBA(src)
+	for row := src.Min.Y; row < src.Max.Y; row++ {
+		copy(tmp.Pix[tmp.PixOffset(src.Min.X, row):], img.Pix[img.PixOffset(src.Min.X, row):img.PixOffset(src.Max.X, row)])
+	}
+	for row := src.Min.Y; row < src.Max.Y; row++ {
+		dstY := y + row - srcY
+		if dstY >= img.Rect.Max.Y {
+			break
+		}
+		dstX := x + src.Min.X - srcX
+		n := min(src.Dx(), img.Rect.Max.X-dstX)
+		if n <= 0 {
+			continue
+		}
+		copy(img.Pix[img.PixOffset(dstX, dstY):], tmp.Pix[tmp.PixOffset(src.Min.X, row):tmp.PixOffset(src.Min.X, row)+n*4])
+	}
+}
+
+// passwordKey converts a VNC password into a DES key. VNC uses at most
+// eight characters and, for historical reasons, mirrors the bits of each byte.
+func passwordKey(password string) []byte {
+	key := make([]byte, 8)
+	copy(key, password)
+	for i, b := range key {
+		key[i] = reverseBits(b)
+	}
+	return key
+}
+
+func reverseBits(b byte) byte {
+	var r byte
+	for i := 0; i < 8; i++ {
+		r = r<<1 | b&1
+		b >>= 1
+	}
+	return r
+}
+
+// ReadPasswordFile decodes a password file written by vncpasswd
+func ReadPasswordFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < 8 {
+		return "", fmt.Errorf("rfb: password file %s is too short", path)
+	}
+
+	block, err := des.NewCipher(obfuscationKey)
+	if err != nil {
+		return "", err
+	}
+	plain := make([]byte, 8)
+	block.Decrypt(plain, data[:8])
+
+	for i, b := range plain {
+		if b == 0 {
+			return string(plain[:i]), nil
+		}
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,273 @@
+package rfb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"image"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// pipe returns a client and the server end of an in-memory connection
+func pipe(t *testing.T) (*Client, net.Conn) {
+	t.Helper()
+
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	client.SetDeadline(deadline)
+	server.SetDeadline(deadline)
+
+	return &Client{conn: client, r: bufio.NewReader(client)}, server
+}
+
+// expect reads len(want) bytes from the client and compares them
+func expect(t *testing.T, server net.Conn, what string, want []byte) {
+	t.Helper()
+
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Fatalf("read %s: %v", what, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("%s = %x, want %x", what, got, want)
+	}
+}
+
+func send(t *testing.T, server net.Conn, data ...[]byte) {
+	t.Helper()
+
+	for _, d := range data {
+		if _, err := server.Write(d); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+}
+
+func uint32Bytes(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// serverInit encodes a ServerInit message for a width x height desktop
+func serverInit(width, height int, name string) []byte {
+	msg := binary.BigEndian.AppendUint16(nil, uint16(width))
+	msg = binary.BigEndian.AppendUint16(msg, uint16(height))
+	msg = append(msg, make([]byte, 16)...) // pixel format, replaced by the client
+	msg = binary.BigEndian.AppendUint32(msg, uint32(len(name)))
+	return append(msg, name...)
+}
+
+// expectSetup reads the SetPixelFormat and SetEncodings messages
+func expectSetup(t *testing.T, server net.Conn) {
+	t.Helper()
+
+	setup := make([]byte, 20+4+8)
+	if _, err := io.ReadFull(server, setup); err != nil {
+		t.Fatalf("read setup: %v", err)
+	}
+	if setup[0] != 0 || setup[4] != 32 || setup[20] != 2 {
+		t.Errorf("setup messages = %x", setup)
+	}
+}
+
+func TestHandshakeVncAuth(t *testing.T) {
+	c, server := pipe(t)
+	done := make(chan error, 1)
+	go func() { done <- c.handshake("secret") }()
+
+	send(t, server, []byte("RFB 003.008\n"))
+	expect(t, server, "version", []byte("RFB 003.008\n"))
+
+	// Tight (16) isn't supported, so VNC authentication is picked
+	send(t, server, []byte{2, 16, securityVncAuth})
+	expect(t, server, "security type", []byte{securityVncAuth})
+
+	// Response to this challenge for "secret", computed with openssl des-ecb
+	send(t, server, []byte("0123456789abcdef"))
+	expect(t, server, "challenge response", mustHex("752440ee2bfcc2a0d9013fd20371e23b"))
+
+	send(t, server, uint32Bytes(0))
+	expect(t, server, "client init", []byte{1})
+
+	send(t, server, serverInit(640, 480, "desk"))
+	expectSetup(t, server)
+
+	if err := <-done; err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	if c.Width != 640 || c.Height != 480 || c.Name != "desk" || c.minor != 8 {
+		t.Errorf("client = %dx%d %q, minor %d", c.Width, c.Height, c.Name, c.minor)
+	}
+}
+
+func TestHandshakeAuthFailed(t *testing.T) {
+	c, server := pipe(t)
+	done := make(chan error, 1)
+	go func() { done <- c.handshake("wrong") }()
+
+	send(t, server, []byte("RFB 003.008\n"))
+	expect(t, server, "version", []byte("RFB 003.008\n"))
+	send(t, server, []byte{1, securityVncAuth})
+	expect(t, server, "security type", []byte{securityVncAuth})
+	send(t, server, make([]byte, 16))
+	if _, err := io.ReadFull(server, make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+
+	reason := "bad password"
+	send(t, server, uint32Bytes(1), uint32Bytes(uint32(len(reason))), []byte(reason))
+
+	if err := <-done; !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("err = %v, want ErrAuthFailed", err)
+	}
+}
+
+func TestHandshakeVersion33(t *testing.T) {
+	c, server := pipe(t)
+	done := make(chan error, 1)
+	go func() { done <- c.handshake("") }()
+
+	// 3.3 servers pick the security type and send no result for None
+	send(t, server, []byte("RFB 003.003\n"))
+	expect(t, server, "version", []byte("RFB 003.003\n"))
+	send(t, server, uint32Bytes(securityNone))
+	expect(t, server, "client init", []byte{1})
+
+	send(t, server, serverInit(800, 600, ""))
+	expectSetup(t, server)
+
+	if err := <-done; err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	if c.minor != 3 || c.Width != 800 {
+		t.Errorf("minor = %d, width = %d", c.minor, c.Width)
+	}
+}
+
+func TestHandshakeRejectsOtherProtocols(t *testing.T) {
+	c, server := pipe(t)
+	done := make(chan error, 1)
+	go func() { done <- c.handshake("") }()
+
+	send(t, server, []byte("SSH-2.0-Ope"))
+	send(t, server, []byte("n"))
+
+	if err := <-done; err == nil {
+		t.Error("expected an error for a non-RFB server")
+	}
+}
+
+// pixel encodes a colour the way the client's pixel format expects it
+func pixel(r, g, b byte) []byte {
+	return []byte{b, g, r, 0}
+}
+
+func rectHeader(x, y, w, h int, encoding int32) []byte {
+	header := binary.BigEndian.AppendUint16(nil, uint16(x))
+	header = binary.BigEndian.AppendUint16(header, uint16(y))
+	header = binary.BigEndian.AppendUint16(header, uint16(w))
+	header = binary.BigEndian.AppendUint16(header, uint16(h))
+	return binary.BigEndian.AppendUint32(header, uint32(encoding))
+}
+
+func TestCapture(t *testing.T) {
+	c, server := pipe(t)
+	c.Width, c.Height = 4, 2
+
+	type captured struct {
+		img *image.RGBA
+		err error
+	}
+	done := make(chan captured, 1)
+	go func() {
+		img, err := c.Capture(5 * time.Second)
+		done <- captured{img, err}
+	}()
+
+	expect(t, server, "update request", []byte{3, 0, 0, 0, 0, 0, 0, 4, 0, 2})
+
+	// A bell before the update is skipped
+	send(t, server, []byte{msgBell})
+
+	// A Raw rectangle fills the screen, then a CopyRect shifts the first
+	// three pixels of the top row one to the right, overlapping its source
+	send(t, server, []byte{msgFramebufferUpdate, 0, 0, 2})
+	send(t, server, rectHeader(0, 0, 4, 2, encodingRaw))
+	for i := range 8 {
+		send(t, server, pixel(byte(10*i), byte(i), 0xFF-byte(i)))
+	}
+	send(t, server, rectHeader(1, 0, 3, 1, encodingCopyRect), []byte{0, 0, 0, 0})
+
+	result := <-done
+	if result.err != nil {
+		t.Fatalf("capture: %v", result.err)
+	}
+
+	want := []int{0, 0, 1, 2, 4, 5, 6, 7}
+	for i, src := range want {
+		x, y := i%4, i/4
+		offset := result.img.PixOffset(x, y)
+		got := result.img.Pix[offset : offset+4]
+		if !bytes.Equal(got, []byte{byte(10 * src), byte(src), 0xFF - byte(src), 0xFF}) {
+			t.Errorf("pixel (%d, %d) = %v, want source pixel %d", x, y, got, src)
+		}
+	}
+}
+
+func TestCopyRectOverlapDown(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1, 3))
+	for y := range 3 {
+		img.Pix[img.PixOffset(0, y)] = byte(y + 1)
+	}
+
+	copyRect(img, 0, 0, 0, 1, 1, 2)
+
+	for y, want := range []byte{1, 1, 2} {
+		if got := img.Pix[img.PixOffset(0, y)]; got != want {
+			t.Errorf("row %d = %d, want %d", y, got, want)
+		}
+	}
+}
+
+func TestReadPasswordFile(t *testing.T) {
+	dir := t.TempDir()
+
+	// vncpasswd output for "password"
+	path := filepath.Join(dir, "passwd")
+	if err := os.WriteFile(path, mustHex("dbd83cfd727a1458"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if password, err := ReadPasswordFile(path); err != nil || password != "password" {
+		t.Errorf("ReadPasswordFile = %q, %v", password, err)
+	}
+
+	short := filepath.Join(dir, "short")
+	if err := os.WriteFile(short, []byte{1, 2, 3}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadPasswordFile(short); err == nil {
+		t.Error("expected an error for a short password file")
+	}
+
+	if _, err := ReadPasswordFile(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("err = %v, want ErrNotExist", err)
+	}
+}
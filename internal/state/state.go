@@ -0,0 +1,168 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// State is runtime information that survives restarts but does not belong
+// in the user's configuration file
+type State struct {
+	// ActiveDevices are the IDs of devices with a running session
+	ActiveDevices []string  `json:"active_devices"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	// Usage records how each device has been used, keyed by device ID
+	Usage map[string]Usage `json:"usage,omitempty"`
+}
+
+// Usage describes how a device has been used
+type Usage struct {
+	LastConnected time.Time `json:"last_connected"`
+	ConnectCount  int       `json:"connect_count"`
+}
+
+// Store persists State to a JSON file. Writes happen immediately; a failed
+// write is retried by the next change or by Flush.
+type Store struct {
+	path  string
+	lock  sync.Mutex
+	state State
+	dirty bool
+}
+
+// New returns an empty store that will be written to path
+func New(path string) *Store {
+	return &Store{path: path}
+}
+
+// Load reads the state file at path. A missing file yields an empty state.
+func Load(path string) (*Store, error) {
+	s := New(path)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+
+	return s, nil
+}
+
+// Exists reports whether any state has been recorded
+func (s *Store) Exists() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return !s.state.UpdatedAt.IsZero()
+}
+
+// ActiveDevices returns the devices that had a running session
+func (s *Store) ActiveDevices() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return slices.Clone(s.state.ActiveDevices)
+}
+
+// SetActiveDevices records the devices that currently have a running session
+func (s *Store) SetActiveDevices(ids []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.state.ActiveDevices = append([]string{}, ids...)
+	s.state.UpdatedAt = time.Now()
+	s.dirty = true
+
+	return s.write()
+}
+
+// Usage returns the recorded usage of a device
+func (s *Store) Usage(id string) Usage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.state.Usage[id]
+}
+
+// RecordConnect records that a session to the device was started
+func (s *Store) RecordConnect(id string, at time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.state.Usage == nil {
+		s.state.Usage = make(map[string]Usage)
+	}
+	usage := s.state.Usage[id]
+	usage.LastConnected = at
+	usage.ConnectCount++
+	s.state.Usage[id] = usage
+	s.dirty = true
+
+	return s.write()
+}
+
+// Forget removes the usage of a deleted device, so a new device that is
+// given the same ID starts without history
+func (s *Store) Forget(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, found := s.state.Usage[id]; !found {
+		return nil
+	}
+	delete(s.state.Usage, id)
+	s.dirty = true
+
+	return s.write()
+}
+
+// Flush writes the state if an earlier write failed
+func (s *Store) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+	return s.write()
+}
+
+// write replaces the state file atomically so a crash never leaves it truncated
+func (s *Store) write() error {
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".heimdall-state-*")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	s.dirty = false
+	return nil
+}
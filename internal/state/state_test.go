@@ -0,0 +1,118 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLoadMissing(t *testing.T) {
+	s, err := Load(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Exists() || len(s.ActiveDevices()) != 0 {
+		t.Errorf("missing file should give an empty state")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("expected an error for an invalid state file")
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "state.json")
+	s := New(path)
+
+	if err := s.SetActiveDevices([]string{"pc1", "pc2"}); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+	for _, id := range []string{"pc1", "pc1", "pc2"} {
+		if err := s.RecordConnect(id, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Forget("pc2"); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Exists() || !slices.Equal(loaded.ActiveDevices(), []string{"pc1", "pc2"}) {
+		t.Errorf("active devices = %v", loaded.ActiveDevices())
+	}
+	if usage := loaded.Usage("pc1"); usage.ConnectCount != 2 || !usage.LastConnected.Equal(at) {
+		t.Errorf("usage of pc1 = %+v", usage)
+	}
+	if usage := loaded.Usage("pc2"); usage.ConnectCount != 0 {
+		t.Errorf("usage of forgotten pc2 = %+v", usage)
+	}
+}
+
+func TestActiveDevicesIsACopy(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "state.json"))
+	ids := []string{"pc1"}
+	if err := s.SetActiveDevices(ids); err != nil {
+		t.Fatal(err)
+	}
+
+	ids[0] = "changed"
+	s.ActiveDevices()[0] = "changed"
+	if active := s.ActiveDevices(); active[0] != "pc1" {
+		t.Errorf("active devices = %v, want [pc1]", active)
+	}
+}
+
+func TestFlushRetriesFailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	blocked := filepath.Join(dir, "blocked")
+	if err := os.WriteFile(blocked, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The state directory is a file, so writes fail
+	s := New(filepath.Join(blocked, "state.json"))
+	if err := s.SetActiveDevices([]string{"pc1"}); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+	if err := s.Flush(); err == nil {
+		t.Error("expected Flush to fail while the directory is blocked")
+	}
+
+	if err := os.Remove(blocked); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	loaded, err := Load(filepath.Join(blocked, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(loaded.ActiveDevices(), []string{"pc1"}) {
+		t.Errorf("active devices after Flush = %v", loaded.ActiveDevices())
+	}
+
+	// Nothing is written when the state is saved
+	if err := os.Remove(filepath.Join(blocked, "state.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(blocked, "state.json")); !os.IsNotExist(err) {
+		t.Error("Flush should not write a clean state")
+	}
+}
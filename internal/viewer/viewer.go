@@ -0,0 +1,131 @@
+package viewer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Viewer profiles determine how connection arguments are formatted
+const (
+	ProfileUnknown  = ""
+	ProfileTigerVNC = "tigervnc"
+	ProfileRealVNC  = "realvnc"
+	ProfileTightVNC = "tightvnc"
+	ProfileFreeRDP  = "freerdp"
+	ProfileRdesktop = "rdesktop"
+)
+
+// rdpCandidates are tried in order when no RDP viewer is configured
+var rdpCandidates = []string{"xfreerdp3", "xfreerdp", "sdl-freerdp3", "sdl-freerdp", "wlfreerdp", "rdesktop"}
+
+// versionFlags are tried in order until one produces a version number
+var versionFlags = []string{"--version", "-version", "/version", "-h"}
+
+var versionPattern = regexp.MustCompile(`\d+\.\d+(\.\d+)*`)
+
+const probeTimeout = 3 * time.Second
+
+// Info describes a resolved viewer executable
+type Info struct {
+	Configured string `json:"configured"`
+	Found      bool   `json:"found"`
+	Path       string `json:"path,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Profile    string `json:"profile,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Check returns an error if name cannot be resolved to an executable
+func Check(name string) error {
+	if name == "" {
+		return errors.New("no viewer configured")
+	}
+	if _, err := exec.LookPath(name); err != nil {
+		return fmt.Errorf("viewer %q not found: %w", name, err)
+	}
+	return nil
+}
+
+// DetectRDP returns the first known RDP client found on PATH, or "" if none
+func DetectRDP() string {
+	for _, candidate := range rdpCandidates {
+		if _, err := exec.LookPath(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// Inspect resolves name via PATH and probes its version and profile
+func Inspect(name string) Info {
+	info := Info{Configured: name}
+	if name == "" {
+		info.Error = "no viewer configured"
+		return info
+	}
+
+	path, err := exec.LookPath(name)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.Found = true
+	info.Path = path
+
+	output := probeVersion(path)
+	if match := versionPattern.FindString(output); match != "" {
+		info.Version = match
+	}
+	info.Profile = detectProfile(path, output)
+
+	return info
+}
+
+// probeVersion runs the viewer with common version flags and returns the
+// first output that contains a version number
+func probeVersion(path string) string {
+	for _, flag := range versionFlags {
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		var out bytes.Buffer
+		cmd := exec.CommandContext(ctx, path, flag)
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		// Don't wait on children that inherited the output pipes
+		cmd.WaitDelay = time.Second
+		// Most viewers exit non-zero for version/help flags, only the output matters
+		_ = cmd.Run()
+		cancel()
+
+		if versionPattern.Match(out.Bytes()) {
+			return out.String()
+		}
+	}
+	return ""
+}
+
+func detectProfile(path, output string) string {
+	base := strings.ToLower(filepath.Base(path))
+	lower := strings.ToLower(output)
+
+	switch {
+	case strings.Contains(base, "freerdp") || strings.Contains(lower, "freerdp"):
+		return ProfileFreeRDP
+	case strings.Contains(base, "rdesktop") || strings.Contains(lower, "rdesktop"):
+		return ProfileRdesktop
+	case strings.Contains(lower, "tigervnc"):
+		return ProfileTigerVNC
+	case strings.Contains(lower, "realvnc") || strings.Contains(lower, "vnc(r) viewer"):
+		return ProfileRealVNC
+	case strings.Contains(lower, "tightvnc"):
+		return ProfileTightVNC
+	default:
+		return ProfileUnknown
+	}
+}
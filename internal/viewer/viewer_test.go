@@ -0,0 +1,74 @@
+package viewer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeViewer writes an executable that prints output and returns its
+// directory
+func fakeViewer(t *testing.T, name, output string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho '" + output + "'\n"
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestDetectProfile(t *testing.T) {
+	tests := []struct {
+		path, output, want string
+	}{
+		{"/usr/bin/xfreerdp", "This is FreeRDP version 2.11.5", ProfileFreeRDP},
+		{"/usr/bin/sdl-freerdp3", "", ProfileFreeRDP},
+		{"/usr/bin/rdesktop", "rdesktop: A Remote Desktop Protocol client. Version 1.9.0", ProfileRdesktop},
+		{"/usr/bin/vncviewer", "TigerVNC Viewer 64-bit v1.13.1", ProfileTigerVNC},
+		{"/usr/bin/vncviewer", "VNC(R) Viewer 7.5.1 (r50075) x64", ProfileRealVNC},
+		{"/usr/bin/vncviewer", "TightVNC Viewer version 1.3.10", ProfileTightVNC},
+		{"/usr/bin/remmina", "remmina 1.4.27", ProfileUnknown},
+	}
+	for _, test := range tests {
+		if got := detectProfile(test.path, test.output); got != test.want {
+			t.Errorf("detectProfile(%q, %q) = %q, want %q", test.path, test.output, got, test.want)
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	dir := fakeViewer(t, "vncviewer", "TigerVNC Viewer 64-bit v1.13.1")
+	t.Setenv("PATH", dir)
+
+	info := Inspect("vncviewer")
+	if !info.Found || info.Path != filepath.Join(dir, "vncviewer") || info.Version != "1.13.1" || info.Profile != ProfileTigerVNC {
+		t.Errorf("Inspect = %+v", info)
+	}
+
+	if info := Inspect("missing-viewer"); info.Found || info.Error == "" {
+		t.Errorf("Inspect(missing) = %+v", info)
+	}
+	if info := Inspect(""); info.Found || info.Error != "no viewer configured" {
+		t.Errorf("Inspect(\"\") = %+v", info)
+	}
+}
+
+func TestDetectRDP(t *testing.T) {
+	// xfreerdp is preferred over rdesktop
+	dir := fakeViewer(t, "rdesktop", "")
+	if err := os.WriteFile(filepath.Join(dir, "xfreerdp"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	if got := DetectRDP(); got != "xfreerdp" {
+		t.Errorf("DetectRDP = %q", got)
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	if got := DetectRDP(); got != "" {
+		t.Errorf("DetectRDP without viewers = %q", got)
+	}
+}
@@ -0,0 +1,261 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// result is what the server side of a test connection read
+type result struct {
+	opcode int
+	data   []byte
+	err    error
+}
+
+// dial upgrades a connection to a test server that reads one message and
+// returns the raw client connection and the server's result
+func dial(t *testing.T, header http.Header) (net.Conn, *bufio.Reader, *http.Response, <-chan result) {
+	t.Helper()
+
+	results := make(chan result, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := Upgrade(w, r, []string{"binary", "base64"})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			results <- result{err: err}
+			return
+		}
+		defer ws.Close()
+		opcode, data, err := ws.ReadMessage()
+		results <- result{opcode, data, err}
+	}))
+	t.Cleanup(server.Close)
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, reader, resp, results
+}
+
+// clientFrame encodes a client frame, masked unless told otherwise
+func clientFrame(fin bool, opcode int, payload []byte, masked bool) []byte {
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	if masked {
+		mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	return frame
+}
+
+// writeFrame sends a client frame
+func writeFrame(t *testing.T, w io.Writer, fin bool, opcode int, payload []byte, masked bool) {
+	t.Helper()
+
+	if _, err := w.Write(clientFrame(fin, opcode, payload, masked)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFrame reads an unmasked server frame
+func readFrame(t *testing.T, r io.Reader) (int, []byte) {
+	t.Helper()
+
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatal("server frames must not be masked")
+	}
+	length := int(header[1] & 0x7F)
+	if length >= 126 {
+		t.Fatalf("unexpected extended length in control frame")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return int(header[0] & 0x0F), payload
+}
+
+func waitResult(t *testing.T, results <-chan result) result {
+	t.Helper()
+
+	select {
+	case r := <-results:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not finish reading")
+		return result{}
+	}
+}
+
+func TestAcceptKey(t *testing.T) {
+	// Example from RFC 6455 section 1.3
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("acceptKey = %q", got)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	_, _, resp, _ := dial(t, http.Header{"Sec-Websocket-Protocol": {"base64, binary"}})
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q", got)
+	}
+	// The server's preference wins over the client's order
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != "binary" {
+		t.Errorf("Sec-WebSocket-Protocol = %q, want binary", got)
+	}
+}
+
+func TestUpgradeRejectsOtherVersions(t *testing.T) {
+	_, _, resp, results := dial(t, http.Header{"Sec-Websocket-Version": {"8"}})
+
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("status = %d, version = %q", resp.StatusCode, resp.Header.Get("Sec-WebSocket-Version"))
+	}
+	if r := waitResult(t, results); r.err == nil {
+		t.Error("expected an upgrade error")
+	}
+}
+
+func TestReadMessageRejectsUnmaskedFrames(t *testing.T) {
+	conn, _, _, results := dial(t, nil)
+
+	writeFrame(t, conn, true, OpBinary, []byte("hello"), false)
+
+	if r := waitResult(t, results); r.err == nil || !strings.Contains(r.err.Error(), "masked") {
+		t.Errorf("err = %v, want an error for the unmasked frame", r.err)
+	}
+}
+
+func TestReadMessageFragmented(t *testing.T) {
+	conn, reader, _, results := dial(t, nil)
+
+	// Control frames may arrive between fragments
+	writeFrame(t, conn, false, OpText, []byte("Hel"), true)
+	writeFrame(t, conn, true, OpPing, []byte("ping"), true)
+	writeFrame(t, conn, true, OpContinuation, []byte("lo"), true)
+
+	if opcode, payload := readFrame(t, reader); opcode != OpPong || string(payload) != "ping" {
+		t.Errorf("got opcode %d with %q, want a pong echoing the ping", opcode, payload)
+	}
+
+	r := waitResult(t, results)
+	if r.err != nil || r.opcode != OpText || string(r.data) != "Hello" {
+		t.Errorf("ReadMessage = %d, %q, %v", r.opcode, r.data, r.err)
+	}
+}
+
+func TestReadMessageRejectsUnexpectedContinuation(t *testing.T) {
+	conn, _, _, results := dial(t, nil)
+
+	writeFrame(t, conn, true, OpContinuation, []byte("lo"), true)
+
+	if r := waitResult(t, results); r.err == nil {
+		t.Error("expected an error for a continuation without a first frame")
+	}
+}
+
+func TestReadMessageOversized(t *testing.T) {
+	t.Run("frame", func(t *testing.T) {
+		conn, _, _, results := dial(t, nil)
+
+		// Only the header is sent, the length alone must be rejected
+		header := []byte{0x80 | OpBinary, 0x80 | 127}
+		header = binary.BigEndian.AppendUint64(header, maxMessageSize+1)
+		if _, err := conn.Write(header); err != nil {
+			t.Fatal(err)
+		}
+
+		if r := waitResult(t, results); r.err == nil || !strings.Contains(r.err.Error(), "too large") {
+			t.Errorf("err = %v, want frame too large", r.err)
+		}
+	})
+
+	t.Run("message", func(t *testing.T) {
+		conn, _, _, results := dial(t, nil)
+
+		half := make([]byte, maxMessageSize/2+1)
+		// The server may stop reading before the second frame is sent
+		go func() {
+			conn.Write(clientFrame(false, OpBinary, half, true))
+			conn.Write(clientFrame(true, OpContinuation, half, true))
+		}()
+
+		if r := waitResult(t, results); r.err == nil || !strings.Contains(r.err.Error(), "too large") {
+			t.Errorf("err = %v, want message too large", r.err)
+		}
+	})
+}
+
+func TestReadMessageClose(t *testing.T) {
+	conn, reader, _, results := dial(t, nil)
+
+	writeFrame(t, conn, true, OpClose, []byte{0x03, 0xE8}, true)
+
+	if r := waitResult(t, results); !errors.Is(r.err, ErrClosed) {
+		t.Errorf("err = %v, want ErrClosed", r.err)
+	}
+	if opcode, payload := readFrame(t, reader); opcode != OpClose || binary.BigEndian.Uint16(payload) != 1000 {
+		t.Errorf("got opcode %d with %v, want the close frame echoed", opcode, payload)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"log"
 	configuration "spark-heimdall/internal/config"
 	"spark-heimdall/internal/heimdall"
@@ -37,11 +38,18 @@ func main() {
 		log.Fatalf("Failed to parse HTML templates: %v", err)
 	}
 
-	server := heimdall.NewServer(configFile, templates)
+	// The browser VNC viewer loads noVNC from here
+	novnc, err := fs.Sub(templateFS, "templates/novnc")
+	if err != nil {
+		log.Fatalf("Failed to load noVNC: %v", err)
+	}
+
+	server := heimdall.NewServer(configFile, templates, novnc)
 	server.SetupRoutes()
 
+	// Start blocks until the server fails or is stopped by a signal
 	err = server.Start()
 	if err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		log.Fatalf("Server stopped with error: %v", err)
 	}
 }